@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	spss "go-spss"
+	"go-spss/convert"
+)
+
+// runConvert converts a file between the SAV, CSV, JSON and NDJSON formats, or from them to
+// Parquet, Arrow, XLSX or Stata. The formats are taken from the file extensions unless -from or -to are given
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "", "input format: sav, csv, json or ndjson, taken from the input extension if empty")
+	to := fs.String("to", "", "output format: sav, csv, json, ndjson, parquet, arrow, xlsx or dta, taken from the output extension if empty")
+	labels := fs.Bool("labels", false, "write value labels instead of values when converting from SAV")
+	dictionary := fs.Bool("dictionary", false, "start NDJSON output with the dictionary of the data")
+	encoding := fs.String("encoding", "", "character encoding of a SAV input, overrides the file's own")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss convert [flags] input output\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected an input and an output file")
+	}
+	input, output := fs.Arg(0), fs.Arg(1)
+	read := spss.WithEncoding(*encoding)
+
+	inFormat, err := convert.Format(*from, input)
+	if err != nil {
+		return fmt.Errorf("%s: %v", input, err)
+	}
+	outFormat, err := convert.Format(*to, output)
+	if err != nil {
+		return fmt.Errorf("%s: %v", output, err)
+	}
+
+	if convert.IsColumnar(inFormat) || inFormat == convert.XLSX || inFormat == convert.DTA {
+		return fmt.Errorf("%s: cannot read %s files", input, inFormat)
+	}
+	if inFormat == convert.Sav && convert.IsStreamed(outFormat) {
+		// stream the rows instead of reading the whole file
+		return writeFile(output, func(w io.Writer) error {
+			opts := convert.SavOptions{Labels: *labels, Dictionary: *dictionary, Encoding: *encoding}
+			_, err := convert.ConvertSav(context.Background(), input, w, outFormat, opts)
+			return err
+		})
+	}
+
+	var t *convert.Table
+	if inFormat == convert.Sav {
+		t, err = convert.ReadSavContext(context.Background(), input, *labels, nil, read)
+	} else {
+		t, err = readTable(input, inFormat)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch outFormat {
+	case convert.Sav:
+		return t.WriteSav(output)
+	case convert.CSV:
+		return writeFile(output, t.WriteCSV)
+	case convert.Parquet, convert.Arrow:
+		return writeFile(output, func(w io.Writer) error {
+			return t.WriteColumnar(w, outFormat)
+		})
+	case convert.XLSX:
+		return writeFile(output, t.WriteXLSX)
+	case convert.DTA:
+		return writeFile(output, t.WriteDTA)
+	case convert.NDJSON:
+		return writeFile(output, func(w io.Writer) error {
+			return t.WriteNDJSON(w, *dictionary)
+		})
+	}
+	return writeFile(output, t.WriteJSON)
+}
+
+func readTable(fileName, format string) (*convert.Table, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var t *convert.Table
+	switch format {
+	case convert.CSV:
+		t, err = convert.ReadCSV(f)
+	case convert.NDJSON:
+		t, err = convert.ReadNDJSON(f)
+	default:
+		t, err = convert.ReadJSON(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return t, nil
+}
+
+// writeFile creates fileName and passes it to write, removing it again when anything fails so
+// no partial output is left behind
+func writeFile(fileName string, write func(w io.Writer) error) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(fileName)
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"reflect"
+
+	spss "go-spss"
+)
+
+var errDifferent = errors.New("files differ")
+
+// runDiff compares two SAV files. Dictionary differences are printed first, followed by the
+// first cells that differ in the variables both files have and the row counts. The rows of both
+// files are read side by side, neither is held in memory
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	max := fs.Int("max", 20, "maximum number of cell differences to print, 0 for all")
+	tolerance := fs.Float64("tolerance", 0, "numbers closer than this are considered equal")
+	dictOnly := fs.Bool("dict", false, "only compare the dictionaries")
+	encoding := fs.String("encoding", "", "character encoding of the SAV files, overrides the files' own")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss diff [flags] a.sav b.sav\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected two SAV files")
+	}
+	read := spss.WithEncoding(*encoding)
+
+	metaA, err := spss.Inspect(fs.Arg(0), read)
+	if err != nil {
+		return err
+	}
+	metaB, err := spss.Inspect(fs.Arg(1), read)
+	if err != nil {
+		return err
+	}
+
+	differences := 0
+	diff := func(format string, a ...interface{}) {
+		differences++
+		fmt.Printf(format+"\n", a...)
+	}
+
+	var common [][2]spss.Variable
+	for _, a := range metaA.Variables {
+		b, ok := metaB.Variable(a.Name)
+		if !ok {
+			diff("- variable %s", a.Name)
+			continue
+		}
+		common = append(common, [2]spss.Variable{a, b})
+		if typeName(a) != typeName(b) {
+			diff("~ variable %s: type %s, %s", a.Name, typeName(a), typeName(b))
+		}
+		if a.Label != b.Label {
+			diff("~ variable %s: label %q, %q", a.Name, a.Label, b.Label)
+		}
+		if a.Format != b.Format {
+			diff("~ variable %s: format %s, %s", a.Name, a.Format, b.Format)
+		}
+		if a.Measure != b.Measure {
+			diff("~ variable %s: measure %s, %s", a.Name, a.Measure, b.Measure)
+		}
+		if !reflect.DeepEqual(a.ValueLabels, b.ValueLabels) {
+			diff("~ variable %s: value labels differ", a.Name)
+		}
+		if missingText(a.Missing) != missingText(b.Missing) {
+			diff("~ variable %s: missing values %s, %s", a.Name, missingText(a.Missing), missingText(b.Missing))
+		}
+	}
+	for _, b := range metaB.Variables {
+		if _, ok := metaA.Variable(b.Name); !ok {
+			diff("+ variable %s", b.Name)
+		}
+	}
+
+	if !*dictOnly {
+		cells := 0
+		rowsA, rowsB, err := readPairs(fs.Arg(0), fs.Arg(1), read, func(i int, rowA, rowB []interface{}) {
+			for _, v := range common {
+				a, b := rowA[v[0].Index], rowB[v[1].Index]
+				if sameValue(a, b, *tolerance) {
+					continue
+				}
+				cells++
+				if *max == 0 || cells <= *max {
+					diff("~ row %d, %s: %s, %s", i+1, v[0].Name, valueText(a), valueText(b))
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if *max > 0 && cells > *max {
+			differences++
+			fmt.Printf("... %d more cell differences\n", cells-*max)
+		}
+		if rowsA != rowsB {
+			diff("~ rows: %d, %d", rowsA, rowsB)
+		}
+	}
+
+	if differences > 0 {
+		return errDifferent
+	}
+	return nil
+}
+
+// readPairs reads the rows of the SAV files a and b side by side, passing fn the rows both files
+// have, and returns the number of rows of each. b is read by a goroutine a few rows ahead of a
+func readPairs(a, b string, read spss.Option, fn func(i int, rowA, rowB []interface{})) (int, int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rowsB := make(chan []interface{}, 64)
+	errB := make(chan error, 1)
+	go func() {
+		defer close(rowsB)
+		_, err := spss.ReadRows(ctx, b, nil, func(row []interface{}) error {
+			select {
+			case rowsB <- append([]interface{}(nil), row...):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, read)
+		errB <- err
+	}()
+
+	nA, nB := 0, 0
+	_, err := spss.ReadRows(ctx, a, nil, func(rowA []interface{}) error {
+		nA++
+		if rowB, ok := <-rowsB; ok {
+			nB++
+			fn(nA-1, rowA, rowB)
+		}
+		return nil
+	}, read)
+	if err != nil {
+		return 0, 0, err
+	}
+	for range rowsB {
+		nB++
+	}
+	if err := <-errB; err != nil {
+		return 0, 0, err
+	}
+	return nA, nB, nil
+}
+
+func sameValue(a, b interface{}, tolerance float64) bool {
+	fa, okA := a.(float64)
+	fb, okB := b.(float64)
+	if okA && okB {
+		return math.Abs(fa-fb) <= tolerance
+	}
+	return a == b
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	spss "go-spss"
+)
+
+// runGen writes a Go struct for the dictionary of a SAV file. It is meant to be used from a
+// go:generate directive, for example
+//
+//	//go:generate go-spss gen -type Survey -o survey_gen.go survey.sav
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	typeName := fs.String("type", "Record", "name of the generated struct")
+	pkg := fs.String("package", os.Getenv("GOPACKAGE"), "package of the generated file, $GOPACKAGE or main if empty")
+	output := fs.String("o", "", "output file, standard output if empty")
+	encoding := fs.String("encoding", "", "character encoding of the SAV file, overrides the file's own")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss gen [flags] file.sav\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single SAV file")
+	}
+	read := spss.WithEncoding(*encoding)
+
+	meta, err := spss.Inspect(fs.Arg(0), read)
+	if err != nil {
+		return err
+	}
+
+	var src bytes.Buffer
+	opts := spss.GenerateOptions{Package: *pkg, TypeName: *typeName, Source: filepath.Base(fs.Arg(0))}
+	if err := spss.Generate(&src, meta, opts); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(src.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(*output, src.Bytes(), 0644)
+}
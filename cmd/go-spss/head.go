@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	spss "go-spss"
+)
+
+// errEnough stops ReadRows once head has the rows it prints
+var errEnough = errors.New("enough rows")
+
+// runHead prints the first rows of a SAV file as a table in the same way as Dataset.Head. Only
+// the rows printed are read
+func runHead(args []string) error {
+	fs := flag.NewFlagSet("head", flag.ExitOnError)
+	n := fs.Int("n", 5, "number of rows to print")
+	vars := fs.String("vars", "", "comma separated variables to print, all if empty")
+	labels := fs.Bool("labels", false, "print value labels instead of values where there is one")
+	encoding := fs.String("encoding", "", "character encoding of the SAV file, overrides the file's own")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss head [flags] file.sav\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single SAV file")
+	}
+	read := spss.WithEncoding(*encoding)
+
+	meta, err := spss.Inspect(fs.Arg(0), read)
+	if err != nil {
+		return err
+	}
+
+	names := meta.Names()
+	if *vars != "" {
+		names = strings.Split(*vars, ",")
+		for i, name := range names {
+			names[i] = strings.TrimSpace(name)
+			if _, ok := meta.Variable(names[i]); !ok {
+				return fmt.Errorf("%s has no variable %s", fs.Arg(0), names[i])
+			}
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader(names)
+
+	columns := make([]spss.Variable, len(names))
+	for i, name := range names {
+		columns[i], _ = meta.Variable(name)
+	}
+	if *n > 0 {
+		_, err = spss.ReadRows(context.Background(), fs.Arg(0), nil, func(values []interface{}) error {
+			row := make([]string, len(columns))
+			for i, v := range columns {
+				row[i] = valueText(values[v.Index])
+				if *labels {
+					if label := v.LabelFor(values[v.Index]); label != "" {
+						row[i] = label
+					}
+				}
+			}
+			table.Append(row)
+			if table.NumLines() >= *n {
+				return errEnough
+			}
+			return nil
+		}, read)
+		if err != nil && !errors.Is(err, errEnough) {
+			return err
+		}
+	}
+
+	caption := fmt.Sprintf("%d Rows(s)\n", table.NumLines())
+	if meta.RowCount >= 0 {
+		caption = fmt.Sprintf("%d of %d Rows(s)\n", table.NumLines(), meta.RowCount)
+	}
+	table.SetCaption(true, caption)
+	table.Render()
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	spss "go-spss"
+)
+
+// runInfo prints the file header and a table of the variables in a SAV file. Only the
+// dictionary is read so it is fast on large files
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	encoding := fs.String("encoding", "", "character encoding of the SAV file, overrides the file's own")
+	labels := fs.Bool("labels", false, "also print the value labels of every variable")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss info [flags] file.sav\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single SAV file")
+	}
+	read := spss.WithEncoding(*encoding)
+
+	meta, err := spss.Inspect(fs.Arg(0), read)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("File:        %s\n", meta.FileName)
+	fmt.Printf("Label:       %s\n", meta.FileLabel)
+	fmt.Printf("Encoding:    %s\n", meta.Encoding)
+	fmt.Printf("Rows:        %d\n", meta.RowCount)
+	fmt.Printf("Variables:   %d\n", meta.VarCount)
+	fmt.Printf("Created:     %s\n", meta.Created.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Compressed:  %t\n", meta.Compressed)
+	fmt.Printf("Version:     %d\n\n", meta.FormatVersion)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"#", "Name", "Type", "Format", "Measure", "Label", "Value labels", "Missing"})
+	for _, v := range meta.Variables {
+		table.Append([]string{
+			strconv.Itoa(v.Index + 1),
+			v.Name,
+			typeName(v),
+			v.Format,
+			v.Measure.String(),
+			v.Label,
+			strconv.Itoa(len(v.ValueLabels)),
+			missingText(v.Missing),
+		})
+	}
+	table.Render()
+
+	if *labels {
+		for _, v := range meta.Variables {
+			if len(v.ValueLabels) == 0 {
+				continue
+			}
+			fmt.Printf("\n%s\n", v.Name)
+			for _, l := range v.ValueLabels {
+				fmt.Printf("  %v = %s\n", valueText(l.Value), l.Label)
+			}
+		}
+	}
+	return nil
+}
+
+func typeName(v spss.Variable) string {
+	if v.Type == spss.ReadstatTypeString {
+		return fmt.Sprintf("string(%d)", v.StorageWidth)
+	}
+	return "numeric"
+}
+
+func missingText(missing []spss.MissingRange) string {
+	parts := make([]string, len(missing))
+	for i, m := range missing {
+		if m.Lo == m.Hi {
+			parts[i] = valueText(m.Lo)
+		} else {
+			parts[i] = valueText(m.Lo) + " thru " + valueText(m.Hi)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// valueText formats a SAV value for display, system missing values are shown as a dot like
+// SPSS does
+func valueText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "."
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,70 @@
+// Command go-spss works with SPSS SAV files from the command line.
+//
+// Usage:
+//
+//	go-spss <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	info      print the dictionary of a SAV file
+//	head      print the first rows of a SAV file as a table
+//	convert   convert between SAV, CSV and JSON files, or to Parquet, Arrow, XLSX and Stata
+//	validate  check a SAV file can be read completely and its dictionary is consistent
+//	diff      compare the dictionaries and data of two SAV files
+//	gen       generate a Go struct from the dictionary of a SAV file
+//
+// Run go-spss <command> -h for the flags of a command.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"info":     {runInfo, "print the dictionary of a SAV file"},
+	"head":     {runHead, "print the first rows of a SAV file as a table"},
+	"convert":  {runConvert, "convert between SAV, CSV and JSON files, or to Parquet, Arrow, XLSX and Stata"},
+	"validate": {runValidate, "check a SAV file can be read completely and its dictionary is consistent"},
+	"diff":     {runDiff, "compare the dictionaries and data of two SAV files"},
+	"gen":      {runGen, "generate a Go struct from the dictionary of a SAV file"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "--help" {
+			fmt.Fprintf(os.Stderr, "go-spss: unknown command %q\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "go-spss %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: go-spss <command> [flags] [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
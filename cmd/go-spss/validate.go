@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	spss "go-spss"
+)
+
+// runValidate reads every row of a SAV file and checks the dictionary agrees with the data.
+// Each problem is printed on its own line and the command fails if there are any
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	labels := fs.Bool("labels", false, "report values of labelled variables that have no value label and are not missing")
+	encoding := fs.String("encoding", "", "character encoding of the SAV file, overrides the file's own")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss validate [flags] file.sav\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single SAV file")
+	}
+	read := spss.WithEncoding(*encoding)
+
+	meta, err := spss.Inspect(fs.Arg(0), read)
+	if err != nil {
+		return err
+	}
+
+	// the rows are read one at a time, counting the values of labelled variables without a label
+	rows := 0
+	unlabelled := make([]map[string]int, len(meta.Variables))
+	_, err = spss.ReadRows(context.Background(), fs.Arg(0), nil, func(row []interface{}) error {
+		rows++
+		if !*labels {
+			return nil
+		}
+		for i, v := range meta.Variables {
+			if len(v.ValueLabels) == 0 || row[i] == nil || v.IsMissing(row[i]) || v.LabelFor(row[i]) != "" {
+				continue
+			}
+			if unlabelled[i] == nil {
+				unlabelled[i] = make(map[string]int)
+			}
+			unlabelled[i][valueText(row[i])]++
+		}
+		return nil
+	}, read)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	report := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if meta.RowCount >= 0 && meta.RowCount != rows {
+		report("header has %d rows, file contains %d", meta.RowCount, rows)
+	}
+	if meta.VarCount != len(meta.Variables) {
+		report("header has %d variables, dictionary contains %d", meta.VarCount, len(meta.Variables))
+	}
+
+	names := make(map[string]string)
+	for _, v := range meta.Variables {
+		// SPSS variable names are not case sensitive
+		key := strings.ToUpper(v.Name)
+		if other, ok := names[key]; ok {
+			report("variable %s: duplicate of %s", v.Name, other)
+		}
+		names[key] = v.Name
+
+		for _, l := range v.ValueLabels {
+			if _, isString := l.Value.(string); isString != (v.Type == spss.ReadstatTypeString) {
+				report("variable %s: value label %q has the wrong type", v.Name, l.Label)
+			}
+		}
+		for _, m := range v.Missing {
+			if _, isString := m.Lo.(string); isString != (v.Type == spss.ReadstatTypeString) {
+				report("variable %s: missing value %s has the wrong type", v.Name, valueText(m.Lo))
+			}
+		}
+	}
+
+	for i, v := range meta.Variables {
+		for value, n := range unlabelled[i] {
+			report("variable %s: value %s has no label (%d rows)", v.Name, value, n)
+		}
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found", fs.Arg(0), len(problems))
+	}
+	fmt.Printf("%s: ok, %d rows, %d variables\n", fs.Arg(0), rows, len(meta.Variables))
+	return nil
+}
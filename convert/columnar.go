@@ -0,0 +1,260 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	spss "go-spss"
+)
+
+// batchRows is the number of rows in each Parquet row group or Arrow record batch
+const batchRows = 64 * 1024
+
+// Keys of the SAV dictionary in the schema and field metadata of Parquet and Arrow files
+const (
+	MetaFileLabel   = "spss.file_label"
+	MetaLabel       = "spss.label"
+	MetaFormat      = "spss.format"
+	MetaMeasure     = "spss.measure"
+	MetaValueLabels = "spss.value_labels" // JSON array of ValueLabel
+	MetaMissing     = "spss.missing"      // JSON array of Missing
+)
+
+// ColumnWriter streams rows into a Parquet or Arrow IPC file, writing a row group or record
+// batch every 65536 rows so only one batch is held in memory. Numeric variables are DOUBLE,
+// FLOAT or, for the integer types, INT64 columns and strings are UTF-8 columns, every column
+// is nullable. The label, format, measure, value labels and missing values of each header are
+// kept as field metadata and the file label as schema metadata, see the Meta keys
+type ColumnWriter struct {
+	builder *array.RecordBuilder
+	rows    int
+	write   func(rec arrow.Record) error
+	close   func() error
+}
+
+// NewColumnWriter starts a Parquet or Arrow file with a column for every header
+func NewColumnWriter(w io.Writer, format, label string, headers []spss.Header) (*ColumnWriter, error) {
+	schema, err := arrowSchema(label, headers)
+	if err != nil {
+		return nil, err
+	}
+	c := &ColumnWriter{}
+	switch format {
+	case Parquet:
+		props := parquet.NewWriterProperties(
+			parquet.WithCompression(compress.Codecs.Snappy),
+			parquet.WithMaxRowGroupLength(batchRows),
+		)
+		fw, err := pqarrow.NewFileWriter(schema, w, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+		if err != nil {
+			return nil, err
+		}
+		c.write, c.close = fw.Write, fw.Close
+	case Arrow:
+		fw, err := ipc.NewFileWriter(w, ipc.WithSchema(schema))
+		if err != nil {
+			return nil, err
+		}
+		c.write, c.close = fw.Write, fw.Close
+	default:
+		return nil, fmt.Errorf("%s is not a columnar format, expected parquet or arrow", format)
+	}
+	c.builder = array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	return c, nil
+}
+
+// IsColumnar reports whether format is written by a ColumnWriter
+func IsColumnar(format string) bool {
+	return format == Parquet || format == Arrow
+}
+
+func arrowSchema(label string, headers []spss.Header) (*arrow.Schema, error) {
+	fields := make([]arrow.Field, len(headers))
+	for i, h := range headers {
+		var typ arrow.DataType
+		switch h.SavType {
+		case spss.ReadstatTypeString:
+			typ = arrow.BinaryTypes.String
+		case spss.ReadstatTypeInt8, spss.ReadstatTypeInt16, spss.ReadstatTypeInt32:
+			typ = arrow.PrimitiveTypes.Int64
+		case spss.ReadstatTypeFloat:
+			typ = arrow.PrimitiveTypes.Float32
+		case spss.ReadstatTypeDouble:
+			typ = arrow.PrimitiveTypes.Float64
+		default:
+			return nil, fmt.Errorf("variable %s: cannot write type %d", h.Name, h.SavType)
+		}
+
+		var keys, values []string
+		add := func(key, value string) {
+			if value != "" {
+				keys, values = append(keys, key), append(values, value)
+			}
+		}
+		add(MetaLabel, h.Label)
+		add(MetaFormat, h.Format)
+		if h.Measure != spss.MeasureUnknown {
+			add(MetaMeasure, h.Measure.String())
+		}
+		if len(h.ValueLabels) > 0 {
+			labels := make([]ValueLabel, len(h.ValueLabels))
+			for j, l := range h.ValueLabels {
+				labels[j] = ValueLabel{l.Value, l.Label}
+			}
+			b, err := json.Marshal(labels)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s: %v", h.Name, err)
+			}
+			add(MetaValueLabels, string(b))
+		}
+		if len(h.Missing) > 0 {
+			missing := make([]Missing, len(h.Missing))
+			for j, m := range h.Missing {
+				missing[j] = Missing{m.Lo, m.Hi}
+			}
+			b, err := json.Marshal(missing)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s: %v", h.Name, err)
+			}
+			add(MetaMissing, string(b))
+		}
+		fields[i] = arrow.Field{Name: h.Name, Type: typ, Nullable: true, Metadata: arrow.NewMetadata(keys, values)}
+	}
+
+	var meta *arrow.Metadata
+	if label != "" {
+		m := arrow.NewMetadata([]string{MetaFileLabel}, []string{label})
+		meta = &m
+	}
+	return arrow.NewSchema(fields, meta), nil
+}
+
+// Write adds a row. Values are float64, float32, int, int64, string or nil for missing, NaN
+// is written as missing too
+func (c *ColumnWriter) Write(row []interface{}) error {
+	fields := c.builder.Fields()
+	if len(row) != len(fields) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(fields))
+	}
+	for i, v := range row {
+		if err := appendValue(fields[i], v); err != nil {
+			return fmt.Errorf("row %d, variable %s: %v", c.rows+1, c.builder.Schema().Field(i).Name, err)
+		}
+	}
+	c.rows++
+	if c.rows%batchRows == 0 {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *ColumnWriter) flush() error {
+	rec := c.builder.NewRecord()
+	defer rec.Release()
+	if rec.NumRows() == 0 {
+		return nil
+	}
+	return c.write(rec)
+}
+
+// Close writes the last batch and the file footer. It does not close the underlying writer
+// unless it is an io.Closer
+func (c *ColumnWriter) Close() error {
+	defer c.builder.Release()
+	err := c.flush()
+	if closeErr := c.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// abort releases the writer after an error, the file is left incomplete
+func (c *ColumnWriter) abort() {
+	c.builder.Release()
+	_ = c.close()
+}
+
+func appendValue(b array.Builder, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	if s, ok := b.(*array.StringBuilder); ok {
+		switch val := v.(type) {
+		case string:
+			s.Append(val)
+		case float64:
+			s.Append(strconv.FormatFloat(val, 'f', -1, 64))
+		case int64:
+			s.Append(strconv.FormatInt(val, 10))
+		default:
+			return fmt.Errorf("unexpected value %T", v)
+		}
+		return nil
+	}
+
+	var f float64
+	switch val := v.(type) {
+	case float64:
+		f = val
+	case float32:
+		f = float64(val)
+	case int:
+		f = float64(val)
+	case int64:
+		if n, ok := b.(*array.Int64Builder); ok {
+			n.Append(val)
+			return nil
+		}
+		f = float64(val)
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(val, 64); err != nil {
+			return fmt.Errorf("%q is not a number", val)
+		}
+	default:
+		return fmt.Errorf("unexpected value %T", v)
+	}
+	if math.IsNaN(f) {
+		b.AppendNull()
+		return nil
+	}
+
+	switch n := b.(type) {
+	case *array.Float64Builder:
+		n.Append(f)
+	case *array.Float32Builder:
+		n.Append(float32(f))
+	case *array.Int64Builder:
+		if f != math.Trunc(f) {
+			return fmt.Errorf("%v is not an integer", f)
+		}
+		n.Append(int64(f))
+	}
+	return nil
+}
+
+// WriteColumnar writes the table as a Parquet or Arrow file
+func (t *Table) WriteColumnar(w io.Writer, format string) error {
+	c, err := NewColumnWriter(w, format, t.Label, t.Headers)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := c.Write(row); err != nil {
+			c.abort()
+			return err
+		}
+	}
+	return c.Close()
+}
@@ -0,0 +1,345 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/xuri/excelize/v2"
+	spss "go-spss"
+)
+
+func Test_convert(t *testing.T) {
+
+	table, err := ReadCSV(strings.NewReader("id,name,score\n1,Ann,3.5\n2,\"Bob, Jr\",\n"))
+	if err != nil {
+		panic(err)
+	}
+	if err := table.WriteSav("test_convert.sav"); err != nil {
+		panic(err)
+	}
+	defer os.Remove("test_convert.sav")
+
+	table, err = ReadSav("test_convert.sav", false)
+	if err != nil {
+		panic(err)
+	}
+
+	var got bytes.Buffer
+	if err := table.WriteJSON(&got); err != nil {
+		panic(err)
+	}
+	want := "[\n  {\"id\": 1, \"name\": \"Ann\", \"score\": 3.5},\n  {\"id\": 2, \"name\": \"Bob, Jr\", \"score\": null}\n]\n"
+	if got.String() != want {
+		t.Errorf("convert returned the wrong rows, got: %s, want: %s.", got.String(), want)
+	}
+
+	// the streamed formats write the same as the table
+	got.Reset()
+	if _, err := ConvertSav(context.Background(), "test_convert.sav", &got, JSON, SavOptions{}); err != nil {
+		panic(err)
+	}
+	if got.String() != want {
+		t.Errorf("ConvertSav wrote the wrong JSON, got: %s, want: %s.", got.String(), want)
+	}
+	got.Reset()
+	if _, err := ConvertSav(context.Background(), "test_convert.sav", &got, CSV, SavOptions{}); err != nil {
+		panic(err)
+	}
+	if want := "id,name,score\n1,Ann,3.5\n2,\"Bob, Jr\",\n"; got.String() != want {
+		t.Errorf("ConvertSav wrote the wrong CSV, got: %q, want: %q.", got.String(), want)
+	}
+
+	if _, err := Format("", "data.sas7bdat"); err == nil {
+		t.Errorf("Format accepted an unsupported format")
+	}
+}
+
+func Test_dictionaryApply(t *testing.T) {
+
+	table, err := ReadJSON(strings.NewReader(`[{"name": "Ann", "code": "01"}, {"name": "Bob", "code": "02"}]`))
+	if err != nil {
+		panic(err)
+	}
+
+	dict := Dictionary{FileLabel: "people", Variables: []Variable{
+		{Name: "code", Label: "Person code", Type: Numeric},
+	}}
+	if err := dict.Apply(table); err != nil {
+		panic(err)
+	}
+
+	if table.Label != "people" || table.Headers[0].Name != "code" || table.Headers[0].SavType != spss.ReadstatTypeDouble {
+		t.Errorf("Apply did not set the dictionary, got: %+v", table.Headers)
+	}
+	if table.Rows[1][0] != 2.0 || table.Rows[1][1] != "Bob" {
+		t.Errorf("Apply did not reorder the rows, got: %v", table.Rows[1])
+	}
+
+	dict.Variables[0].ValueLabels = []ValueLabel{{"01", "First"}}
+	if err := dict.Apply(table); err == nil {
+		t.Errorf("Apply accepted a string value label of a numeric variable")
+	}
+
+	dict.Variables[0].Name = "unknown"
+	if err := dict.Apply(table); err == nil {
+		t.Errorf("Apply accepted a variable that is not in the data")
+	}
+}
+
+func Test_convertSav(t *testing.T) {
+
+	headers := []spss.Header{
+		{SavType: spss.ReadstatTypeDouble, Name: "sex", Label: "Sex", ValueLabels: []spss.ValueLabel{{Value: 1.0, Label: "Male"}}},
+		{SavType: spss.ReadstatTypeString, Name: "name", Label: "Name"},
+	}
+	data := []spss.DataItem{
+		{Value: []interface{}{1.0, "Ann"}},
+		{Value: []interface{}{math.NaN(), "Bob"}},
+	}
+	if err := spss.Export("test_columnar.sav", "people", headers, data); err != nil {
+		panic(err)
+	}
+	defer os.Remove("test_columnar.sav")
+
+	var out bytes.Buffer
+	rows, err := ConvertSav(context.Background(), "test_columnar.sav", &out, Parquet, SavOptions{})
+	if err != nil {
+		panic(err)
+	}
+	if rows != 2 {
+		t.Errorf("ConvertSav wrote the wrong number of rows, got: %d, want: %d.", rows, 2)
+	}
+
+	reader, err := file.NewParquetReader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	fr, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		panic(err)
+	}
+	schema, err := fr.Schema()
+	if err != nil {
+		panic(err)
+	}
+	table, err := fr.ReadTable(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	defer table.Release()
+
+	if label, _ := schema.Metadata().GetValue(MetaFileLabel); label != "people" {
+		t.Errorf("ConvertSav did not keep the file label, got: %q, want: %q.", label, "people")
+	}
+	sex := schema.Field(0)
+	labels, _ := sex.Metadata.GetValue(MetaValueLabels)
+	if sex.Type.ID() != arrow.FLOAT64 || labels != `[{"value":1,"label":"Male"}]` {
+		t.Errorf("ConvertSav wrote the wrong field, got: %s %v", sex.Type, sex.Metadata)
+	}
+	if schema.Field(1).Type.ID() != arrow.STRING {
+		t.Errorf("ConvertSav did not write a string column, got: %s", schema.Field(1).Type)
+	}
+	if nulls := table.Column(0).NullN(); nulls != 1 {
+		t.Errorf("ConvertSav did not write system missing as null, got: %d nulls, want: 1.", nulls)
+	}
+
+	out.Reset()
+	if _, err := ConvertSav(context.Background(), "test_columnar.sav", &out, Arrow, SavOptions{Labels: true}); err != nil {
+		panic(err)
+	}
+	ar, err := ipc.NewFileReader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	defer ar.Close()
+	rec, err := ar.Record(0)
+	if err != nil {
+		panic(err)
+	}
+	if col, ok := rec.Column(0).(*array.String); !ok || col.Value(0) != "Male" || col.Value(1) != "" {
+		t.Errorf("ConvertSav did not write value labels, got: %v", rec.Column(0))
+	}
+}
+
+func Test_convertXLSX(t *testing.T) {
+
+	headers := []spss.Header{
+		{
+			SavType: spss.ReadstatTypeDouble, Name: "sex", Label: "Sex",
+			ValueLabels: []spss.ValueLabel{{Value: 1.0, Label: "Male"}},
+			Missing:     []spss.MissingRange{{Lo: 8.0, Hi: 9.0}},
+		},
+		{SavType: spss.ReadstatTypeString, Name: "name", Label: "Name"},
+	}
+	data := []spss.DataItem{
+		{Value: []interface{}{1.0, "Ann"}},
+		{Value: []interface{}{math.NaN(), "Bob"}},
+	}
+	if err := spss.Export("test_xlsx.sav", "people", headers, data); err != nil {
+		panic(err)
+	}
+	defer os.Remove("test_xlsx.sav")
+
+	var out bytes.Buffer
+	if _, err := ConvertSav(context.Background(), "test_xlsx.sav", &out, XLSX, SavOptions{Labels: true}); err != nil {
+		panic(err)
+	}
+	f, err := excelize.OpenReader(&out)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(DataSheet)
+	if err != nil {
+		panic(err)
+	}
+	want := [][]string{{"sex", "name"}, {"Male", "Ann"}, {"", "Bob"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ConvertSav wrote the wrong data sheet, got: %q, want: %q.", rows, want)
+	}
+	rows, err = f.GetRows(VariablesSheet)
+	if err != nil {
+		panic(err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[1][:2], []string{"sex", "Sex"}) ||
+		rows[1][5] != "1 = Male" || rows[1][6] != "8 thru 9" {
+		t.Errorf("ConvertSav wrote the wrong variables sheet, got: %q.", rows)
+	}
+	if props, err := f.GetDocProps(); err != nil || props.Title != "people" {
+		t.Errorf("ConvertSav did not keep the file label, got: %v.", props)
+	}
+}
+
+func Test_convertDTA(t *testing.T) {
+
+	long := strings.Repeat("a long note, ", 200)
+	headers := []spss.Header{
+		{SavType: spss.ReadstatTypeDouble, Name: "sex", Label: "Sex", Format: "F3.0", ValueLabels: []spss.ValueLabel{{Value: 1.0, Label: "Male"}}},
+		{SavType: spss.ReadstatTypeString, Name: "name", Label: "Name"},
+		{SavType: spss.ReadstatTypeString, Name: "note", Label: "Note"},
+		{SavType: spss.ReadstatTypeDouble, Name: "Q1.a", Label: "Question 1a"},
+	}
+	data := []spss.DataItem{
+		{Value: []interface{}{1.0, "Ann", long, 2.0}},
+		{Value: []interface{}{math.NaN(), "Bob", "", 3.5}},
+	}
+	if err := spss.Export("test_dta.sav", "people", headers, data); err != nil {
+		panic(err)
+	}
+	defer os.Remove("test_dta.sav")
+
+	var out bytes.Buffer
+	if _, err := ConvertSav(context.Background(), "test_dta.sav", &out, DTA, SavOptions{}); err != nil {
+		panic(err)
+	}
+	b := out.Bytes()
+	section := func(tag string) []byte {
+		start := bytes.Index(b, []byte("<"+tag+">")) + len(tag) + 2
+		return b[start : start+bytes.Index(b[start:], []byte("</"+tag+">"))]
+	}
+
+	tags := []string{"<stata_dta>", "<map>", "<variable_types>", "<varnames>", "<sortlist>", "<formats>",
+		"<value_label_names>", "<variable_labels>", "<characteristics>", "<data>", "<strls>", "<value_labels>", "</stata_dta>"}
+	offsets := section("map")
+	for i, tag := range tags {
+		offset := binary.LittleEndian.Uint64(offsets[i*8:])
+		if !bytes.HasPrefix(b[offset:], []byte(tag)) {
+			t.Errorf("ConvertSav wrote the wrong offset of %s, got: %d.", tag, offset)
+		}
+	}
+	if end := binary.LittleEndian.Uint64(offsets[13*8:]); end != uint64(len(b)) {
+		t.Errorf("ConvertSav wrote the wrong end of file offset, got: %d, want: %d.", end, len(b))
+	}
+
+	if k, n := binary.LittleEndian.Uint16(section("K")), binary.LittleEndian.Uint64(section("N")); k != 4 || n != 2 {
+		t.Errorf("ConvertSav wrote the wrong counts, got: %d variables %d rows, want: 4 variables 2 rows.", k, n)
+	}
+	types := make([]uint16, 4)
+	_ = binary.Read(bytes.NewReader(section("variable_types")), binary.LittleEndian, types)
+	if want := []uint16{dtaDouble, 3, dtaStrL, dtaDouble}; !reflect.DeepEqual(types, want) {
+		t.Errorf("ConvertSav wrote the wrong types, got: %v, want: %v.", types, want)
+	}
+	var names []string
+	for i := 0; i < 4; i++ {
+		names = append(names, string(bytes.TrimRight(section("varnames")[i*129:(i+1)*129], "\x00")))
+	}
+	if want := []string{"sex", "name", "note", "Q1_a"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ConvertSav wrote the wrong names, got: %v, want: %v.", names, want)
+	}
+	if format := string(bytes.TrimRight(section("formats")[:57], "\x00")); format != "%3.0f" {
+		t.Errorf("ConvertSav wrote the wrong format, got: %q, want: %%3.0f.", format)
+	}
+
+	rows := section("data")
+	if len(rows) != 2*(8+3+8+8) {
+		t.Fatalf("ConvertSav wrote the wrong data size, got: %d.", len(rows))
+	}
+	if math.Float64frombits(binary.LittleEndian.Uint64(rows)) != 1 || string(rows[8:11]) != "Ann" ||
+		binary.LittleEndian.Uint64(rows[11:]) != 3|1<<16 || math.Float64frombits(binary.LittleEndian.Uint64(rows[19:])) != 2 {
+		t.Errorf("ConvertSav wrote the wrong first row, got: %v.", rows[:27])
+	}
+	if binary.LittleEndian.Uint64(rows[27:]) != 0x7fe0000000000000 || binary.LittleEndian.Uint64(rows[38:]) != 0 {
+		t.Errorf("ConvertSav did not write missing values, got: %v.", rows[27:])
+	}
+	if strls := section("strls"); !bytes.HasPrefix(strls, []byte("GSO")) || !bytes.Contains(strls, []byte(strings.TrimSpace(long)+"\x00")) {
+		t.Errorf("ConvertSav did not write the long string.")
+	}
+	if !bytes.Contains(section("value_labels"), []byte("Male\x00")) || string(section("value_label_names")[:4]) != "sex\x00" {
+		t.Errorf("ConvertSav did not write the value labels.")
+	}
+}
+
+func Test_convertNDJSON(t *testing.T) {
+
+	headers := []spss.Header{
+		{SavType: spss.ReadstatTypeDouble, Name: "sex", Label: "Sex", ValueLabels: []spss.ValueLabel{{Value: 1.0, Label: "Male"}}},
+		{SavType: spss.ReadstatTypeString, Name: "name", Label: "Name"},
+	}
+	data := []spss.DataItem{
+		{Value: []interface{}{1.0, "Ann"}},
+		{Value: []interface{}{math.NaN(), "Bob"}},
+	}
+	if err := spss.Export("test_ndjson.sav", "people", headers, data); err != nil {
+		panic(err)
+	}
+	defer os.Remove("test_ndjson.sav")
+
+	var out bytes.Buffer
+	if _, err := ConvertSav(context.Background(), "test_ndjson.sav", &out, NDJSON, SavOptions{Dictionary: true}); err != nil {
+		panic(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], `{"$dictionary":{"file_label":"people"`) ||
+		lines[1] != `{"sex":1,"name":"Ann"}` || lines[2] != `{"sex":null,"name":"Bob"}` {
+		t.Errorf("ConvertSav wrote the wrong NDJSON, got: %q", out.String())
+	}
+
+	table, err := ReadNDJSON(&out)
+	if err != nil {
+		panic(err)
+	}
+	if table.Label != "people" || table.Headers[0].Label != "Sex" || table.Headers[1].SavType != spss.ReadstatTypeString {
+		t.Errorf("ReadNDJSON did not apply the dictionary, got: %q %v", table.Label, table.Headers)
+	}
+	want := [][]interface{}{{1.0, "Ann"}, {nil, "Bob"}}
+	if !reflect.DeepEqual(table.Rows, want) {
+		t.Errorf("ReadNDJSON read the wrong rows, got: %v, want: %v", table.Rows, want)
+	}
+
+	if _, err := ReadNDJSON(strings.NewReader("{\"a\":1}\n[1]\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ReadNDJSON did not report the bad line, got: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package convert
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	spss "go-spss"
+)
+
+// CSVWriter writes CSV with a header row of variable names. Numbers are written without
+// trailing zeros and missing values are empty cells
+type CSVWriter struct {
+	cw      *csv.Writer
+	headers []spss.Header
+	record  []string
+	rows    int
+}
+
+// NewCSVWriter starts a CSV file with a column for every header
+func NewCSVWriter(w io.Writer, headers []spss.Header) (*CSVWriter, error) {
+	c := &CSVWriter{cw: csv.NewWriter(w), headers: headers, record: make([]string, len(headers))}
+	for i, h := range headers {
+		c.record[i] = h.Name
+	}
+	if err := c.cw.Write(c.record); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write adds a row. Values are float64, float32, int, int64, string or nil for missing, NaN is
+// written as an empty cell too
+func (c *CSVWriter) Write(row []interface{}) error {
+	if len(row) != len(c.headers) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(c.headers))
+	}
+	c.rows++
+	for i, v := range row {
+		switch val := v.(type) {
+		case float64:
+			c.record[i] = ""
+			if !math.IsNaN(val) {
+				c.record[i] = strconv.FormatFloat(val, 'f', -1, 64)
+			}
+		case float32:
+			c.record[i] = ""
+			if !math.IsNaN(float64(val)) {
+				c.record[i] = strconv.FormatFloat(float64(val), 'f', -1, 32)
+			}
+		case int:
+			c.record[i] = strconv.Itoa(val)
+		case int64:
+			c.record[i] = strconv.FormatInt(val, 10)
+		case string:
+			c.record[i] = val
+		case nil:
+			c.record[i] = ""
+		default:
+			return fmt.Errorf("row %d, variable %s: unexpected value %T", c.rows, c.headers[i].Name, v)
+		}
+	}
+	return c.cw.Write(c.record)
+}
+
+// Close writes any buffered rows. It does not close the underlying writer
+func (c *CSVWriter) Close() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+func (c *CSVWriter) abort() {}
+
+// WriteCSV writes the table as CSV with a header row, missing values are empty cells
+func (t *Table) WriteCSV(w io.Writer) error {
+	c, err := NewCSVWriter(w, t.Headers)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := c.Write(row); err != nil {
+			return err
+		}
+	}
+	return c.Close()
+}
@@ -0,0 +1,235 @@
+package convert
+
+import (
+	"fmt"
+
+	spss "go-spss"
+)
+
+// Dictionary is the JSON form of a SAV dictionary
+type Dictionary struct {
+	FileLabel string     `json:"file_label,omitempty"`
+	Encoding  string     `json:"encoding,omitempty"`
+	Rows      int        `json:"rows"`
+	Weight    string     `json:"weight,omitempty"` // name of the numeric variable weighting the rows
+	Variables []Variable `json:"variables"`
+}
+
+// Variable is the JSON form of a variable in a Dictionary. Type is numeric or string
+type Variable struct {
+	Name        string       `json:"name"`
+	Label       string       `json:"label,omitempty"`
+	Type        string       `json:"type"`
+	Width       int          `json:"width,omitempty"`
+	Format      string       `json:"format,omitempty"`
+	Measure     string       `json:"measure,omitempty"`
+	ValueLabels []ValueLabel `json:"value_labels,omitempty"`
+	Missing     []Missing    `json:"missing,omitempty"`
+}
+
+// ValueLabel is the label of a single value, Value is a number or a string
+type ValueLabel struct {
+	Value interface{} `json:"value"`
+	Label string      `json:"label"`
+}
+
+// Missing is a user defined missing value, or a range of them when Lo and Hi differ
+type Missing struct {
+	Lo interface{} `json:"lo"`
+	Hi interface{} `json:"hi"`
+}
+
+// Variable types in a Dictionary
+const (
+	Numeric = "numeric"
+	String  = "string"
+)
+
+// NewDictionary returns the dictionary of a SAV file read with spss.Inspect
+func NewDictionary(meta *spss.Metadata) Dictionary {
+	d := Dictionary{FileLabel: meta.FileLabel, Encoding: meta.Encoding, Rows: meta.RowCount, Weight: meta.Weight, Variables: []Variable{}}
+	for _, v := range meta.Variables {
+		variable := Variable{Name: v.Name, Label: v.Label, Type: Numeric, Format: v.Format, Measure: v.Measure.String()}
+		if v.Type == spss.ReadstatTypeString {
+			variable.Type, variable.Width = String, v.StorageWidth
+		}
+		for _, l := range v.ValueLabels {
+			variable.ValueLabels = append(variable.ValueLabels, ValueLabel{l.Value, l.Label})
+		}
+		for _, m := range v.Missing {
+			variable.Missing = append(variable.Missing, Missing{m.Lo, m.Hi})
+		}
+		d.Variables = append(d.Variables, variable)
+	}
+	return d
+}
+
+// Metadata returns d as the metadata of a SAV file. Values of value labels and missing values
+// are float64 or string as they are decoded from JSON
+func (d Dictionary) Metadata() *spss.Metadata {
+	meta := &spss.Metadata{FileLabel: d.FileLabel, Encoding: d.Encoding, RowCount: d.Rows, VarCount: len(d.Variables), Weight: d.Weight}
+	for i, v := range d.Variables {
+		variable := spss.Variable{
+			Index:        i,
+			Name:         v.Name,
+			Label:        v.Label,
+			Format:       v.Format,
+			Type:         spss.ReadstatTypeDouble,
+			StorageWidth: v.Width,
+			Measure:      parseMeasure(v.Measure),
+		}
+		if v.Type == String {
+			variable.Type = spss.ReadstatTypeString
+		}
+		for _, l := range v.ValueLabels {
+			variable.ValueLabels = append(variable.ValueLabels, spss.ValueLabel{Value: l.Value, Label: l.Label})
+		}
+		for _, m := range v.Missing {
+			variable.Missing = append(variable.Missing, spss.MissingRange{Lo: m.Lo, Hi: m.Hi})
+		}
+		meta.Variables = append(meta.Variables, variable)
+	}
+	return meta
+}
+
+// applyDictionary sets the format, measure, value labels and missing values of v on h, whose
+// type has already been set. Values must be numbers for numeric variables and strings for strings
+func (v Variable) applyDictionary(h *spss.Header) error {
+	isString := h.SavType == spss.ReadstatTypeString
+	if v.Format != "" && !isString {
+		h.Format = v.Format
+	}
+	if v.Measure != "" {
+		h.Measure = parseMeasure(v.Measure)
+		if h.Measure == spss.MeasureUnknown && v.Measure != spss.MeasureUnknown.String() {
+			return fmt.Errorf("dictionary variable %s has unknown measure %q, expected nominal, ordinal or scale", v.Name, v.Measure)
+		}
+	}
+	if v.ValueLabels != nil {
+		h.ValueLabels = nil
+	}
+	for _, l := range v.ValueLabels {
+		value, err := dictionaryValue(l.Value, isString)
+		if err != nil {
+			return fmt.Errorf("dictionary variable %s value label %q: %v", v.Name, l.Label, err)
+		}
+		h.ValueLabels = append(h.ValueLabels, spss.ValueLabel{Value: value, Label: l.Label})
+	}
+	if v.Missing != nil {
+		h.Missing = nil
+	}
+	for _, m := range v.Missing {
+		lo, err := dictionaryValue(m.Lo, isString)
+		if err == nil {
+			m.Hi, err = dictionaryValue(m.Hi, isString)
+		}
+		if err != nil {
+			return fmt.Errorf("dictionary variable %s missing value: %v", v.Name, err)
+		}
+		h.Missing = append(h.Missing, spss.MissingRange{Lo: lo, Hi: m.Hi})
+	}
+	return nil
+}
+
+// dictionaryValue returns a value label or missing value decoded from JSON as the float64 or
+// string a header of a numeric or string variable holds
+func dictionaryValue(value interface{}, isString bool) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if isString {
+			return v, nil
+		}
+	case float64:
+		if !isString {
+			return v, nil
+		}
+	case int:
+		if !isString {
+			return float64(v), nil
+		}
+	}
+	if isString {
+		return nil, fmt.Errorf("%v is not a string", value)
+	}
+	return nil, fmt.Errorf("%v is not a number", value)
+}
+
+func parseMeasure(name string) spss.Measure {
+	for _, m := range []spss.Measure{spss.MeasureNominal, spss.MeasureOrdinal, spss.MeasureScale} {
+		if m.String() == name {
+			return m
+		}
+	}
+	return spss.MeasureUnknown
+}
+
+// Apply sets the file label and the type, label, format, measure, value labels, missing values
+// and weight of every variable in d on t, and puts the variables of d first in the order d lists
+// them. Variables of t that are not in d keep the type they were read with
+func (d Dictionary) Apply(t *Table) error {
+	index := make(map[string]int, len(t.Headers))
+	for i, h := range t.Headers {
+		index[h.Name] = i
+	}
+
+	order := make([]int, 0, len(t.Headers))
+	used := make(map[int]bool)
+	for _, v := range d.Variables {
+		i, ok := index[v.Name]
+		if !ok {
+			return fmt.Errorf("dictionary variable %s is not in the data", v.Name)
+		}
+		if used[i] {
+			return fmt.Errorf("dictionary variable %s is repeated", v.Name)
+		}
+		switch v.Type {
+		case Numeric:
+			if err := t.setNumeric(i); err != nil {
+				return err
+			}
+		case String:
+			t.setString(i)
+		case "":
+		default:
+			return fmt.Errorf("dictionary variable %s has unknown type %q, expected numeric or string", v.Name, v.Type)
+		}
+		if v.Label != "" {
+			t.Headers[i].Label = v.Label
+		}
+		if err := v.applyDictionary(&t.Headers[i]); err != nil {
+			return err
+		}
+		t.Headers[i].Weight = v.Name == d.Weight && t.Headers[i].SavType != spss.ReadstatTypeString
+		order = append(order, i)
+		used[i] = true
+	}
+	for i := range t.Headers {
+		if !used[i] {
+			order = append(order, i)
+		}
+	}
+
+	headers := make([]spss.Header, len(order))
+	for n, i := range order {
+		headers[n] = t.Headers[i]
+	}
+	t.Headers = headers
+	for r, row := range t.Rows {
+		values := make([]interface{}, len(order))
+		for n, i := range order {
+			values[n] = row[i]
+		}
+		t.Rows[r] = values
+	}
+	for r, record := range t.text {
+		text := make([]string, len(order))
+		for n, i := range order {
+			text[n] = record[i]
+		}
+		t.text[r] = text
+	}
+	if d.FileLabel != "" {
+		t.Label = d.FileLabel
+	}
+	return nil
+}
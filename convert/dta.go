@@ -0,0 +1,455 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	spss "go-spss"
+)
+
+// Stata variable types of format 118, strings up to dtaMaxStr bytes have their width as type
+const (
+	dtaStrL   = 32768
+	dtaDouble = 65526
+	dtaMaxStr = 2045
+)
+
+// dtaMissing is the system missing value . of a Stata double, larger values are missing too
+var dtaMissing = math.Float64frombits(0x7fe0000000000000)
+
+// dtaReserved are the words Stata does not allow as variable names
+var dtaReserved = map[string]bool{
+	"_all": true, "_b": true, "byte": true, "_coef": true, "_cons": true, "double": true,
+	"float": true, "if": true, "in": true, "int": true, "long": true, "_n": true, "_N": true,
+	"_pi": true, "_pred": true, "_rc": true, "_skip": true, "strL": true, "using": true, "with": true,
+}
+
+// DTAWriter writes rows to a Stata 14 (format 118) data file. Numeric variables become doubles
+// with their F or COMMA format, strings longer than Stata's 2045 bytes become strLs and names
+// are made valid Stata names. Value labels of numeric variables are kept when every value is
+// an integer, missing values are not. Rows are kept in a temporary file until Close, as the
+// file starts with the row count and the width of every string
+type DTAWriter struct {
+	w       io.Writer
+	label   string
+	headers []spss.Header
+	tmp     *os.File
+	buf     *bufio.Writer
+	rows    int
+	widths  []int   // longest value of each string variable in bytes
+	strls   []int64 // size of the strL blocks of each string variable if it becomes a strL
+}
+
+// NewDTAWriter starts a Stata file with a variable for every header. The file label becomes
+// the data label
+func NewDTAWriter(w io.Writer, label string, headers []spss.Header) (*DTAWriter, error) {
+	if len(headers) > math.MaxUint16 {
+		return nil, fmt.Errorf("%d variables, a Stata file holds at most %d", len(headers), math.MaxUint16)
+	}
+	tmp, err := os.CreateTemp("", "go-spss-dta-*")
+	if err != nil {
+		return nil, err
+	}
+	return &DTAWriter{
+		w:       w,
+		label:   label,
+		headers: headers,
+		tmp:     tmp,
+		buf:     bufio.NewWriter(tmp),
+		widths:  make([]int, len(headers)),
+		strls:   make([]int64, len(headers)),
+	}, nil
+}
+
+// Write adds a row. Values are float64, float32, int, int64, string or nil for missing, NaN is
+// missing too
+func (d *DTAWriter) Write(row []interface{}) error {
+	if len(row) != len(d.headers) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(d.headers))
+	}
+	var b [8]byte
+	for i, v := range row {
+		h := d.headers[i]
+		if h.SavType == spss.ReadstatTypeString {
+			s, ok := v.(string)
+			if !ok && v != nil {
+				return fmt.Errorf("row %d, variable %s: unexpected value %T", d.rows+1, h.Name, v)
+			}
+			if len(s) > d.widths[i] {
+				d.widths[i] = len(s)
+			}
+			if s != "" {
+				d.strls[i] += 3 + 4 + 8 + 1 + 4 + int64(len(s)) + 1
+			}
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+			_, _ = d.buf.Write(b[:4])
+			_, _ = d.buf.WriteString(s)
+			continue
+		}
+
+		f := dtaMissing
+		switch val := v.(type) {
+		case float64:
+			f = val
+		case float32:
+			f = float64(val)
+		case int:
+			f = float64(val)
+		case int64:
+			f = float64(val)
+		case nil:
+		default:
+			return fmt.Errorf("row %d, variable %s: unexpected value %T", d.rows+1, h.Name, v)
+		}
+		switch {
+		case math.IsNaN(f) || math.IsInf(f, 0):
+			f = dtaMissing
+		case f >= dtaMissing && v != nil:
+			return fmt.Errorf("row %d, variable %s: %v is too large for Stata", d.rows+1, h.Name, f)
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		_, _ = d.buf.Write(b[:])
+	}
+	d.rows++
+	return nil
+}
+
+// Close writes the file. It does not close the underlying writer
+func (d *DTAWriter) Close() error {
+	defer d.abort()
+	if err := d.buf.Flush(); err != nil {
+		return err
+	}
+
+	types := make([]int, len(d.headers))
+	names := dtaNames(d.headers)
+	var dict [7]bytes.Buffer
+	vartypes, varnames, sortlist, formats, labelNames, varLabels, valueLabels := &dict[0], &dict[1], &dict[2], &dict[3], &dict[4], &dict[5], &dict[6]
+	rowWidth, strls := 0, int64(0)
+	for i, h := range d.headers {
+		switch {
+		case h.SavType != spss.ReadstatTypeString:
+			types[i] = dtaDouble
+			rowWidth += 8
+		case d.widths[i] > dtaMaxStr:
+			types[i] = dtaStrL
+			rowWidth += 8
+			strls += d.strls[i]
+		default:
+			types[i] = d.widths[i]
+			if types[i] == 0 {
+				types[i] = 1
+			}
+			rowWidth += types[i]
+		}
+		_ = binary.Write(vartypes, binary.LittleEndian, uint16(types[i]))
+		varnames.Write(dtaString(names[i], 129))
+		formats.Write(dtaString(dtaFormat(h, types[i]), 57))
+		labelName := ""
+		if table := dtaValueLabels(names[i], h); table != nil {
+			labelName = names[i]
+			valueLabels.WriteString("<lbl>")
+			valueLabels.Write(table)
+			valueLabels.WriteString("</lbl>")
+		}
+		labelNames.Write(dtaString(labelName, 129))
+		varLabels.Write(dtaString(truncateUTF8(h.Label, 320), 321))
+	}
+	sortlist.Write(make([]byte, 2*(len(d.headers)+1)))
+
+	var header bytes.Buffer
+	header.WriteString("<stata_dta><header><release>118</release><byteorder>LSF</byteorder><K>")
+	_ = binary.Write(&header, binary.LittleEndian, uint16(len(d.headers)))
+	header.WriteString("</K><N>")
+	_ = binary.Write(&header, binary.LittleEndian, uint64(d.rows))
+	header.WriteString("</N><label>")
+	label := truncateUTF8(d.label, 320)
+	_ = binary.Write(&header, binary.LittleEndian, uint16(len(label)))
+	header.WriteString(label)
+	header.WriteString("</label><timestamp>\x11")
+	header.WriteString(time.Now().Format("02 Jan 2006 15:04"))
+	header.WriteString("</timestamp></header>")
+
+	// the map holds the offset of every section, which follow each other in this order
+	sections := []struct {
+		tag  string
+		size int64
+	}{
+		{"map", 14 * 8},
+		{"variable_types", int64(vartypes.Len())},
+		{"varnames", int64(varnames.Len())},
+		{"sortlist", int64(sortlist.Len())},
+		{"formats", int64(formats.Len())},
+		{"value_label_names", int64(labelNames.Len())},
+		{"variable_labels", int64(varLabels.Len())},
+		{"characteristics", 0},
+		{"data", int64(d.rows) * int64(rowWidth)},
+		{"strls", strls},
+		{"value_labels", int64(valueLabels.Len())},
+	}
+	offsets := []uint64{0}
+	offset := int64(header.Len())
+	for _, s := range sections {
+		offsets = append(offsets, uint64(offset))
+		offset += int64(2*len(s.tag)+5) + s.size
+	}
+	offsets = append(offsets, uint64(offset), uint64(offset+int64(len("</stata_dta>"))))
+
+	w := bufio.NewWriter(d.w)
+	_, _ = w.Write(header.Bytes())
+	w.WriteString("<map>")
+	_ = binary.Write(w, binary.LittleEndian, offsets)
+	w.WriteString("</map>")
+	for i := range dict[:6] {
+		w.WriteString("<" + sections[i+1].tag + ">")
+		_, _ = w.Write(dict[i].Bytes())
+		w.WriteString("</" + sections[i+1].tag + ">")
+	}
+	w.WriteString("<characteristics></characteristics><data>")
+	if err := d.each(func(row int, values [][]byte) error {
+		for i, v := range values {
+			switch types[i] {
+			case dtaDouble:
+				_, _ = w.Write(v)
+			case dtaStrL:
+				// strLs are referred to by variable and row, the empty string by zero
+				var ref uint64
+				if len(v) > 0 {
+					ref = uint64(i+1) | uint64(row+1)<<16
+				}
+				_ = binary.Write(w, binary.LittleEndian, ref)
+			default:
+				_, _ = w.Write(v)
+				_, _ = w.Write(make([]byte, types[i]-len(v)))
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	w.WriteString("</data><strls>")
+	if strls > 0 {
+		if err := d.each(func(row int, values [][]byte) error {
+			for i, v := range values {
+				if types[i] != dtaStrL || len(v) == 0 {
+					continue
+				}
+				w.WriteString("GSO")
+				_ = binary.Write(w, binary.LittleEndian, uint32(i+1))
+				_ = binary.Write(w, binary.LittleEndian, uint64(row+1))
+				_ = w.WriteByte(130)
+				_ = binary.Write(w, binary.LittleEndian, uint32(len(v)+1))
+				_, _ = w.Write(v)
+				_ = w.WriteByte(0)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	w.WriteString("</strls><value_labels>")
+	_, _ = w.Write(valueLabels.Bytes())
+	w.WriteString("</value_labels></stata_dta>")
+	return w.Flush()
+}
+
+// each reads the rows back from the temporary file, passing fn the bytes of every value
+func (d *DTAWriter) each(fn func(row int, values [][]byte) error) error {
+	if _, err := d.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(d.tmp)
+	values := make([][]byte, len(d.headers))
+	ends := make([]int, len(d.headers))
+	var b []byte
+	for row := 0; row < d.rows; row++ {
+		// the values of a row are read into one buffer, so values refers to it once it is full
+		b = b[:0]
+		for i, h := range d.headers {
+			n := 8
+			if h.SavType == spss.ReadstatTypeString {
+				var size [4]byte
+				if _, err := io.ReadFull(r, size[:]); err != nil {
+					return err
+				}
+				n = int(binary.LittleEndian.Uint32(size[:]))
+			}
+			start := len(b)
+			b = append(b, make([]byte, n)...)
+			if _, err := io.ReadFull(r, b[start:]); err != nil {
+				return err
+			}
+			ends[i] = len(b)
+		}
+		start := 0
+		for i := range values {
+			values[i] = b[start:ends[i]]
+			start = ends[i]
+		}
+		if err := fn(row, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort removes the temporary file
+func (d *DTAWriter) abort() {
+	_ = d.tmp.Close()
+	_ = os.Remove(d.tmp.Name())
+}
+
+// WriteDTA writes the table as a Stata file, see DTAWriter
+func (t *Table) WriteDTA(w io.Writer) error {
+	d, err := NewDTAWriter(w, t.Label, t.Headers)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := d.Write(row); err != nil {
+			d.abort()
+			return err
+		}
+	}
+	return d.Close()
+}
+
+// dtaNames returns a valid, unique Stata name for every header: at most 32 letters, digits or
+// underscores, not starting with a digit and not a reserved word
+func dtaNames(headers []spss.Header) []string {
+	names := make([]string, len(headers))
+	used := make(map[string]bool, len(headers))
+	for i, h := range headers {
+		name := []rune(strings.Map(func(r rune) rune {
+			if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return '_'
+		}, h.Name))
+		if len(name) == 0 || unicode.IsDigit(name[0]) || dtaReserved[string(name)] || strings.HasPrefix(string(name), "str") && len(name) > 3 && isDigits(string(name[3:])) {
+			name = append([]rune{'_'}, name...)
+		}
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		unique := string(name)
+		for n := 2; used[strings.ToLower(unique)]; n++ {
+			suffix := "_" + strconv.Itoa(n)
+			if len(name)+len(suffix) > 32 {
+				name = name[:32-len(suffix)]
+			}
+			unique = string(name) + suffix
+		}
+		used[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+	return names
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// dtaFormat returns the Stata display format of a variable of type typ, %w.df for an SPSS F
+// format and %w.dfc for COMMA
+func dtaFormat(h spss.Header, typ int) string {
+	switch typ {
+	case dtaStrL:
+		return "%9s"
+	case dtaDouble:
+	default:
+		return "%" + strconv.Itoa(typ) + "s"
+	}
+	format := strings.ToUpper(h.Format)
+	suffix := "f"
+	switch {
+	case strings.HasPrefix(format, "COMMA"):
+		format, suffix = strings.TrimPrefix(format, "COMMA"), "fc"
+	case strings.HasPrefix(format, "F"):
+		format = strings.TrimPrefix(format, "F")
+	default:
+		return "%10.0g"
+	}
+	width, decimals := format, "0"
+	if i := strings.IndexByte(format, '.'); i >= 0 {
+		width, decimals = format[:i], format[i+1:]
+	}
+	if !isDigits(width) || width == "" || !isDigits(decimals) || decimals == "" {
+		return "%10.0g"
+	}
+	return "%" + width + "." + decimals + suffix
+}
+
+// dtaValueLabels returns the value label table called name for the value labels of h, nil when
+// h has none or Stata cannot hold them: strings or values that are not integers
+func dtaValueLabels(name string, h spss.Header) []byte {
+	if h.SavType == spss.ReadstatTypeString || len(h.ValueLabels) == 0 {
+		return nil
+	}
+	values := make([]int32, len(h.ValueLabels))
+	var text bytes.Buffer
+	offsets := make([]int32, len(h.ValueLabels))
+	for i, l := range h.ValueLabels {
+		var f float64
+		switch v := l.Value.(type) {
+		case float64:
+			f = v
+		case int:
+			f = float64(v)
+		case int64:
+			f = float64(v)
+		default:
+			return nil
+		}
+		if f != math.Trunc(f) || f < -2147483647 || f > 2147483620 {
+			return nil
+		}
+		values[i] = int32(f)
+		offsets[i] = int32(text.Len())
+		text.WriteString(truncateUTF8(l.Label, 32000))
+		text.WriteByte(0)
+	}
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, int32(8+8*len(values)+text.Len()))
+	b.Write(dtaString(name, 129))
+	b.Write([]byte{0, 0, 0})
+	_ = binary.Write(&b, binary.LittleEndian, []int32{int32(len(values)), int32(text.Len())})
+	_ = binary.Write(&b, binary.LittleEndian, offsets)
+	_ = binary.Write(&b, binary.LittleEndian, values)
+	b.Write(text.Bytes())
+	return b.Bytes()
+}
+
+// dtaString returns s padded with zeros to n bytes
+func dtaString(s string, n int) []byte {
+	b := make([]byte, n)
+	copy(b, s)
+	return b
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
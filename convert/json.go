@@ -0,0 +1,88 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	spss "go-spss"
+)
+
+// JSONWriter writes a JSON array with an object per row, keyed by variable name in variable
+// order. Every row is written as an element of the array as soon as it is added, the array is
+// opened by the first row and closed by Close
+type JSONWriter struct {
+	w       io.Writer
+	headers []spss.Header
+	keys    [][]byte
+	b       bytes.Buffer
+	rows    int
+}
+
+// NewJSONWriter starts a JSON array of objects with a key for every header
+func NewJSONWriter(w io.Writer, headers []spss.Header) (*JSONWriter, error) {
+	j := &JSONWriter{w: w, headers: headers, keys: make([][]byte, len(headers))}
+	for i, h := range headers {
+		j.keys[i], _ = json.Marshal(h.Name)
+	}
+	return j, nil
+}
+
+// Write adds a row. Values are float64, float32, int, int64, string or nil for missing, NaN is
+// written as null too
+func (j *JSONWriter) Write(row []interface{}) error {
+	if len(row) != len(j.keys) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(j.keys))
+	}
+	j.rows++
+	j.b.Reset()
+	if j.rows == 1 {
+		j.b.WriteString("[")
+	} else {
+		j.b.WriteString(",")
+	}
+	j.b.WriteString("\n  {")
+	for i, v := range row {
+		value, err := jsonValue(v)
+		if err != nil {
+			return fmt.Errorf("row %d, variable %s: %v", j.rows, j.headers[i].Name, err)
+		}
+		if i > 0 {
+			j.b.WriteString(", ")
+		}
+		j.b.Write(j.keys[i])
+		j.b.WriteString(": ")
+		j.b.Write(value)
+	}
+	j.b.WriteString("}")
+	_, err := j.w.Write(j.b.Bytes())
+	return err
+}
+
+// Close ends the array. It does not close the underlying writer
+func (j *JSONWriter) Close() error {
+	end := "\n]\n"
+	if j.rows == 0 {
+		end = "[" + end
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func (j *JSONWriter) abort() {}
+
+// WriteJSON writes an array with an object per row, keeping the variable order of the table.
+// Missing values are null
+func (t *Table) WriteJSON(w io.Writer) error {
+	j, err := NewJSONWriter(w, t.Headers)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := j.Write(row); err != nil {
+			return err
+		}
+	}
+	return j.Close()
+}
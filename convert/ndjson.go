@@ -0,0 +1,191 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	spss "go-spss"
+)
+
+// DictionaryKey is the only key of the object on the optional first line of an NDJSON file,
+// holding the Dictionary of the rows that follow. SAV variable names cannot start with $
+const DictionaryKey = "$dictionary"
+
+// NDJSONWriter writes newline delimited JSON, an object per row keyed by variable name in
+// variable order. Numbers are JSON numbers, strings JSON strings and missing values null
+type NDJSONWriter struct {
+	w    io.Writer
+	keys [][]byte
+	b    bytes.Buffer
+	rows int
+}
+
+// NewNDJSONWriter starts an NDJSON file with a key for every header. When dict is not nil it is
+// written first, as the only value of an object keyed by DictionaryKey
+func NewNDJSONWriter(w io.Writer, headers []spss.Header, dict *Dictionary) (*NDJSONWriter, error) {
+	n := &NDJSONWriter{w: w, keys: make([][]byte, len(headers))}
+	for i, h := range headers {
+		n.keys[i], _ = json.Marshal(h.Name)
+	}
+	if dict != nil {
+		b, err := json.Marshal(map[string]Dictionary{DictionaryKey: *dict})
+		if err != nil {
+			return nil, fmt.Errorf("cannot write dictionary: %v", err)
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// HeaderDictionary returns the Dictionary of a file of rows with headers
+func HeaderDictionary(label string, headers []spss.Header, rows int) Dictionary {
+	d := Dictionary{FileLabel: label, Rows: rows, Variables: make([]Variable, len(headers))}
+	for i, h := range headers {
+		v := Variable{Name: h.Name, Label: h.Label, Type: Numeric, Format: h.Format, Measure: h.Measure.String()}
+		if h.SavType == spss.ReadstatTypeString {
+			v.Type = String
+		}
+		if h.Weight {
+			d.Weight = h.Name
+		}
+		for _, l := range h.ValueLabels {
+			v.ValueLabels = append(v.ValueLabels, ValueLabel{l.Value, l.Label})
+		}
+		for _, m := range h.Missing {
+			v.Missing = append(v.Missing, Missing{m.Lo, m.Hi})
+		}
+		d.Variables[i] = v
+	}
+	return d
+}
+
+// Write adds a row. Values are float64, float32, int, int64, string or nil for missing, NaN
+// is written as null too
+func (n *NDJSONWriter) Write(row []interface{}) error {
+	if len(row) != len(n.keys) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(n.keys))
+	}
+	n.rows++
+	n.b.Reset()
+	n.b.WriteString("{")
+	for i, v := range row {
+		value, err := jsonValue(v)
+		if err != nil {
+			return fmt.Errorf("row %d, variable %s: %v", n.rows, n.keys[i], err)
+		}
+		if i > 0 {
+			n.b.WriteString(",")
+		}
+		n.b.Write(n.keys[i])
+		n.b.WriteString(":")
+		n.b.Write(value)
+	}
+	n.b.WriteString("}\n")
+	_, err := n.w.Write(n.b.Bytes())
+	return err
+}
+
+// jsonValue marshals a value of a row, writing NaN as null
+func jsonValue(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case float64:
+		if math.IsNaN(val) {
+			v = nil
+		}
+	case float32:
+		if math.IsNaN(float64(val)) {
+			v = nil
+		}
+	case nil, string, int, int64:
+	default:
+		return nil, fmt.Errorf("unexpected value %T", v)
+	}
+	return json.Marshal(v)
+}
+
+// Close does nothing, every row is written by Write
+func (n *NDJSONWriter) Close() error {
+	return nil
+}
+
+func (n *NDJSONWriter) abort() {}
+
+// WriteNDJSON writes the table as NDJSON, starting with its dictionary when dictionary is set
+func (t *Table) WriteNDJSON(w io.Writer, dictionary bool) error {
+	var dict *Dictionary
+	if dictionary {
+		d := HeaderDictionary(t.Label, t.Headers, len(t.Rows))
+		dict = &d
+	}
+	n, err := NewNDJSONWriter(w, t.Headers, dict)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := n.Write(row); err != nil {
+			return err
+		}
+	}
+	return n.Close()
+}
+
+// ReadNDJSON reads NDJSON, an object per line, with the variables found as ReadJSON does. When
+// the first line holds a dictionary, see DictionaryKey, it is applied to the table as Apply does
+func ReadNDJSON(in io.Reader) (*Table, error) {
+	r := bufio.NewReader(in)
+	t := &Table{}
+	index := make(map[string]int)
+	var dict *Dictionary
+	first := true
+	for line := 1; ; line++ {
+		raw, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw)) > 0 {
+			var readErr error
+			if first {
+				dict, readErr = NDJSONDictionary(raw)
+			}
+			if readErr == nil && (!first || dict == nil) {
+				readErr = t.addObject(index, raw)
+			}
+			if readErr != nil {
+				return nil, fmt.Errorf("line %d: %v", line, readErr)
+			}
+			first = false
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	t.padRows()
+	t.inferTypes()
+	if dict != nil {
+		if err := dict.Apply(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// NDJSONDictionary returns the dictionary held by the first line of an NDJSON file, nil when
+// the line is a row
+func NDJSONDictionary(raw []byte) (*Dictionary, error) {
+	keys, err := objectKeys(raw)
+	if err != nil || len(keys) != 1 || keys[0] != DictionaryKey {
+		return nil, nil
+	}
+	var header map[string]Dictionary
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("invalid dictionary: %v", err)
+	}
+	d := header[DictionaryKey]
+	return &d, nil
+}
@@ -0,0 +1,83 @@
+package convert
+
+import (
+	"context"
+	"io"
+
+	spss "go-spss"
+)
+
+// SavOptions control how ConvertSav converts a SAV file
+type SavOptions struct {
+	Labels     bool              // variables with value labels become strings holding the label of each value, as with ReadSav
+	Dictionary bool              // NDJSON starts with the dictionary of the file, see DictionaryKey
+	Progress   spss.ProgressFunc // called as rows are read, may be nil
+	Encoding   string            // overrides the character encoding recorded in the file when set
+}
+
+// IsStreamed reports whether ConvertSav writes format, which it does for every format but SAV
+func IsStreamed(format string) bool {
+	switch format {
+	case CSV, JSON, NDJSON, Parquet, Arrow, XLSX, DTA:
+		return true
+	}
+	return false
+}
+
+// rowWriter is implemented by CSVWriter, JSONWriter, NDJSONWriter, ColumnWriter, XLSXWriter and
+// DTAWriter
+type rowWriter interface {
+	Write(row []interface{}) error
+	Close() error
+	abort()
+}
+
+// ConvertSav streams a SAV file into a CSV, JSON, NDJSON, Parquet, Arrow, XLSX or Stata file
+// without reading it into memory, returning the number of rows written
+func ConvertSav(ctx context.Context, fileName string, w io.Writer, format string, opts SavOptions) (int, error) {
+	var read []spss.Option
+	if opts.Encoding != "" {
+		read = append(read, spss.WithEncoding(opts.Encoding))
+	}
+	meta, err := spss.Inspect(fileName, read...)
+	if err != nil {
+		return 0, err
+	}
+	var c rowWriter
+	switch format {
+	case CSV:
+		c, err = NewCSVWriter(w, savHeaders(meta, opts.Labels))
+	case JSON:
+		c, err = NewJSONWriter(w, savHeaders(meta, opts.Labels))
+	case XLSX:
+		// the Variables sheet lists the value labels even when the data shows them
+		c, err = NewXLSXWriter(w, meta.FileLabel, savHeaders(meta, false))
+	case NDJSON:
+		var dict *Dictionary
+		if opts.Dictionary {
+			d := NewDictionary(meta)
+			dict = &d
+		}
+		c, err = NewNDJSONWriter(w, savHeaders(meta, opts.Labels), dict)
+	case DTA:
+		c, err = NewDTAWriter(w, meta.FileLabel, savHeaders(meta, opts.Labels))
+	default:
+		c, err = NewColumnWriter(w, format, meta.FileLabel, savHeaders(meta, opts.Labels))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	values := make([]interface{}, len(meta.Variables))
+	_, err = spss.ReadRows(ctx, fileName, opts.Progress, func(row []interface{}) error {
+		savRow(meta, row, values, opts.Labels)
+		rows++
+		return c.Write(values)
+	}, read...)
+	if err != nil {
+		c.abort()
+		return rows, err
+	}
+	return rows, c.Close()
+}
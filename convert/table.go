@@ -0,0 +1,336 @@
+// Package convert moves rectangular data between SAV, CSV, JSON and NDJSON files, and from them
+// to Parquet, Arrow, Excel and Stata. It is shared by the go-spss command and the conversion service
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	spss "go-spss"
+)
+
+// Supported file formats
+const (
+	Sav     = "sav"
+	CSV     = "csv"
+	JSON    = "json"
+	Parquet = "parquet"
+	Arrow   = "arrow"
+	XLSX    = "xlsx"
+	NDJSON  = "ndjson"
+	DTA     = "dta"
+)
+
+// Format returns the file format called format, or the format of fileName's extension when
+// format is empty
+func Format(format, fileName string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(fileName), ".")
+	}
+	switch strings.ToLower(format) {
+	case "sav", "zsav":
+		return Sav, nil
+	case "csv":
+		return CSV, nil
+	case "json":
+		return JSON, nil
+	case "parquet":
+		return Parquet, nil
+	case "arrow", "feather":
+		return Arrow, nil
+	case "xlsx":
+		return XLSX, nil
+	case "ndjson", "jsonl":
+		return NDJSON, nil
+	case "dta":
+		return DTA, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, expected sav, csv, json, ndjson, parquet, arrow, xlsx or dta", format)
+}
+
+// Table is the file independent form of the data being converted. Values are float64, string
+// or nil for missing
+type Table struct {
+	Label   string
+	Headers []spss.Header
+	Rows    [][]interface{}
+
+	// text holds the cells of a CSV table as they were read, so a column read as numeric can
+	// be made a string again without losing leading zeros or formatting
+	text [][]string
+}
+
+// ReadSav reads a SAV file. With labels set, variables with value labels become strings holding
+// the label of each value
+func ReadSav(fileName string, labels bool) (*Table, error) {
+	return ReadSavContext(context.Background(), fileName, labels, nil)
+}
+
+// ReadSavContext is ReadSav with cancellation, progress reporting and options such as
+// spss.WithEncoding
+func ReadSavContext(ctx context.Context, fileName string, labels bool, progress spss.ProgressFunc, opts ...spss.Option) (*Table, error) {
+	records, meta, err := spss.ReadRecordsContext(ctx, fileName, progress, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Table{Label: meta.FileLabel, Headers: savHeaders(meta, labels)}
+	for _, r := range records {
+		row := make([]interface{}, len(meta.Variables))
+		savRow(meta, r.Values(), row, labels)
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+// savHeaders returns the headers of the variables of a SAV file with their dictionary. With
+// labels set, variables with value labels become strings without value labels or missing values
+func savHeaders(meta *spss.Metadata, labels bool) []spss.Header {
+	headers := make([]spss.Header, len(meta.Variables))
+	for i, v := range meta.Variables {
+		headers[i] = spss.Header{
+			SavType:     spss.ReadstatTypeDouble,
+			Name:        v.Name,
+			Label:       v.Label,
+			Measure:     v.Measure,
+			ValueLabels: v.ValueLabels,
+			Missing:     v.Missing,
+		}
+		switch {
+		case labels && len(v.ValueLabels) > 0:
+			headers[i].SavType = spss.ReadstatTypeString
+			headers[i].ValueLabels, headers[i].Missing = nil, nil
+		case v.Type == spss.ReadstatTypeString:
+			headers[i].SavType = spss.ReadstatTypeString
+		default:
+			headers[i].Format = v.Format
+			headers[i].Weight = v.Name == meta.Weight
+		}
+	}
+	return headers
+}
+
+// savRow copies the values of a row read from a SAV file into values. With labels set, values
+// with a value label are replaced by the label and other values of labelled variables are
+// formatted as strings
+func savRow(meta *spss.Metadata, row, values []interface{}, labels bool) {
+	for i, v := range meta.Variables {
+		values[i] = row[i]
+		if labels && len(v.ValueLabels) > 0 {
+			label := v.LabelFor(row[i])
+			if label == "" {
+				switch value := row[i].(type) {
+				case float64:
+					label = strconv.FormatFloat(value, 'f', -1, 64)
+				case string:
+					label = value
+				}
+			}
+			values[i] = label
+		}
+	}
+}
+
+// ReadCSV reads CSV with a header row. Columns where every non empty cell is a number become
+// numeric variables, empty cells in them are system missing
+func ReadCSV(in io.Reader) (*Table, error) {
+	r := csv.NewReader(bufio.NewReader(in))
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read header: %w", err)
+	}
+
+	t := &Table{}
+	for _, name := range header {
+		t.Headers = append(t.Headers, spss.Header{Name: strings.TrimSpace(name)})
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(record))
+		for i, cell := range record {
+			if cell != "" {
+				row[i] = cell
+			}
+		}
+		t.Rows = append(t.Rows, row)
+		t.text = append(t.text, record)
+	}
+
+	for i := range t.Headers {
+		if err := t.setNumeric(i); err != nil {
+			t.setString(i)
+		}
+	}
+	return t, nil
+}
+
+// ReadJSON reads a JSON array of objects. The variables are the keys of all the objects in the
+// order they first appear, keys missing from an object are missing values
+func ReadJSON(in io.Reader) (*Table, error) {
+	var objects []json.RawMessage
+	if err := json.NewDecoder(in).Decode(&objects); err != nil {
+		return nil, fmt.Errorf("expected an array of objects: %v", err)
+	}
+
+	t := &Table{}
+	index := make(map[string]int)
+	for n, raw := range objects {
+		if err := t.addObject(index, raw); err != nil {
+			return nil, fmt.Errorf("row %d: %v", n+1, err)
+		}
+	}
+	t.padRows()
+	t.inferTypes()
+	return t, nil
+}
+
+// addObject adds the JSON object raw as a row, adding a variable for each key not in index
+func (t *Table) addObject(index map[string]int, raw json.RawMessage) error {
+	keys, err := objectKeys(raw)
+	if err != nil {
+		return err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if _, ok := index[key]; !ok {
+			index[key] = len(t.Headers)
+			t.Headers = append(t.Headers, spss.Header{Name: key})
+		}
+	}
+	row := make([]interface{}, len(t.Headers))
+	for key, value := range object {
+		switch v := value.(type) {
+		case nil, float64, string:
+			row[index[key]] = v
+		case bool:
+			row[index[key]] = 0.0
+			if v {
+				row[index[key]] = 1.0
+			}
+		default:
+			return fmt.Errorf("%s is not a number, string, boolean or null", key)
+		}
+	}
+	t.Rows = append(t.Rows, row)
+	return nil
+}
+
+// padRows gives rows read before the last variable was added a missing value for it
+func (t *Table) padRows() {
+	for i, row := range t.Rows {
+		if len(row) < len(t.Headers) {
+			t.Rows[i] = append(row, make([]interface{}, len(t.Headers)-len(row))...)
+		}
+	}
+}
+
+// objectKeys returns the keys of a JSON object in the order they are written
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("expected an object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// inferTypes makes every column numeric when all its values are numbers or missing, otherwise
+// the column is a string and numbers in it are formatted
+func (t *Table) inferTypes() {
+	for i := range t.Headers {
+		t.Headers[i].SavType = spss.ReadstatTypeDouble
+		for _, row := range t.Rows {
+			if _, ok := row[i].(string); ok {
+				t.Headers[i].SavType = spss.ReadstatTypeString
+				break
+			}
+		}
+		if t.Headers[i].SavType == spss.ReadstatTypeString {
+			t.setString(i)
+		}
+	}
+}
+
+// setString makes column i a string variable, formatting the numbers in it
+func (t *Table) setString(i int) {
+	t.Headers[i].SavType = spss.ReadstatTypeString
+	for n, row := range t.Rows {
+		f, ok := row[i].(float64)
+		switch {
+		case ok && t.text != nil:
+			row[i] = t.text[n][i]
+		case ok:
+			row[i] = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+}
+
+// setNumeric makes column i a numeric variable, every value in it must be a number or missing.
+// On error the column is left partly converted and should be made a string
+func (t *Table) setNumeric(i int) error {
+	for n, row := range t.Rows {
+		s, ok := row[i].(string)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("row %d, variable %s: %q is not a number", n+1, t.Headers[i].Name, s)
+		}
+		row[i] = f
+	}
+	t.Headers[i].SavType = spss.ReadstatTypeDouble
+	return nil
+}
+
+// WriteSav writes the table to a SAV file, missing values become system missing numbers and
+// empty strings
+func (t *Table) WriteSav(fileName string) error {
+	data := make([]spss.DataItem, len(t.Rows))
+	for i, row := range t.Rows {
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			switch {
+			case v != nil:
+				values[j] = v
+			case t.Headers[j].SavType == spss.ReadstatTypeString:
+				values[j] = ""
+			default:
+				values[j] = math.NaN()
+			}
+		}
+		data[i] = spss.DataItem{Value: values}
+	}
+	return spss.Export(fileName, t.Label, t.Headers, data)
+}
@@ -0,0 +1,196 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+	spss "go-spss"
+)
+
+// Names of the sheets of an XLSX file
+const (
+	DataSheet      = "Data"
+	VariablesSheet = "Variables"
+)
+
+// XLSXWriter writes rows to the Data sheet of an Excel workbook and, on Close, describes every
+// variable on the Variables sheet with its label, type, format, measure, value labels and
+// missing values. Rows are streamed to a temporary file, the workbook is written out by Close
+type XLSXWriter struct {
+	w       io.Writer
+	file    *excelize.File
+	data    *excelize.StreamWriter
+	headers []spss.Header
+	rows    int
+	cells   []interface{}
+}
+
+// NewXLSXWriter starts a workbook with a column for every header. The file label becomes the
+// title of the workbook
+func NewXLSXWriter(w io.Writer, label string, headers []spss.Header) (*XLSXWriter, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName(f.GetSheetName(0), DataSheet); err != nil {
+		return nil, err
+	}
+	if label != "" {
+		if err := f.SetDocProps(&excelize.DocProperties{Title: label}); err != nil {
+			return nil, err
+		}
+	}
+	x := &XLSXWriter{w: w, file: f, headers: headers, cells: make([]interface{}, len(headers))}
+
+	var err error
+	if x.data, err = f.NewStreamWriter(DataSheet); err != nil {
+		return nil, err
+	}
+	err = x.data.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]interface{}, len(headers))
+	for i, h := range headers {
+		names[i] = h.Name
+	}
+	if err := x.writeHeader(x.data, names); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// writeHeader writes values in bold as the first row of sw
+func (x *XLSXWriter) writeHeader(sw *excelize.StreamWriter, values []interface{}) error {
+	style, err := x.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		cells[i] = excelize.Cell{StyleID: style, Value: v}
+	}
+	return sw.SetRow("A1", cells)
+}
+
+// Write adds a row. Values are float64, float32, int, int64, string or nil for missing, NaN is
+// written as an empty cell too
+func (x *XLSXWriter) Write(row []interface{}) error {
+	if len(row) != len(x.headers) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(x.headers))
+	}
+	if x.rows+1 >= excelize.TotalRows {
+		return fmt.Errorf("row %d: a sheet holds at most %d rows", x.rows+1, excelize.TotalRows-1)
+	}
+	for i, v := range row {
+		switch val := v.(type) {
+		case float64:
+			if math.IsNaN(val) {
+				v = nil
+			}
+		case float32:
+			v = nil
+			if !math.IsNaN(float64(val)) {
+				v = float64(val)
+			}
+		case nil, string, int, int64:
+		default:
+			return fmt.Errorf("row %d, variable %s: unexpected value %T", x.rows+1, x.headers[i].Name, v)
+		}
+		x.cells[i] = v
+	}
+	x.rows++
+	cell, err := excelize.CoordinatesToCellName(1, x.rows+1)
+	if err != nil {
+		return err
+	}
+	return x.data.SetRow(cell, x.cells)
+}
+
+// Close writes the Variables sheet and the workbook. It does not close the underlying writer
+func (x *XLSXWriter) Close() error {
+	defer x.abort()
+	if err := x.data.Flush(); err != nil {
+		return err
+	}
+	if _, err := x.file.NewSheet(VariablesSheet); err != nil {
+		return err
+	}
+	sw, err := x.file.NewStreamWriter(VariablesSheet)
+	if err != nil {
+		return err
+	}
+	header := []interface{}{"Name", "Label", "Type", "Format", "Measure", "Value labels", "Missing values"}
+	if err := x.writeHeader(sw, header); err != nil {
+		return err
+	}
+	for i, h := range x.headers {
+		typ := "numeric"
+		if h.SavType == spss.ReadstatTypeString {
+			typ = "string"
+		}
+		var measure string
+		if h.Measure != spss.MeasureUnknown {
+			measure = h.Measure.String()
+		}
+		labels := make([]string, len(h.ValueLabels))
+		for j, l := range h.ValueLabels {
+			labels[j] = dictionaryText(l.Value) + " = " + l.Label
+		}
+		missing := make([]string, len(h.Missing))
+		for j, m := range h.Missing {
+			missing[j] = dictionaryText(m.Lo)
+			if m.Hi != m.Lo {
+				missing[j] += " thru " + dictionaryText(m.Hi)
+			}
+		}
+		row := []interface{}{h.Name, h.Label, typ, h.Format, measure, strings.Join(labels, "; "), strings.Join(missing, "; ")}
+		if err := sw.SetRow("A"+strconv.Itoa(i+2), row); err != nil {
+			return err
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	_, err = x.file.WriteTo(x.w)
+	return err
+}
+
+// abort releases the workbook and its temporary files
+func (x *XLSXWriter) abort() {
+	_ = x.file.Close()
+}
+
+// dictionaryText formats a value label or missing value as SPSS displays it, with LO and HI for
+// the ends of open ranges
+func dictionaryText(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		switch {
+		case math.IsInf(val, -1):
+			return "LO"
+		case math.IsInf(val, 1):
+			return "HI"
+		}
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case string:
+		return strconv.Quote(val)
+	}
+	return fmt.Sprint(v)
+}
+
+// WriteXLSX writes the table as an Excel workbook
+func (t *Table) WriteXLSX(w io.Writer) error {
+	x, err := NewXLSXWriter(w, t.Label, t.Headers)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := x.Write(row); err != nil {
+			x.abort()
+			return err
+		}
+	}
+	return x.Close()
+}
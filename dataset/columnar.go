@@ -0,0 +1,76 @@
+package dataset
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	spss "go-spss"
+	"go-spss/convert"
+)
+
+// ToParquet writes the dataset to a Parquet file a row group at a time. INTEGER columns are
+// INT64, FLOAT and DOUBLE columns FLOAT and DOUBLE and TEXT columns UTF-8 strings, NULL stays
+// NULL. The dictionary stored by FromSav is kept as field metadata, see convert.ColumnWriter
+func (d Dataset) ToParquet(fileName string) error {
+	if err := d.toColumnar(fileName, convert.Parquet); err != nil {
+		return fmt.Errorf(" -> ToParquet: %s", err)
+	}
+	return nil
+}
+
+// ToArrow writes the dataset to an Arrow IPC file a record batch at a time, with the same
+// types and metadata as ToParquet
+func (d Dataset) ToArrow(fileName string) error {
+	if err := d.toColumnar(fileName, convert.Arrow); err != nil {
+		return fmt.Errorf(" -> ToArrow: %s", err)
+	}
+	return nil
+}
+
+func (d Dataset) toColumnar(fileName, format string) (err error) {
+	label, names, headers, err := d.savHeaders()
+	if err != nil {
+		return err
+	}
+	types := d.columnMetadata()
+	for i, name := range names {
+		if strings.Contains(strings.ToUpper(types[name]), "INT") {
+			headers[i].SavType = spss.ReadstatTypeInt32
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot open output file: %s", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	out := bufio.NewWriter(f)
+
+	w, err := convert.NewColumnWriter(out, format, label, headers)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(names))
+	err = d.eachRow(names, func(values []interface{}) error {
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = v
+		}
+		return w.Write(row)
+	})
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
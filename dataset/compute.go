@@ -0,0 +1,263 @@
+package dataset
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	spss "go-spss"
+)
+
+// sqlKeywords are the words of an SQLite expression that are not column names
+var sqlKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "is": true, "null": true, "in": true, "between": true,
+	"like": true, "glob": true, "regexp": true, "match": true, "escape": true, "case": true,
+	"when": true, "then": true, "else": true, "end": true, "cast": true, "as": true, "collate": true,
+	"nocase": true, "binary": true, "rtrim": true, "exists": true, "distinct": true, "true": true,
+	"false": true, "integer": true, "int": true, "real": true, "double": true, "float": true,
+	"text": true, "numeric": true, "blob": true, "current_date": true, "current_time": true,
+	"current_timestamp": true,
+}
+
+// Compute sets the column name to the value of the SQLite expression expr for every row, as
+// COMPUTE does in SPSS, for example Compute("Age2", spss.INT, "Age * Age"). The column is added
+// as columnType when it does not exist, otherwise its values are replaced and columnType is
+// ignored. Every column expr refers to must exist
+func (d Dataset) Compute(name string, columnType spss.ColumnTypes, expr string) error {
+	if identifier(name) != name {
+		return fmt.Errorf(" -> Compute: %s is not a valid column name", name)
+	}
+	colLookup := d.columnMetadata()
+	if err := checkExpression(expr, colLookup); err != nil {
+		return fmt.Errorf(" -> Compute: %s", err)
+	}
+	// SQLite reports syntax errors when the statement is prepared, before anything is changed
+	rows, err := d.DB.Query(fmt.Sprintf("select %s from %s limit 0", expr, d.tableName))
+	if err != nil {
+		return fmt.Errorf(" -> Compute: invalid expression %s: %s", expr, err)
+	}
+	_ = rows.Close()
+
+	if err := d.update(name, columnType, colLookup, fmt.Sprintf("update %s set %s = %s", d.tableName, name, expr)); err != nil {
+		return fmt.Errorf(" -> Compute%s", err)
+	}
+	return nil
+}
+
+// Range is a key of a Recode mapping matching the numbers from Lo to Hi, as lo THRU hi does in
+// SPSS RECODE. Use math.Inf for LOWEST and HIGHEST
+type Range struct {
+	Lo float64
+	Hi float64
+}
+
+// Recode sets the column dst to the value mapping gives the value of the column src, as RECODE
+// does in SPSS. Keys are values of src, a string, int, int64 or float64, or a Range of them,
+// for example {Range{18, 64}: "adult"}. Values are a string, int, int64, float64 or nil for
+// NULL. Values are matched before ranges, ranges in order of Lo. Rows with a value that is not
+// mapped keep the value of dst, so they are unchanged when dst is src and NULL when dst is a new
+// column, added as INTEGER, DOUBLE or TEXT to hold the values of mapping
+func (d Dataset) Recode(src, dst string, mapping map[interface{}]interface{}) error {
+	colLookup := d.columnMetadata()
+	srcType, ok := colLookup[src]
+	if !ok {
+		return fmt.Errorf(" -> Recode: column %s does not exist", src)
+	}
+	if identifier(dst) != dst {
+		return fmt.Errorf(" -> Recode: %s is not a valid column name", dst)
+	}
+	if len(mapping) == 0 {
+		return fmt.Errorf(" -> Recode: mapping is empty")
+	}
+	numeric := savHeader(src, srcType).SavType != spss.ReadstatTypeString
+
+	var values []interface{}
+	var ranges []Range
+	kind := reflect.Invalid
+	for key, value := range mapping {
+		switch k := key.(type) {
+		case Range:
+			if !numeric {
+				return fmt.Errorf(" -> Recode: column %s is not numeric, it cannot be recoded by range", src)
+			}
+			ranges = append(ranges, k)
+		case string, int, int64, float64:
+			values = append(values, key)
+		default:
+			return fmt.Errorf(" -> Recode: unexpected key %T", key)
+		}
+		var err error
+		if kind, err = recodeKind(kind, value); err != nil {
+			return fmt.Errorf(" -> Recode: %s", err)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return lessValue(recodeNumber(values[i]), recodeNumber(values[j])) })
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Lo < ranges[j].Lo || ranges[i].Lo == ranges[j].Lo && ranges[i].Hi < ranges[j].Hi
+	})
+
+	if dstType, ok := colLookup[dst]; ok && kind == reflect.String && savHeader(dst, dstType).SavType != spss.ReadstatTypeString {
+		return fmt.Errorf(" -> Recode: column %s is numeric, it cannot hold strings", dst)
+	}
+	columnType := spss.INT
+	if kind != reflect.Invalid {
+		columnType, _ = kindColumnType(kind)
+	}
+
+	var stmt strings.Builder
+	var args []interface{}
+	stmt.WriteString(fmt.Sprintf("update %s set %s = case", d.tableName, dst))
+	for _, v := range values {
+		stmt.WriteString(fmt.Sprintf(" when %s = ? then ?", src))
+		args = append(args, v, mapping[v])
+	}
+	for _, r := range ranges {
+		var conditions []string
+		if !math.IsInf(r.Lo, -1) {
+			conditions = append(conditions, fmt.Sprintf("%s >= ?", src))
+			args = append(args, r.Lo)
+		}
+		if !math.IsInf(r.Hi, 1) {
+			conditions = append(conditions, fmt.Sprintf("%s <= ?", src))
+			args = append(args, r.Hi)
+		}
+		if len(conditions) == 0 {
+			conditions = append(conditions, fmt.Sprintf("%s is not null", src))
+		}
+		stmt.WriteString(fmt.Sprintf(" when %s then ?", strings.Join(conditions, " and ")))
+		args = append(args, mapping[r])
+	}
+	stmt.WriteString(fmt.Sprintf(" else %s end", dst))
+
+	if err := d.update(dst, columnType, colLookup, stmt.String(), args...); err != nil {
+		return fmt.Errorf(" -> Recode%s", err)
+	}
+	return nil
+}
+
+// update adds the column name as columnType when it is not in colLookup and runs the update
+// stmt, dropping the added column again when the update fails
+func (d Dataset) update(name string, columnType spss.ColumnTypes, colLookup columnInfo, stmt string, args ...interface{}) error {
+	_, exists := colLookup[name]
+	if !exists {
+		if err := d.AddColumn(name, columnType); err != nil {
+			return err
+		}
+	}
+	if _, err := d.DB.Exec(stmt, args...); err != nil {
+		if !exists {
+			_ = d.DropColumn(name)
+		}
+		return fmt.Errorf(": cannot update column %s: %s", name, err)
+	}
+	return nil
+}
+
+// recodeKind narrows the kind of the column holding the values of a Recode mapping to one that
+// can hold value as well
+func recodeKind(kind reflect.Kind, value interface{}) (reflect.Kind, error) {
+	switch value.(type) {
+	case nil:
+		return kind, nil
+	case string:
+		return reflect.String, nil
+	case int, int64:
+		if kind == reflect.Invalid {
+			return reflect.Int64, nil
+		}
+		return kind, nil
+	case float64:
+		if kind == reflect.String {
+			return kind, nil
+		}
+		return reflect.Float64, nil
+	}
+	return kind, fmt.Errorf("unexpected value %T", value)
+}
+
+// recodeNumber converts an int key of a Recode mapping to the int64 lessValue orders
+func recodeNumber(key interface{}) interface{} {
+	if n, ok := key.(int); ok {
+		return int64(n)
+	}
+	return key
+}
+
+// checkExpression checks every column the SQLite expression expr refers to is in colLookup.
+// Names followed by ( are functions, names in single quotes strings and names in double quotes
+// or backquotes columns
+func checkExpression(expr string, colLookup columnInfo) error {
+	columns := make(map[string]bool, len(colLookup))
+	for name := range colLookup {
+		columns[strings.ToLower(name)] = true
+	}
+	check := func(name string) error {
+		if !columns[strings.ToLower(name)] {
+			return fmt.Errorf("column %s does not exist", name)
+		}
+		return nil
+	}
+
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			j := i + 1
+			var quoted strings.Builder
+			for ; j < len(runes); j++ {
+				if runes[j] == r {
+					if j+1 < len(runes) && runes[j+1] == r {
+						quoted.WriteRune(r)
+						j++
+						continue
+					}
+					break
+				}
+				quoted.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return fmt.Errorf("unterminated quote in %s", expr)
+			}
+			if r != '\'' {
+				if err := check(quoted.String()); err != nil {
+					return err
+				}
+			}
+			i = j + 1
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			// numbers, with their exponents and hexadecimal digits
+			for i++; i < len(runes) && (isNameRune(runes[i]) || runes[i] == '.'); i++ {
+			}
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for ; j < len(runes) && isNameRune(runes[j]); j++ {
+			}
+			name := string(runes[i:j])
+			if strings.EqualFold(name, "x") && j < len(runes) && runes[j] == '\'' {
+				// a blob such as x'0A'
+				i = j
+				continue
+			}
+			k := j
+			for ; k < len(runes) && unicode.IsSpace(runes[k]); k++ {
+			}
+			if (k == len(runes) || runes[k] != '(') && !sqlKeywords[strings.ToLower(name)] {
+				if err := check(name); err != nil {
+					return err
+				}
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return nil
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,479 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	spss "go-spss"
+)
+
+// CSVOptions control how FromCSVOptions reads a CSV file. The zero value reads comma separated
+// values with a header row and double quotes
+type CSVOptions struct {
+	Comma      rune // field delimiter, ',' when 0
+	Quote      rune // quote character, '"' when 0
+	Comment    rune // lines starting with Comment are skipped, none when 0
+	LazyQuotes bool // quotes may appear in unquoted fields and unescaped in quoted ones
+	TrimSpace  bool // leading and trailing white space is removed from every field
+	NoHeader   bool // the first line is data, columns are named VAR00001... or after the struct fields
+}
+
+// CSVWriteOptions control how ToCSVOptions writes a CSV file. The zero value writes comma
+// separated values with a header row, NULL as an empty field and numbers in the fewest digits
+// that read back exactly
+type CSVWriteOptions struct {
+	Comma       rune   // field delimiter, ',' when 0
+	NoHeader    bool   // no header row of column names is written
+	Null        string // text written for NULL
+	FloatFormat byte   // strconv format of REAL values such as 'f', 'g' with the fewest digits when 0
+	Precision   int    // precision used with FloatFormat, -1 for the fewest digits
+	Labels      bool   // values with a value label in the stored dictionary are written as the label
+}
+
+// CSVError is an error in a CSV file. Line is the line the record starts on and Column the
+// column being read, if known
+type CSVError struct {
+	Line   int
+	Column string
+	Err    error
+}
+
+func (e *CSVError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("line %d, column %s: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *CSVError) Unwrap() error {
+	return e.Err
+}
+
+// FromCSVOptions is FromCSV with options for the delimiter, quoting and header
+func (d *Dataset) FromCSVOptions(fileName string, out interface{}, opts CSVOptions) (dataset Dataset, err error) {
+	return d.logLoad(func(in string, out interface{}) (Dataset, error) {
+		return d.readCSV(in, out, opts)
+	})(fileName, out)
+}
+
+func (d *Dataset) readCSV(in string, out interface{}, opts CSVOptions) (dataset Dataset, err error) {
+
+	var empty Dataset
+
+	var columns []column
+	if out != nil {
+		if columns, err = structColumns(out); err != nil {
+			return empty, fmt.Errorf(" -> FromCSV: %s", err)
+		}
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return empty, fmt.Errorf(" -> FromCSV: cannot open csv file: %s", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// the first pass finds the header and, without a struct, the type of every column
+	r := newCSVReader(f, opts)
+	positions, columns, err := csvSchema(r, columns, opts)
+	if err != nil {
+		return empty, fmt.Errorf(" -> FromCSV: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return empty, fmt.Errorf(" -> FromCSV: cannot rewind csv file: %s", err)
+	}
+
+	_, file := filepath.Split(in)
+	var name = identifier(strings.TrimSuffix(file, filepath.Ext(file)))
+
+	d, er := NewDataset(name, d.logger)
+	if er != nil {
+		return empty, fmt.Errorf(" -> FromCSV: cannot create a new DataSet: %s", er)
+	}
+
+	d.logger.Println("starting CSV file import")
+
+	d.tableMeta = make(map[string]reflect.Kind)
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		if err = d.AddColumn(col.Name, col.Type); err != nil {
+			return empty, fmt.Errorf(" -> FromCSV: cannot create column %s, of type %s", col.Name, col.Type)
+		}
+		d.tableMeta[col.Name] = col.Kind
+		names[i] = col.Name
+	}
+
+	if err := d.loadCSV(newCSVReader(f, opts), positions, columns, names, opts); err != nil {
+		return empty, fmt.Errorf(" -> FromCSV: %w", err)
+	}
+	return *d, nil
+}
+
+// loadCSV inserts the records of r in a single transaction, either every row is loaded or none
+func (d *Dataset) loadCSV(r *csvReader, positions []int, columns []column, names []string, opts CSVOptions) error {
+	if !opts.NoHeader {
+		if _, _, err := r.read(); err != nil {
+			return err
+		}
+	}
+	return d.bulkInsert(names, func(insert func(values []interface{}) error) error {
+		values := make([]interface{}, len(columns))
+		for {
+			record, line, err := r.read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for i, col := range columns {
+				if values[i], err = parseValue(record[positions[i]], col.Kind); err != nil {
+					return &CSVError{Line: line, Column: col.Name, Err: err}
+				}
+			}
+			if err := insert(values); err != nil {
+				return err
+			}
+		}
+	}, nil)
+}
+
+// csvSchema reads the header and returns the position in each record of every column. With no
+// columns from a struct, a column is made for every field and its type is inferred from the
+// whole file: INTEGER when every value is an integer, DOUBLE when every value is a number, TEXT
+// otherwise. Numbers with leading zeros are taken to be codes and kept as text
+func csvSchema(r *csvReader, columns []column, opts CSVOptions) ([]int, []column, error) {
+	header, line, err := r.read()
+	if err == io.EOF {
+		return nil, nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.NoHeader {
+		for i := range header {
+			header[i] = fmt.Sprintf("VAR%05d", i+1)
+		}
+	}
+
+	fields := len(header)
+	positions := make([]int, 0, fields)
+	if columns != nil {
+		index := make(map[string]int)
+		for i, name := range header {
+			index[strings.TrimSpace(name)] = i
+		}
+		for i, col := range columns {
+			pos, ok := index[col.Source]
+			if opts.NoHeader {
+				pos, ok = i, i < fields
+			}
+			if !ok {
+				return nil, nil, &CSVError{Line: line, Err: fmt.Errorf("column %s is not in the header", col.Source)}
+			}
+			positions = append(positions, pos)
+		}
+	} else {
+		for i, name := range uniqueNames(header) {
+			columns = append(columns, column{Name: name, Kind: reflect.Int64, Field: -1})
+			positions = append(positions, i)
+		}
+	}
+
+	// read every record to check the number of fields and infer the column types
+	record, data := header, opts.NoHeader
+	for {
+		if len(record) != fields {
+			return nil, nil, &CSVError{Line: line, Err: fmt.Errorf("record has %d fields, expected %d", len(record), fields)}
+		}
+		for i := range columns {
+			if data && columns[i].Field == -1 {
+				columns[i].Kind = inferKind(columns[i].Kind, record[positions[i]])
+			}
+		}
+		data = true
+		if record, line, err = r.read(); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i := range columns {
+		if columns[i].Field == -1 {
+			columns[i].Type, _ = kindColumnType(columns[i].Kind)
+		}
+	}
+	return positions, columns, nil
+}
+
+// inferKind narrows the kind of a column to one that can hold s as well
+func inferKind(kind reflect.Kind, s string) reflect.Kind {
+	s = strings.TrimSpace(s)
+	if s == "" || kind == reflect.String {
+		return kind
+	}
+	if len(s) > 1 && s[0] == '0' && s[1] >= '0' && s[1] <= '9' {
+		return reflect.String
+	}
+	if kind == reflect.Int64 {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return kind
+		}
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return reflect.Float64
+	}
+	return reflect.String
+}
+
+var (
+	errBareQuote   = errors.New("bare quote in unquoted field")
+	errQuote       = errors.New("extraneous quote in quoted field")
+	errOpenQuote   = errors.New("quoted field is not closed")
+	errInvalidUTF8 = errors.New("invalid UTF-8")
+)
+
+// csvReader reads RFC 4180 records with a configurable delimiter and quote, keeping count of
+// lines so errors can say where they are. Empty lines are skipped
+type csvReader struct {
+	r     *bufio.Reader
+	comma rune
+	quote rune
+	opts  CSVOptions
+	line  int // lines read so far
+}
+
+func newCSVReader(in io.Reader, opts CSVOptions) *csvReader {
+	c := &csvReader{r: bufio.NewReader(in), comma: opts.Comma, quote: opts.Quote, opts: opts}
+	if c.comma == 0 {
+		c.comma = ','
+	}
+	if c.quote == 0 {
+		c.quote = '"'
+	}
+	return c
+}
+
+// readLine returns the next line without its line ending
+func (c *csvReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	c.line++
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if !utf8.ValidString(line) {
+		return "", &CSVError{Line: c.line, Err: errInvalidUTF8}
+	}
+	return line, nil
+}
+
+// read returns the next record and the line it starts on, io.EOF after the last record
+func (c *csvReader) read() ([]string, int, error) {
+	var text string
+	for {
+		var err error
+		if text, err = c.readLine(); err != nil {
+			return nil, 0, err
+		}
+		if text != "" && (c.opts.Comment == 0 || !strings.HasPrefix(text, string(c.opts.Comment))) {
+			break
+		}
+	}
+	line := c.line
+
+	var record []string
+	var field strings.Builder
+	start, quoted, wasQuoted := true, false, false
+	endField := func() {
+		s := field.String()
+		if c.opts.TrimSpace && !wasQuoted {
+			s = strings.TrimSpace(s)
+		}
+		record = append(record, s)
+		field.Reset()
+		start, wasQuoted = true, false
+	}
+
+	for i := 0; ; {
+		if i >= len(text) {
+			if !quoted {
+				endField()
+				return record, line, nil
+			}
+			// a quoted field continues on the next line
+			next, err := c.readLine()
+			if err == io.EOF {
+				return nil, line, &CSVError{Line: line, Err: errOpenQuote}
+			}
+			if err != nil {
+				return nil, line, err
+			}
+			field.WriteByte('\n')
+			text, i = next, 0
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		switch {
+		case quoted && r == c.quote:
+			next, nextSize := utf8.DecodeRuneInString(text[i:])
+			switch {
+			case i < len(text) && next == c.quote:
+				field.WriteRune(c.quote)
+				i += nextSize
+			case i >= len(text) || next == c.comma:
+				quoted = false
+			case c.opts.LazyQuotes:
+				field.WriteRune(r)
+			default:
+				return nil, line, &CSVError{Line: c.line, Err: errQuote}
+			}
+		case quoted:
+			field.WriteRune(r)
+		case r == c.comma:
+			endField()
+		case r == c.quote && start:
+			quoted, wasQuoted, start = true, true, false
+		case r == c.quote && !c.opts.LazyQuotes:
+			return nil, line, &CSVError{Line: c.line, Err: errBareQuote}
+		case c.opts.TrimSpace && start && (r == ' ' || r == '\t'):
+			// leading space before an opening quote
+		default:
+			field.WriteRune(r)
+			start = false
+		}
+	}
+}
+
+// ToCSVOptions is ToCSV with options for the delimiter, header, NULL text, number format and
+// value labels. Fields are quoted as needed
+func (d Dataset) ToCSVOptions(fileName string, opts CSVWriteOptions) (err error) {
+	names := d.columnNames()
+	var labels []map[interface{}]string
+	if opts.Labels {
+		if labels, err = d.valueLabels(names); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf(" -> ToCSV: cannot open output csv file: %s", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf(" -> ToCSV: write to file: %s failed: %s", fileName, closeErr)
+		}
+	}()
+
+	w := csv.NewWriter(f)
+	if opts.Comma != 0 {
+		w.Comma = opts.Comma
+	}
+	if !opts.NoHeader {
+		if err := w.Write(names); err != nil {
+			return fmt.Errorf(" -> ToCSV: write to file: %s failed: %s", fileName, err)
+		}
+	}
+
+	record := make([]string, len(names))
+	err = d.eachRow(names, func(values []interface{}) error {
+		for i, value := range values {
+			var columnLabels map[interface{}]string
+			if labels != nil {
+				columnLabels = labels[i]
+			}
+			record[i] = csvText(value, columnLabels, opts)
+		}
+		return w.Write(record)
+	})
+	if err != nil {
+		return fmt.Errorf(" -> ToCSV: write to file: %s failed: %s", fileName, err)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf(" -> ToCSV: write to file: %s failed: %s", fileName, err)
+	}
+	return nil
+}
+
+// csvText formats a value read from SQLite, or its label when labels has one
+func csvText(value interface{}, labels map[interface{}]string, opts CSVWriteOptions) string {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if label, ok := columnLabel(labels, value); ok {
+		return label
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return opts.Null
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if opts.FloatFormat == 0 {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return strconv.FormatFloat(v, opts.FloatFormat, opts.Precision, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// columnLabel returns the label of value in the value labels of a column
+func columnLabel(labels map[interface{}]string, value interface{}) (string, bool) {
+	if labels == nil {
+		return "", false
+	}
+	if n, ok := value.(int64); ok {
+		value = float64(n)
+	}
+	label, ok := labels[value]
+	return label, ok
+}
+
+// valueLabels returns the value labels of each of the columns names from the stored dictionary,
+// keyed by float64 values for numeric columns and strings for TEXT columns
+func (d Dataset) valueLabels(names []string) ([]map[interface{}]string, error) {
+	meta, err := d.Dictionary()
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	types := d.columnMetadata()
+	labels := make([]map[interface{}]string, len(names))
+	for i, name := range names {
+		v, ok := meta.Variable(name)
+		if !ok || len(v.ValueLabels) == 0 {
+			continue
+		}
+		isString := savHeader(name, types[name]).SavType == spss.ReadstatTypeString
+		labels[i] = make(map[interface{}]string, len(v.ValueLabels))
+		for _, l := range v.ValueLabels {
+			if value, ok := dictionaryValue(l.Value, isString); ok {
+				labels[i][value] = l.Label
+			}
+		}
+	}
+	return labels, nil
+}
@@ -2,6 +2,7 @@ package dataset
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,11 +11,15 @@ import (
 	"github.com/olekukonko/tablewriter"
 	spss "go-spss"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"upper.io/db.v3"
 	"upper.io/db.v3/lib/sqlbuilder"
 	"upper.io/db.v3/sqlite"
 )
@@ -40,6 +45,20 @@ var settings = sqlite.ConnectionURL{
 	},
 }
 
+// OpenStore opens the SQLite database the datasets are kept in, so other tables can be stored
+// alongside them. An empty database opens the default file
+func OpenStore(database string) (sqlbuilder.Database, error) {
+	url := settings
+	if database != "" {
+		url.Database = database
+	}
+	sess, err := sqlite.Open(url)
+	if err != nil {
+		return nil, fmt.Errorf(" -> OpenStore: cannot open database: %s, error: %s", url.Database, err)
+	}
+	return sess, nil
+}
+
 func NewDataset(name string, logger *log.Logger) (*Dataset, error) {
 
 	globalLock.Lock()
@@ -54,6 +73,12 @@ func NewDataset(name string, logger *log.Logger) (*Dataset, error) {
 	conn := sess.Driver().(*sql.DB)
 
 	_, _ = sess.Exec(fmt.Sprintf("drop table if exists %s", name))
+	if err := createDictionaryTables(sess); err != nil {
+		return nil, fmt.Errorf(" -> NewDataset: cannot create dictionary tables: %s", err)
+	}
+	if err := deleteDictionary(sess, name); err != nil {
+		return nil, fmt.Errorf(" -> NewDataset: %s", err)
+	}
 
 	_, err = sess.Exec(fmt.Sprintf("create table %s (Row INTEGER PRIMARY KEY)", name))
 	if err != nil {
@@ -168,6 +193,18 @@ func (d Dataset) orderedColumns() (ordered orderedColumns) {
 	return
 }
 
+// columnNames returns the names of the data columns in table order, without Row
+func (d Dataset) columnNames() []string {
+	ordered := d.orderedColumns()
+	var names []string
+	for i := 0; i < len(ordered); i++ {
+		if ordered[i].Name != "Row" {
+			names = append(names, ordered[i].Name)
+		}
+	}
+	return names
+}
+
 type columnInfo map[string]string
 
 func (d Dataset) columnMetadata() (colLookup columnInfo) {
@@ -193,6 +230,8 @@ func (d Dataset) doesColumnExist(name string) (bool, columnInfo) {
 	return true, colLookup
 }
 
+// Mean returns the mean of col, weighted by the weight of the dataset when it has one, see
+// SetWeight
 func (d Dataset) Mean(col string) (res float64, err error) {
 	ok, colLookup := d.doesColumnExist(col)
 	if !ok {
@@ -203,6 +242,17 @@ func (d Dataset) Mean(col string) (res float64, err error) {
 		return 0.0, errors.New(fmt.Sprintf(" -> Mean: column %s is not numeric", col))
 	}
 
+	weight, err := d.Weight()
+	if err != nil {
+		return 0.0, fmt.Errorf(" -> Mean%s", err)
+	}
+	if weight != "" {
+		if res, err = d.WeightedMean(col, weight); err != nil {
+			return 0.0, fmt.Errorf(" -> Mean%s", err)
+		}
+		return res, nil
+	}
+
 	row, err := d.DB.QueryRow(fmt.Sprintf("select avg(%s) from %s", col, d.tableName))
 	if err != nil {
 		return 0.0, err
@@ -357,6 +407,12 @@ func (d Dataset) DropColumn(column string) (err error) {
 		return fmt.Errorf(" -> DropColumn: Exec() failed: %s", err)
 	}
 
+	// and the column's dictionary entry
+	_, err = d.DB.DeleteFrom(variablesTable).Where(db.Cond{"dataset": d.tableName, "column_name": column}).Exec()
+	if err != nil {
+		return fmt.Errorf(" -> DropColumn: cannot delete variable metadata: %s", err)
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf(" -> DropColumn: Commit() failed: %s", err)
@@ -375,99 +431,9 @@ func (d Dataset) DeleteWhere(where ...interface{}) (err error) {
 	return
 }
 
+// ToCSV writes the dataset to a CSV file with a header row, see ToCSVOptions
 func (d Dataset) ToCSV(fileName string) error {
-	f, err := os.Create(fileName)
-	if err != nil {
-		return fmt.Errorf(" -> ToCSV: cannot open output csv file: %s", err)
-	}
-
-	defer func() {
-		_ = f.Close()
-	}()
-
-	orderedColumns := d.orderedColumns()
-
-	var buffer bytes.Buffer
-	var keys []string
-	for i := 0; i < len(orderedColumns); i++ {
-		if orderedColumns[i].Name != "Row" {
-			keys = append(keys, orderedColumns[i].Name)
-		}
-	}
-
-	for i := 0; i < len(keys); i++ {
-		j := fmt.Sprintf("%s", keys[i])
-		buffer.WriteString(j)
-		if i != len(keys)-1 {
-			buffer.WriteString(",")
-		} else {
-			buffer.WriteString("\n")
-		}
-	}
-
-	q := buffer.String()
-
-	_, err = f.WriteString(q)
-	if err != nil {
-		return fmt.Errorf(" -> ToCSV: write to file: %s failed: %s", fileName, err)
-	}
-
-	col := d.DB.Collection(d.tableName)
-	res := col.Find()
-
-	defer func() {
-		_ = res.Close()
-	}()
-
-	var dat map[string]interface{}
-
-	for res.Next(&dat) {
-		buffer.Reset()
-
-		orderedColumns := d.orderedColumns()
-		var keys []string
-		for i := 0; i < len(orderedColumns); i++ {
-			if orderedColumns[i].Name != "Row" {
-				keys = append(keys, orderedColumns[i].Name)
-			}
-		}
-
-		for i := 0; i < len(keys); i++ {
-			kind := d.tableMeta[keys[i]]
-			value := dat[keys[i]]
-
-			switch kind {
-			case reflect.String:
-				buffer.WriteString(fmt.Sprintf("%s", value))
-			case reflect.Int8, reflect.Uint8:
-				buffer.WriteString(fmt.Sprintf("%d", value))
-			case reflect.Int, reflect.Int32, reflect.Uint32:
-				buffer.WriteString(fmt.Sprintf("%d", value))
-			case reflect.Int64, reflect.Uint64:
-				buffer.WriteString(fmt.Sprintf("%d", value))
-			case reflect.Float32:
-				buffer.WriteString(fmt.Sprintf("%f", value))
-			case reflect.Float64:
-				buffer.WriteString(fmt.Sprintf("%g", value))
-			default:
-				return fmt.Errorf(" -> ToCSV: unknown type - possible corruption")
-			}
-			if i != len(keys)-1 {
-				buffer.WriteString(",")
-			} else {
-				buffer.WriteString("\n")
-			}
-		}
-
-		q := buffer.String()
-
-		_, err = f.WriteString(q)
-		if err != nil {
-			return fmt.Errorf(" -> ToCSV: write to file: %s failed: %s", fileName, err)
-		}
-	}
-
-	return nil
+	return d.ToCSVOptions(fileName, CSVWriteOptions{})
 }
 
 type fromFileFunc func(fileName string, out interface{}) (dataset Dataset, err error)
@@ -482,15 +448,17 @@ func (d *Dataset) logLoad(from fromFileFunc) fromFileFunc {
 	}
 }
 
+// FromCSV loads a CSV file with a header row into a new Dataset named after the file. The
+// columns are the fields of the struct out, named by their spss tags, or with a nil out every
+// column of the file with its type inferred from the values
 func (d *Dataset) FromCSV(fileName string, out interface{}) (dataset Dataset, err error) {
-	return d.logLoad(d.readCSV)(fileName, out)
-}
-
-// TODO: Implement Me
-func (d *Dataset) readCSV(in string, out interface{}) (dataset Dataset, err error) {
-	return Dataset{}, nil
+	return d.FromCSVOptions(fileName, out, CSVOptions{})
 }
 
+// FromSav loads a SAV file into a new Dataset named after the file. With a struct out the
+// columns are its fields, read from the variables named by their spss tags. With a nil out
+// every variable becomes a column, numeric variables DOUBLE and strings TEXT. Either way the
+// dictionary of the loaded variables is stored with the dataset, see Dictionary
 func (d *Dataset) FromSav(fileName string, out interface{}) (dataset Dataset, err error) {
 	return d.logLoad(d.readSav)(fileName, out)
 }
@@ -499,22 +467,48 @@ func (d *Dataset) readSav(in string, out interface{}) (dataset Dataset, err erro
 
 	var empty Dataset
 
-	// ensure out is a struct
-	if reflect.ValueOf(out).Kind() != reflect.Struct {
-		return empty, fmt.Errorf(" -> FromSav: %T is not a struct type", out)
+	var columns []column
+	if out != nil {
+		if columns, err = structColumns(out); err != nil {
+			return empty, fmt.Errorf(" -> FromSav: %s", err)
+		}
 	}
 
-	spssRows, err := spss.Import(in)
+	meta, err := spss.Inspect(in)
 	if err != nil {
 		return empty, err
 	}
-	if len(spssRows) == 0 {
+	if meta.RowCount == 0 {
 		return empty, fmt.Errorf(" -> FromSav: spss file: %s is empty", in)
 	}
 
+	// variables holds the index in the file of the variable read into each column
+	variables := make([]int, 0, len(meta.Variables))
+	if columns == nil {
+		for i, name := range uniqueNames(meta.Names()) {
+			col := column{Name: name, Kind: reflect.Float64, Type: spss.DOUBLE, Field: -1}
+			if meta.Variables[i].Type == spss.ReadstatTypeString {
+				col.Kind, col.Type = reflect.String, spss.STRING
+			}
+			columns = append(columns, col)
+			variables = append(variables, i)
+		}
+	} else {
+		index := make(map[string]int, len(meta.Variables))
+		for i, v := range meta.Variables {
+			index[v.Name] = i
+		}
+		for _, col := range columns {
+			i, ok := index[col.Source]
+			if !ok {
+				return empty, fmt.Errorf(" -> FromSav: variable %s is not in %s", col.Source, in)
+			}
+			variables = append(variables, i)
+		}
+	}
+
 	_, file := filepath.Split(in)
-	var extension = filepath.Ext(file)
-	var name = file[0 : len(file)-len(extension)]
+	var name = identifier(strings.TrimSuffix(file, filepath.Ext(file)))
 
 	d, er := NewDataset(name, d.logger)
 	if er != nil {
@@ -523,71 +517,147 @@ func (d *Dataset) readSav(in string, out interface{}) (dataset Dataset, err erro
 
 	d.logger.Println("starting SAV file import")
 
-	tx, err := d.DB.NewTx(nil)
+	d.tableMeta = make(map[string]reflect.Kind)
+	names := make([]string, len(columns))
+	dictionary := make(map[string]string, len(columns))
+	for i, col := range columns {
+		if err = d.AddColumn(col.Name, col.Type); err != nil {
+			return empty, fmt.Errorf(" -> FromSav: cannot create column %s, of type %s", col.Name, col.Type)
+		}
+		d.tableMeta[col.Name] = col.Kind
+		names[i] = col.Name
+		dictionary[meta.Variables[variables[i]].Name] = col.Name
+	}
+
+	// the rows are inserted as they are read, the file is never held in memory
+	err = d.bulkInsert(names, func(insert func(values []interface{}) error) error {
+		n := 0
+		values := make([]interface{}, len(columns))
+		_, err := spss.ReadRows(context.Background(), in, nil, func(row []interface{}) error {
+			n++
+			for i, col := range columns {
+				v, err := savValue(row[variables[i]], col.Kind)
+				if err != nil {
+					return fmt.Errorf("row %d, variable %s: %s", n, meta.Variables[variables[i]].Name, err)
+				}
+				values[i] = v
+			}
+			return insert(values)
+		})
+		if err == nil && n == 0 {
+			err = fmt.Errorf("spss file: %s is empty", in)
+		}
+		return err
+	}, func(tx sqlbuilder.Tx) error {
+		return d.saveDictionary(tx, meta, dictionary)
+	})
 	if err != nil {
-		return empty, fmt.Errorf(" -> FromSav: cannot create a transaction: %s", err)
+		return empty, fmt.Errorf(" -> FromSav: %s", err)
 	}
 
-	t1 := reflect.TypeOf(out)
-
-	d.tableMeta = make(map[string]reflect.Kind)
-
-	for i := 0; i < t1.NumField(); i++ {
-		a := t1.Field(i)
-		d.tableMeta[a.Name] = a.Type.Kind()
-
-		var spssType spss.ColumnTypes
+	return *d, nil
+}
 
-		switch a.Type.Kind() {
+// savValue converts a value read from a SAV file, a float64, string or nil, to the value stored
+// in a column of kind
+func savValue(value interface{}, kind reflect.Kind) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		if kind == reflect.String {
+			return "", nil
+		}
+		return nil, nil
+	case string:
+		return parseValue(v, kind)
+	case float64:
+		switch kind {
 		case reflect.String:
-			spssType = spss.STRING
-		case reflect.Int8, reflect.Uint8:
-			spssType = spss.INT
-		case reflect.Int, reflect.Int32, reflect.Uint32:
-			spssType = spss.INT
-		case reflect.Int64, reflect.Uint64:
-			spssType = spss.INT
-		case reflect.Float32:
-			spssType = spss.FLOAT
-		case reflect.Float64:
-			spssType = spss.DOUBLE
-		default:
-			return empty, fmt.Errorf(" -> FromSav: cannot convert struct variable type from SPSS type")
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case reflect.Float32, reflect.Float64:
+			return v, nil
+		}
+		// non integer values are kept as they are, SQLite stores them as REAL
+		if v == math.Trunc(v) {
+			return int64(v), nil
 		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected value %T", value)
+}
 
-		err = d.AddColumn(a.Name, spssType)
+// bulkInsert inserts rows into the columns names in a single transaction, so either every row
+// is loaded or none. rows passes the values of every row to insert, which is done with them
+// when it returns. after, when not nil, runs in the same transaction once the rows are inserted
+func (d Dataset) bulkInsert(names []string, rows func(insert func(values []interface{}) error) error, after func(tx sqlbuilder.Tx) error) (err error) {
+	tx, err := d.DB.NewTx(nil)
+	if err != nil {
+		return fmt.Errorf("cannot create a transaction: %s", err)
+	}
+	defer func() {
 		if err != nil {
-			return empty, fmt.Errorf(" -> FromSav: cannot create column %s, of type %s", name, spssType)
+			_ = tx.Rollback()
 		}
-	}
+	}()
 
-	headers := spssRows[0]
-	body := spssRows[1:]
-	for _, spssRow := range body {
-		row := make(map[string]interface{})
+	stmt, err := tx.Prepare(fmt.Sprintf("insert into %s (%s) values (?%s)",
+		d.tableName, strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1)))
+	if err != nil {
+		return fmt.Errorf("cannot prepare insert: %s", err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
 
-		for j := 0; j < len(spssRow)-1; j++ {
-			if len(spssRow) != len(headers) {
-				return empty, fmt.Errorf(" -> FromSav: header is out of alignment with row. row size: %d, column size: %d\n", len(spssRow), len(headers))
-			}
-			header := headers[j]
-			// extract the columns we are interested in
-			if _, ok := d.tableMeta[headers[j]]; !ok {
-				continue
-			}
-			row[header] = spssRow[j]
+	err = rows(func(values []interface{}) error {
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("cannot insert row: %s", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		err = d.Insert(row)
-		if err != nil {
-			return empty, fmt.Errorf(" -> FromSav: cannot create row: %s", err)
+	if after != nil {
+		if err := after(tx); err != nil {
+			return err
 		}
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction failed: %s", err)
+	}
+	return nil
+}
 
-	err = tx.Commit()
+// eachRow passes the values of the columns names of every row to fn in row order, as returned
+// by SQLite: int64, float64, string, []byte or nil. values is reused for the next row
+func (d Dataset) eachRow(names []string, fn func(values []interface{}) error) error {
+	if len(names) == 0 {
+		return nil
+	}
+	rows, err := d.DB.Query(fmt.Sprintf("select %s from %s order by Row", strings.Join(names, ", "), d.tableName))
 	if err != nil {
-		return empty, fmt.Errorf(" -> FromSav: commit transaction failed: %s", err)
+		return fmt.Errorf("cannot read table: %s", err)
 	}
+	defer func() {
+		_ = rows.Close()
+	}()
 
-	return *d, nil
+	values := make([]interface{}, len(names))
+	pointers := make([]interface{}, len(names))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("cannot read row: %s", err)
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot read table: %s", err)
+	}
+	return nil
 }
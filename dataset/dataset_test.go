@@ -1,10 +1,26 @@
 package dataset
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	spss "go-spss"
+	"go-spss/convert"
+	"io/ioutil"
 	"log"
+	"math"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/xuri/excelize/v2"
+	"upper.io/db.v3"
 )
 
 func setupTable(logger *log.Logger) (dataset *Dataset, err error) {
@@ -188,3 +204,920 @@ func TestWriteCSV(t *testing.T) {
 	t.Logf("Dataset Size: %d\n", dataset.NumRows())
 	_ = dataset.Head(5)
 }
+
+func TestReadCSV(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+
+	data := "Name;Code;Age;Score\n" +
+		"\"Boss; Lady\";01;42;10.5\n" +
+		"\n" +
+		"\"Thorny \"\"El\"\"\nthe second\";02;;11\n"
+	if err := ioutil.WriteFile("people.csv", []byte(data), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove("people.csv")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromCSVOptions("people.csv", nil, CSVOptions{Comma: ';'})
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	cols := dataset.columnMetadata()
+	if cols["Name"] != string(spss.STRING) || cols["Code"] != string(spss.STRING) || cols["Age"] != string(spss.INT) || cols["Score"] != string(spss.DOUBLE) {
+		t.Errorf("FromCSV inferred the wrong column types, got: %v", cols)
+	}
+	if rows := dataset.NumRows(); rows != 2 {
+		t.Errorf("FromCSV loaded the wrong number of rows, got: %d, want: %d.", rows, 2)
+	}
+	var name string
+	row, _ := dataset.DB.QueryRow("select Name from people where Code = '02'")
+	_ = row.Scan(&name)
+	if name != "Thorny \"El\"\nthe second" {
+		t.Errorf("FromCSV read a quoted field wrongly, got: %q", name)
+	}
+
+	type Person struct {
+		Name   string  `spss:"Name"`
+		Years  int     `spss:"Age"`
+		Score  float64 `spss:"Score"`
+		Ignore string  `spss:"-"`
+	}
+	dataset, err = d.FromCSVOptions("people.csv", Person{}, CSVOptions{Comma: ';'})
+	if err != nil {
+		logger.Panic(err)
+	}
+	if cols := dataset.columnMetadata(); len(cols) != 4 || cols["Age"] != string(spss.INT) {
+		t.Errorf("FromCSV did not use the struct's spss tags, got: %v", cols)
+	}
+
+	if err := ioutil.WriteFile("pay.csv", []byte("Row,Net pay\n1,250.5\n2,300\n"), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove("pay.csv")
+	dataset, err = d.FromCSV("pay.csv", nil)
+	if err != nil {
+		t.Fatalf("FromCSV of a file with a Row column failed: %v", err)
+	}
+	if cols := dataset.columnMetadata(); cols["Row_2"] != string(spss.INT) || cols["Net_pay"] != string(spss.DOUBLE) {
+		t.Errorf("FromCSV did not rename the Row and Net pay columns, got: %v", cols)
+	}
+	type Pay struct {
+		Row int     `spss:"Row"`
+		Net float64 `spss:"Net pay"`
+	}
+	dataset, err = d.FromCSV("pay.csv", Pay{})
+	if err != nil {
+		t.Fatalf("FromCSV of a struct tagged Row and Net pay failed: %v", err)
+	}
+	var pay []Pay
+	if err := dataset.Select().All(&pay); err != nil || len(pay) != 2 || pay[1] != (Pay{2, 300}) {
+		t.Errorf("the struct tagged Row and Net pay was not read back, got: %v %v", pay, err)
+	}
+
+	bad := "Name,Age,Score\nAnn,1,2\nBob,x,3\n"
+	if err := ioutil.WriteFile("bad.csv", []byte(bad), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove("bad.csv")
+
+	_, err = d.FromCSV("bad.csv", Person{})
+	var csvErr *CSVError
+	if !errors.As(err, &csvErr) || csvErr.Line != 3 || csvErr.Column != "Age" {
+		t.Errorf("FromCSV returned the wrong error, got: %v, want: line 3, column Age.", err)
+	}
+	if dataset.NumRows() != 2 {
+		t.Errorf("a failed FromCSV changed another dataset")
+	}
+	var count int
+	row, _ = d.DB.QueryRow("select count(*) from bad")
+	_ = row.Scan(&count)
+	if count != 0 {
+		t.Errorf("a failed FromCSV loaded rows, got: %d, want: 0.", count)
+	}
+}
+
+func writeTestSav(fileName string) {
+	headers := []spss.Header{
+		{SavType: spss.ReadstatTypeDouble, Name: "Serial", Label: "Serial number", Format: "F6.0"},
+		{
+			SavType: spss.ReadstatTypeString, Name: "Version", Label: "Questionnaire version",
+			ValueLabels: []spss.ValueLabel{{Value: "v1", Label: "First version"}},
+		},
+		{
+			SavType: spss.ReadstatTypeDouble, Name: "Weight", Label: "Design weight", Measure: spss.MeasureScale,
+			Missing: []spss.MissingRange{{Lo: -9.0, Hi: -1.0}}, Weight: true,
+		},
+	}
+	data := []spss.DataItem{
+		{Value: []interface{}{1.0, "v1", 1.5}},
+		{Value: []interface{}{2.0, "v2", 0.5}},
+		{Value: []interface{}{3.0, "v1", math.NaN()}},
+	}
+	if err := spss.Export(fileName, "survey", headers, data); err != nil {
+		panic(err)
+	}
+}
+
+func TestFromSavDictionary(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	cols := dataset.columnMetadata()
+	if len(cols) != 4 || cols["Serial"] != string(spss.DOUBLE) || cols["Version"] != string(spss.STRING) {
+		t.Errorf("FromSav did not build the table from the dictionary, got: %v", cols)
+	}
+	if rows := dataset.NumRows(); rows != 3 {
+		t.Errorf("FromSav loaded the wrong number of rows, got: %d, want: %d.", rows, 3)
+	}
+	var missing int
+	row, _ := dataset.DB.QueryRow("select count(*) from survey where Weight is null")
+	_ = row.Scan(&missing)
+	if missing != 1 {
+		t.Errorf("FromSav did not store system missing as NULL, got: %d, want: %d.", missing, 1)
+	}
+
+	meta, err := dataset.Dictionary()
+	if err != nil {
+		panic(err)
+	}
+	if meta == nil || meta.FileLabel != "survey" || len(meta.Variables) != 3 || meta.Variables[1].Label != "Questionnaire version" {
+		t.Errorf("FromSav did not store the dictionary, got: %+v", meta)
+	}
+
+	type Survey struct {
+		ID      int    `spss:"Serial"`
+		Version string `spss:"Version"`
+	}
+	dataset, err = d.FromSav("survey.sav", Survey{})
+	if err != nil {
+		logger.Panic(err)
+	}
+	cols = dataset.columnMetadata()
+	if len(cols) != 3 || cols["Serial"] != string(spss.INT) {
+		t.Errorf("FromSav did not use the struct's spss tags, got: %v", cols)
+	}
+	if meta, _ := dataset.Dictionary(); meta == nil || len(meta.Variables) != 2 || meta.Variables[0].Label != "Serial number" {
+		t.Errorf("FromSav stored the wrong dictionary, got: %+v", meta)
+	}
+
+	_ = dataset.DropColumn("Version")
+	if meta, _ := dataset.Dictionary(); meta == nil || len(meta.Variables) != 1 {
+		t.Errorf("DropColumn did not drop the variable from the dictionary, got: %+v", meta)
+	}
+
+	type Other struct {
+		Age int `spss:"Age"`
+	}
+	if _, err := d.FromSav("survey.sav", Other{}); err == nil {
+		t.Errorf("FromSav accepted a struct with a variable that is not in the file")
+	}
+
+	writeTestSav("ips-1710 q1.sav")
+	defer os.Remove("ips-1710 q1.sav")
+	dataset, err = d.FromSav("ips-1710 q1.sav", nil)
+	if err != nil {
+		t.Fatalf("FromSav failed on a file name that is not an identifier: %s", err)
+	}
+	if rows := dataset.NumRows(); rows != 3 {
+		t.Errorf("FromSav loaded the wrong number of rows, got: %d, want: %d.", rows, 3)
+	}
+}
+
+func TestToSav(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if err := dataset.ToSav("copy.sav"); err != nil {
+		panic(err)
+	}
+	defer os.Remove("copy.sav")
+
+	original, err := spss.Inspect("survey.sav")
+	if err != nil {
+		panic(err)
+	}
+	meta, err := spss.Inspect("copy.sav")
+	if err != nil {
+		panic(err)
+	}
+	if meta.FileLabel != "survey" || meta.RowCount != 3 {
+		t.Errorf("ToSav wrote the wrong file label or rows, got: %s %d, want: survey 3.", meta.FileLabel, meta.RowCount)
+	}
+	for _, want := range original.Variables {
+		got, ok := meta.Variable(want.Name)
+		if !ok {
+			t.Errorf("ToSav did not write variable %s", want.Name)
+			continue
+		}
+		if got.Label != want.Label || got.Format != want.Format || got.Type != want.Type ||
+			!reflect.DeepEqual(got.ValueLabels, want.ValueLabels) || !reflect.DeepEqual(got.Missing, want.Missing) {
+			t.Errorf("ToSav did not keep the dictionary of %s, got: %+v, want: %+v.", want.Name, got, want)
+		}
+	}
+
+	records, _, err := spss.ReadRecords("copy.sav")
+	if err != nil {
+		panic(err)
+	}
+	if len(records) != 3 || records[1].Values()[1] != "v2" || records[2].Values()[2] != nil {
+		t.Errorf("ToSav wrote the wrong data, got: %v", records)
+	}
+
+	table, err := setupTable(logger)
+	if err != nil {
+		panic(err)
+	}
+	if err := table.ToSav("address.sav"); err != nil {
+		panic(err)
+	}
+	defer os.Remove("address.sav")
+	if meta, err = spss.Inspect("address.sav"); err != nil {
+		panic(err)
+	}
+	if v, _ := meta.Variable("PostCode"); v.Type != spss.ReadstatTypeDouble || v.Format != "F8" {
+		t.Errorf("ToSav did not write an INTEGER column as a whole number, got: %+v", v)
+	}
+	if v, _ := meta.Variable("Name"); v.Type != spss.ReadstatTypeString {
+		t.Errorf("ToSav did not write a TEXT column as a string, got: %+v", v)
+	}
+}
+
+func TestToCSV(t *testing.T) {
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	dataset, err := setupTable(logger)
+	if err != nil {
+		panic(err)
+	}
+	defer dataset.Close()
+	_ = dataset.Insert(map[string]interface{}{"Name": "Smith, \"Jo\"", "Address": "1 High St\nLondon"})
+	defer os.Remove("address.csv")
+
+	if err := dataset.ToCSV("address.csv"); err != nil {
+		panic(err)
+	}
+	f, err := os.Open("address.csv")
+	if err != nil {
+		panic(err)
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	_ = f.Close()
+	if err != nil {
+		t.Fatalf("ToCSV wrote invalid CSV: %s", err)
+	}
+	want := [][]string{
+		{"Name", "Address", "PostCode", "HowMany"},
+		{"Boss Lady", "123 the Valleys Newport Wales", "1908", "10.24"},
+		{"Thorny El", "Down the pub, as usual", "666", "11.24"},
+		{"George the Dragon", "With El down the pub", "667", "12.24"},
+		{"Smith, \"Jo\"", "1 High St\nLondon", "", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("ToCSV wrote the wrong records, got: %q, want: %q.", records, want)
+	}
+
+	opts := CSVWriteOptions{Comma: ';', NoHeader: true, Null: "NA", FloatFormat: 'f', Precision: 1}
+	if err := dataset.ToCSVOptions("address.csv", opts); err != nil {
+		panic(err)
+	}
+	b, _ := ioutil.ReadFile("address.csv")
+	if lines := strings.Split(string(b), "\n"); lines[0] != "Boss Lady;123 the Valleys Newport Wales;1908;10.2" || lines[4] != "London\";NA;NA" {
+		t.Errorf("ToCSVOptions did not apply the options, got: %q", lines)
+	}
+
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+	survey, err := dataset.FromSav("survey.sav", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove("survey.csv")
+	if err := survey.ToCSVOptions("survey.csv", CSVWriteOptions{Labels: true}); err != nil {
+		panic(err)
+	}
+	b, _ = ioutil.ReadFile("survey.csv")
+	if want := "Serial,Version,Weight\n1,First version,1.5\n2,v2,0.5\n3,First version,\n"; string(b) != want {
+		t.Errorf("ToCSVOptions did not write value labels, got: %q, want: %q.", b, want)
+	}
+}
+
+func TestToArrow(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if err := dataset.ToArrow("survey.arrow"); err != nil {
+		panic(err)
+	}
+	defer os.Remove("survey.arrow")
+
+	f, err := os.Open("survey.arrow")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+
+	schema := r.Schema()
+	if label, _ := schema.Metadata().GetValue(convert.MetaFileLabel); label != "survey" {
+		t.Errorf("ToArrow did not keep the file label, got: %q, want: survey.", label)
+	}
+	i := schema.FieldIndices("Weight")
+	if len(i) != 1 {
+		t.Fatalf("ToArrow did not write column Weight")
+	}
+	if label, _ := schema.Field(i[0]).Metadata.GetValue(convert.MetaLabel); label != "Design weight" {
+		t.Errorf("ToArrow did not keep the variable label, got: %q, want: Design weight.", label)
+	}
+	rec, err := r.Record(0)
+	if err != nil {
+		panic(err)
+	}
+	if rec.NumRows() != 3 || !rec.Column(i[0]).IsNull(2) {
+		t.Errorf("ToArrow wrote the wrong rows, got: %v", rec)
+	}
+}
+
+func TestToParquet(t *testing.T) {
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	dataset, err := setupTable(logger)
+	if err != nil {
+		panic(err)
+	}
+	defer dataset.Close()
+	defer os.Remove("address.parquet")
+
+	if err := dataset.ToParquet("address.parquet"); err != nil {
+		panic(err)
+	}
+	f, err := os.Open("address.parquet")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	reader, err := file.NewParquetReader(f)
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	if rows := reader.NumRows(); rows != 3 {
+		t.Errorf("ToParquet wrote the wrong number of rows, got: %d, want: 3.", rows)
+	}
+	fr, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		panic(err)
+	}
+	schema, err := fr.Schema()
+	if err != nil {
+		panic(err)
+	}
+	want := map[string]arrow.Type{"Name": arrow.STRING, "PostCode": arrow.INT64, "HowMany": arrow.FLOAT32}
+	for name, typ := range want {
+		i := schema.FieldIndices(name)
+		if len(i) != 1 || schema.Field(i[0]).Type.ID() != typ {
+			t.Errorf("ToParquet wrote the wrong type for %s, got: %v, want: %s.", name, i, typ)
+		}
+	}
+}
+
+func TestToXLSX(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if err := dataset.ToXLSX("survey.xlsx", true); err != nil {
+		panic(err)
+	}
+	defer os.Remove("survey.xlsx")
+
+	f, err := excelize.OpenFile("survey.xlsx")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(convert.DataSheet)
+	if err != nil {
+		panic(err)
+	}
+	want := [][]string{
+		{"Serial", "Version", "Weight"},
+		{"1", "First version", "1.5"},
+		{"2", "v2", "0.5"},
+		{"3", "First version"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("ToXLSX wrote the wrong data sheet, got: %q, want: %q.", rows, want)
+	}
+	rows, err = f.GetRows(convert.VariablesSheet)
+	if err != nil {
+		panic(err)
+	}
+	if len(rows) != 4 || rows[2][5] != `"v1" = First version` || rows[3][6] != "-9 thru -1" {
+		t.Errorf("ToXLSX wrote the wrong variables sheet, got: %q.", rows)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	dataset, err := setupTable(logger)
+	if err != nil {
+		panic(err)
+	}
+	defer dataset.Close()
+	_ = dataset.Insert(map[string]interface{}{"Name": "Smith, \"Jo\""})
+	defer os.Remove("address.json")
+
+	if err := dataset.ToJSON("address.json"); err != nil {
+		panic(err)
+	}
+	b, err := ioutil.ReadFile("address.json")
+	if err != nil {
+		panic(err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(b, &rows); err != nil {
+		t.Fatalf("ToJSON wrote invalid JSON: %s", err)
+	}
+	if len(rows) != 4 || rows[0]["PostCode"] != 1908.0 || rows[0]["HowMany"] != 10.24 || rows[3]["Name"] != "Smith, \"Jo\"" || rows[3]["PostCode"] != nil {
+		t.Errorf("ToJSON wrote the wrong rows, got: %v", rows)
+	}
+}
+
+func TestFromNDJSON(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+
+	data := `{"Name":"Boss Lady","Age":42,"Score":10.5,"Member":true}` + "\n\n" +
+		`{"Name":"Thorny El","Score":11,"Member":false,"Code":"A1"}` + "\n" +
+		`{"Name":"George","Age":null,"Score":null}` + "\n"
+	if err := ioutil.WriteFile("people.ndjson", []byte(data), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove("people.ndjson")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromNDJSON("people.ndjson", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if names := dataset.columnNames(); !reflect.DeepEqual(names, []string{"Name", "Age", "Score", "Member", "Code"}) {
+		t.Errorf("FromNDJSON made the wrong columns, got: %v", names)
+	}
+	cols := dataset.columnMetadata()
+	if cols["Name"] != string(spss.STRING) || cols["Age"] != string(spss.INT) || cols["Score"] != string(spss.DOUBLE) || cols["Member"] != string(spss.INT) {
+		t.Errorf("FromNDJSON inferred the wrong column types, got: %v", cols)
+	}
+	if rows := dataset.NumRows(); rows != 3 {
+		t.Errorf("FromNDJSON loaded the wrong number of rows, got: %d, want: %d.", rows, 3)
+	}
+
+	if err := ioutil.WriteFile("bad.ndjson", []byte("{\"a\":1}\n{\"a\":[1]}\n"), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove("bad.ndjson")
+	if _, err := d.FromNDJSON("bad.ndjson", nil); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("FromNDJSON did not report the bad line, got: %v", err)
+	}
+}
+
+func TestToNDJSON(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if err := dataset.ToNDJSON("copy.ndjson", true); err != nil {
+		panic(err)
+	}
+	defer os.Remove("copy.ndjson")
+	b, _ := ioutil.ReadFile("copy.ndjson")
+	lines := strings.Split(string(b), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], `{"$dictionary":{"file_label":"survey"`) || lines[3] != `{"Serial":3,"Version":"v1","Weight":null}` {
+		t.Errorf("ToNDJSON wrote the wrong lines, got: %q", lines)
+	}
+
+	// the dictionary line makes the copy keep the dictionary of the SAV file
+	copied, err := d.FromNDJSON("copy.ndjson", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer copied.Close()
+	original, err := dataset.Dictionary()
+	if err != nil {
+		panic(err)
+	}
+	meta, err := copied.Dictionary()
+	if err != nil {
+		panic(err)
+	}
+	if meta == nil || meta.FileLabel != "survey" || len(meta.Variables) != 3 {
+		t.Fatalf("FromNDJSON did not store the dictionary, got: %v", meta)
+	}
+	for i, want := range original.Variables {
+		got := meta.Variables[i]
+		if got.Name != want.Name || got.Label != want.Label || got.Measure != want.Measure ||
+			!reflect.DeepEqual(got.ValueLabels, want.ValueLabels) || !reflect.DeepEqual(got.Missing, want.Missing) {
+			t.Errorf("FromNDJSON stored the wrong variable, got: %+v, want: %+v.", got, want)
+		}
+	}
+	if cols := copied.columnMetadata(); cols["Serial"] != string(spss.DOUBLE) || cols["Version"] != string(spss.STRING) {
+		t.Errorf("FromNDJSON did not type the columns from the dictionary, got: %v", cols)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	dataset, err := setupTable(logger)
+	if err != nil {
+		panic(err)
+	}
+	defer dataset.Close()
+	_ = dataset.Insert(map[string]interface{}{"Name": "Thorny El", "PostCode": 666})
+
+	s, err := dataset.Summary("PostCode")
+	if err != nil {
+		panic(err)
+	}
+	if !s.Numeric || s.Count != 4 || s.Missing != 0 || s.Distinct != 3 || s.Min != 666 || s.Max != 1908 || s.Sum != 3907 {
+		t.Errorf("Summary returned the wrong counts, got: %+v", s)
+	}
+	if s.Mean != 976.75 || s.Median != 666.5 || math.Abs(s.StdDev-620.83) > 0.01 || s.Quantiles[2] != (Quantile{P: 0.75, Value: 977.25}) {
+		t.Errorf("Summary returned the wrong statistics, got: %+v", s)
+	}
+
+	s, err = dataset.Summary("HowMany")
+	if err != nil {
+		panic(err)
+	}
+	if s.Count != 3 || s.Missing != 1 || math.Abs(s.Mean-11.24) > 1e-6 {
+		t.Errorf("Summary did not count NULL as missing, got: %+v", s)
+	}
+
+	summaries, err := dataset.Describe("Name")
+	if err != nil {
+		panic(err)
+	}
+	want := []ValueCount{{"Thorny El", 2}, {"Boss Lady", 1}, {"George the Dragon", 1}}
+	if len(summaries) != 1 || summaries[0].Numeric || summaries[0].Distinct != 3 || !reflect.DeepEqual(summaries[0].Top, want) {
+		t.Errorf("Describe returned the wrong summary, got: %+v", summaries)
+	}
+	if _, err := dataset.Summary("Nope"); err == nil {
+		t.Errorf("Summary did not fail for a missing column")
+	}
+}
+
+func TestWeight(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if weight, _ := dataset.Weight(); weight != "Weight" {
+		t.Fatalf("FromSav did not keep the weight variable, got: %q, want: Weight.", weight)
+	}
+	if meta, _ := dataset.Dictionary(); meta.Weight != "Weight" {
+		t.Errorf("Dictionary did not return the weight, got: %q, want: Weight.", meta.Weight)
+	}
+
+	// the third row has a missing weight and is left out
+	if mean, _ := dataset.Mean("Serial"); mean != 1.25 {
+		t.Errorf("Mean is not weighted, got: %f, want: %f.", mean, 1.25)
+	}
+	if total, _ := dataset.Total("Serial"); total != 2.5 {
+		t.Errorf("Total is not weighted, got: %f, want: %f.", total, 2.5)
+	}
+
+	if err := dataset.SetWeight("Version"); err == nil {
+		t.Errorf("SetWeight accepted a string column")
+	}
+	if err := dataset.SetWeight(""); err != nil {
+		panic(err)
+	}
+	if mean, _ := dataset.Mean("Serial"); mean != 2 {
+		t.Errorf("Mean is weighted after SetWeight(\"\"), got: %f, want: %f.", mean, 2.0)
+	}
+	if total, _ := dataset.Total("Serial"); total != 6 {
+		t.Errorf("Total is weighted after SetWeight(\"\"), got: %f, want: %f.", total, 6.0)
+	}
+
+	if err := dataset.SetWeight("Weight"); err != nil {
+		panic(err)
+	}
+	if err := dataset.ToSav("weighted.sav"); err != nil {
+		panic(err)
+	}
+	defer os.Remove("weighted.sav")
+	if meta, _ := spss.Inspect("weighted.sav"); meta == nil || meta.Weight != "Weight" {
+		t.Errorf("ToSav did not write the weight, got: %+v", meta)
+	}
+}
+
+func TestFrequencies(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+	if _, err := dataset.DB.Exec("insert into survey (Serial, Version, Weight) values (4, 'v2', -9)"); err != nil {
+		panic(err)
+	}
+
+	// rows with a missing or negative weight are left out
+	f, err := dataset.Frequencies("Version")
+	if err != nil {
+		panic(err)
+	}
+	if f.Weight != "Weight" || f.Total != 2 || len(f.Frequencies) != 2 || f.Frequencies[0].Label != "First version" ||
+		f.Frequencies[0].Count != 1.5 || f.Frequencies[1].Percent != 25 {
+		t.Errorf("Frequencies did not weight the counts, got: %+v", f)
+	}
+	f.Render(os.Stdout)
+
+	if err := dataset.SetWeight(""); err != nil {
+		panic(err)
+	}
+	f, err = dataset.Frequencies("Weight")
+	if err != nil {
+		panic(err)
+	}
+	want := []Frequency{
+		{Category: Category{0.5, "0.5"}, Count: 1, Percent: 25, ValidPercent: 50, CumulativePercent: 50},
+		{Category: Category{1.5, "1.5"}, Count: 1, Percent: 25, ValidPercent: 50, CumulativePercent: 100},
+		{Category: Category{-9.0, "-9"}, Missing: true, Count: 1, Percent: 25},
+		{Category: Category{nil, "System missing"}, Missing: true, Count: 1, Percent: 25},
+	}
+	for i := range f.Frequencies {
+		if f.Frequencies[i].Missing {
+			f.Frequencies[i].ValidPercent, f.Frequencies[i].CumulativePercent = 0, 0
+		}
+	}
+	if !reflect.DeepEqual(f.Frequencies, want) || f.Valid != 2 || f.Missing != 2 || f.Total != 4 {
+		t.Errorf("Frequencies did not count the missing values, got: %+v, want: %+v.", f.Frequencies, want)
+	}
+
+	c, err := dataset.Crosstab("Version", "Weight")
+	if err != nil {
+		panic(err)
+	}
+	if len(c.Rows) != 2 || c.Rows[0].Label != "First version" || len(c.Columns) != 2 || c.Columns[0].Value != 0.5 ||
+		c.Counts[0][1] != 1 || c.Counts[1][0] != 1 || c.Missing != 2 || c.Total != 2 || c.RowPercent(0, 1) != 100 {
+		t.Errorf("Crosstab is incorrect, got: %+v", c)
+	}
+
+	c, err = dataset.WeightedCrosstab("Version", "Serial", "Weight")
+	if err != nil {
+		panic(err)
+	}
+	if c.Total != 2 || c.Counts[0][0] != 1.5 || c.ColumnPercent(1, 1) != 100 || c.TotalPercent(1, 1) != 25 {
+		t.Errorf("WeightedCrosstab did not weight the counts, got: %+v", c)
+	}
+	c.Render(os.Stdout)
+
+	if _, err := dataset.Crosstab("Version", "Unknown"); err == nil {
+		t.Errorf("Crosstab accepted an unknown column")
+	}
+}
+
+func TestCompute(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	if err := dataset.Compute("Grossed", spss.DOUBLE, `Serial * coalesce("Weight", 0) + length('Weight(x)')`); err != nil {
+		panic(err)
+	}
+	var sum float64
+	row, _ := dataset.DB.QueryRow("select sum(Grossed) from survey")
+	_ = row.Scan(&sum)
+	if sum != 2.5+27 {
+		t.Errorf("Compute set the wrong values, got: %f, want: %f.", sum, 2.5+27)
+	}
+	if err := dataset.Compute("Bad", spss.DOUBLE, "Serial * Unknown"); err == nil || !strings.Contains(err.Error(), "Unknown does not exist") {
+		t.Errorf("Compute accepted an unknown column, got: %v", err)
+	}
+	if err := dataset.Compute("Bad", spss.DOUBLE, "Serial *"); err == nil {
+		t.Errorf("Compute accepted an invalid expression")
+	}
+	if ok, _ := dataset.doesColumnExist("Bad"); ok {
+		t.Errorf("a failed Compute added its column")
+	}
+
+	err = dataset.Recode("Serial", "Band", map[interface{}]interface{}{
+		1:                        "one",
+		Range{2, math.Inf(1)}:    "two or more",
+		Range{math.Inf(-1), 1.5}: "low",
+	})
+	if err != nil {
+		panic(err)
+	}
+	if cols := dataset.columnMetadata(); cols["Band"] != string(spss.STRING) {
+		t.Errorf("Recode did not add a TEXT column, got: %s", cols["Band"])
+	}
+	var bands []string
+	rows, _ := dataset.DB.Query("select Band from survey order by Row")
+	for rows.Next() {
+		var band string
+		_ = rows.Scan(&band)
+		bands = append(bands, band)
+	}
+	_ = rows.Close()
+	if want := []string{"one", "two or more", "two or more"}; !reflect.DeepEqual(bands, want) {
+		t.Errorf("Recode set the wrong values, got: %v, want: %v.", bands, want)
+	}
+
+	// values that are not mapped are unchanged when recoding in place
+	if err := dataset.Recode("Version", "Version", map[interface{}]interface{}{"v2": "v1"}); err != nil {
+		panic(err)
+	}
+	if f, _ := dataset.Frequencies("Version"); len(f.Frequencies) != 1 || f.Frequencies[0].Value != "v1" {
+		t.Errorf("Recode in place set the wrong values, got: %+v", f.Frequencies)
+	}
+	if err := dataset.Recode("Version", "Version2", map[interface{}]interface{}{Range{1, 2}: 1}); err == nil {
+		t.Errorf("Recode accepted a range for a string column")
+	}
+	if err := dataset.Recode("Serial", "Weight", map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Errorf("Recode put strings in a numeric column")
+	}
+}
+
+func TestSelect(t *testing.T) {
+
+	logger := log.New(os.Stdout, "LFS ", log.LstdFlags|log.Lshortfile)
+	_ = os.Remove("LFS.db")
+	writeTestSav("survey.sav")
+	defer os.Remove("survey.sav")
+
+	d, err := NewDataset("test", logger)
+	if err != nil {
+		logger.Panic(err)
+	}
+	dataset, err := d.FromSav("survey.sav", nil)
+	if err != nil {
+		logger.Panic(err)
+	}
+	defer dataset.Close()
+
+	type survey struct {
+		Serial  int     `spss:"Serial"`
+		Version string  `spss:"Version"`
+		Weight  float64 `spss:"Weight"`
+	}
+	var rows []survey
+	if err := dataset.Select().Where("Version = ?", "v1").OrderBy("-Serial").All(&rows); err != nil {
+		panic(err)
+	}
+	if len(rows) != 2 || rows[0].Serial != 3 || !math.IsNaN(rows[0].Weight) || rows[1] != (survey{1, "v1", 1.5}) {
+		t.Errorf("Select did not return the rows as structs, got: %+v", rows)
+	}
+
+	var row map[string]interface{}
+	query := dataset.Select("Serial", "Weight").Where(db.Cond{"Weight >": 0})
+	if ok, err := query.Offset(1).One(&row); err != nil || !ok {
+		t.Fatalf("One did not find a row, got: %v %v", ok, err)
+	}
+	if want := (map[string]interface{}{"Serial": 2.0, "Weight": 0.5}); !reflect.DeepEqual(row, want) {
+		t.Errorf("Select did not return the row as a map, got: %v, want: %v.", row, want)
+	}
+	if ok, _ := query.Where("Serial > ?", 2).One(&row); ok {
+		t.Errorf("One found a row that does not match")
+	}
+
+	it, err := dataset.Select("Serial").Limit(2).Iterator()
+	if err != nil {
+		panic(err)
+	}
+	var serials []int
+	for it.Next() {
+		var s survey
+		if err := it.Scan(&s); err != nil {
+			panic(err)
+		}
+		serials = append(serials, s.Serial)
+	}
+	if err := it.Err(); err != nil {
+		panic(err)
+	}
+	_ = it.Close()
+	if !reflect.DeepEqual(serials, []int{1, 2}) {
+		t.Errorf("Iterator returned the wrong rows, got: %v", serials)
+	}
+
+	if err := dataset.Select("Unknown").All(&rows); err == nil {
+		t.Errorf("Select accepted an unknown column")
+	}
+	if err := dataset.Select().OrderBy("-Unknown").All(&rows); err == nil {
+		t.Errorf("OrderBy accepted an unknown column")
+	}
+}
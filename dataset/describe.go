@@ -0,0 +1,239 @@
+package dataset
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	spss "go-spss"
+)
+
+// quantiles are the probabilities of the quantiles in a Summary
+var quantiles = []float64{0.25, 0.5, 0.75}
+
+// topValues is the number of most frequent values in the Summary of a string column
+const topValues = 5
+
+// Summary holds the descriptive statistics of a column. Numeric columns have Min to Quantiles,
+// which are NaN when the column has no values, string columns have Top. Distinct is counted for
+// both
+type Summary struct {
+	Column    string
+	Numeric   bool
+	Count     int // values that are not NULL
+	Missing   int // NULL values, and text in numeric columns
+	Distinct  int // distinct values that are not NULL
+	Min       float64
+	Max       float64
+	Sum       float64
+	Mean      float64
+	StdDev    float64 // sample standard deviation, as SPSS reports it
+	Variance  float64 // sample variance
+	Median    float64
+	Quantiles []Quantile   // the 25th, 50th and 75th percentiles
+	Top       []ValueCount // the most frequent values, most frequent first
+}
+
+// Quantile is the value below which a proportion P of the values of a column fall, interpolated
+// between the closest ranks as R and pandas do by default
+type Quantile struct {
+	P     float64
+	Value float64
+}
+
+// ValueCount is a value of a column and the number of rows holding it
+type ValueCount struct {
+	Value string
+	Count int
+}
+
+// Summary returns the descriptive statistics of the column col. Columns declared as INTEGER,
+// FLOAT, DOUBLE or REAL are numeric, others are strings
+func (d Dataset) Summary(col string) (Summary, error) {
+	ok, colLookup := d.doesColumnExist(col)
+	if !ok {
+		return Summary{}, fmt.Errorf(" -> Summary: column %s does not exist", col)
+	}
+	s := Summary{Column: col, Numeric: savHeader(col, colLookup[col]).SavType != spss.ReadstatTypeString}
+
+	var err error
+	if s.Numeric {
+		err = d.numericSummary(&s)
+	} else {
+		err = d.stringSummary(&s)
+	}
+	if err != nil {
+		return Summary{}, fmt.Errorf(" -> Summary: column %s: %s", col, err)
+	}
+	return s, nil
+}
+
+// numericSummary reads the numbers of the column in order and computes the statistics from them
+func (d Dataset) numericSummary(s *Summary) error {
+	rows, err := d.DB.Query(fmt.Sprintf("select %s from %s where typeof(%s) in ('integer', 'real') order by 1",
+		s.Column, d.tableName, s.Column))
+	if err != nil {
+		return fmt.Errorf("cannot read values: %s", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var values []float64
+	for rows.Next() {
+		var v float64
+		if err := rows.Scan(&v); err != nil {
+			return fmt.Errorf("cannot read value: %s", err)
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot read values: %s", err)
+	}
+
+	s.Count = len(values)
+	s.Missing = d.NumRows() - s.Count
+	if s.Distinct, err = d.distinct(s.Column); err != nil {
+		return err
+	}
+
+	nan := math.NaN()
+	s.Min, s.Max, s.Mean, s.StdDev, s.Variance = nan, nan, nan, nan, nan
+	for _, p := range quantiles {
+		s.Quantiles = append(s.Quantiles, Quantile{P: p, Value: quantile(values, p)})
+	}
+	s.Median = quantile(values, 0.5)
+	if s.Count == 0 {
+		return nil
+	}
+
+	s.Min, s.Max = values[0], values[len(values)-1]
+	for _, v := range values {
+		s.Sum += v
+	}
+	s.Mean = s.Sum / float64(s.Count)
+	if s.Count > 1 {
+		var squares float64
+		for _, v := range values {
+			squares += (v - s.Mean) * (v - s.Mean)
+		}
+		s.Variance = squares / float64(s.Count-1)
+		s.StdDev = math.Sqrt(s.Variance)
+	}
+	return nil
+}
+
+// quantile returns the p quantile of sorted, NaN when it is empty
+func quantile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	h := p * float64(len(sorted)-1)
+	lo := math.Floor(h)
+	i := int(lo)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (h-lo)*(sorted[i+1]-sorted[i])
+}
+
+// stringSummary counts the values of the column and finds the most frequent
+func (d Dataset) stringSummary(s *Summary) error {
+	row, err := d.DB.QueryRow(fmt.Sprintf("select count(%s), count(*) - count(%s) from %s", s.Column, s.Column, d.tableName))
+	if err != nil {
+		return fmt.Errorf("cannot count values: %s", err)
+	}
+	if err := row.Scan(&s.Count, &s.Missing); err != nil {
+		return fmt.Errorf("cannot count values: %s", err)
+	}
+	if s.Distinct, err = d.distinct(s.Column); err != nil {
+		return err
+	}
+
+	rows, err := d.DB.Query(fmt.Sprintf("select %s, count(*) as n from %s where %s is not null group by %s order by n desc, %s limit %d",
+		s.Column, d.tableName, s.Column, s.Column, s.Column, topValues))
+	if err != nil {
+		return fmt.Errorf("cannot count values: %s", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var v ValueCount
+		if err := rows.Scan(&v.Value, &v.Count); err != nil {
+			return fmt.Errorf("cannot read value: %s", err)
+		}
+		s.Top = append(s.Top, v)
+	}
+	return rows.Err()
+}
+
+func (d Dataset) distinct(col string) (n int, err error) {
+	row, err := d.DB.QueryRow(fmt.Sprintf("select count(distinct %s) from %s", col, d.tableName))
+	if err != nil {
+		return 0, fmt.Errorf("cannot count distinct values: %s", err)
+	}
+	if err := row.Scan(&n); err != nil {
+		return 0, fmt.Errorf("cannot count distinct values: %s", err)
+	}
+	return n, nil
+}
+
+// Describe prints the Summary of each of the columns cols, or of every column when there are
+// none, as a table like Head, and returns them
+func (d Dataset) Describe(cols ...string) ([]Summary, error) {
+	if len(cols) == 0 {
+		cols = d.columnNames()
+	}
+	summaries := make([]Summary, 0, len(cols))
+	for _, col := range cols {
+		s, err := d.Summary(col)
+		if err != nil {
+			return nil, fmt.Errorf(" -> Describe%s", err)
+		}
+		summaries = append(summaries, s)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	header := []string{"Column", "Count", "Missing", "Distinct", "Min", "Max", "Sum", "Mean", "Std Dev", "Variance"}
+	for _, q := range quantiles {
+		header = append(header, strconv.FormatFloat(q*100, 'f', -1, 64)+"%")
+	}
+	table.SetHeader(append(header, "Top"))
+	for _, s := range summaries {
+		row := []string{s.Column, strconv.Itoa(s.Count), strconv.Itoa(s.Missing), strconv.Itoa(s.Distinct)}
+		if s.Numeric {
+			for _, v := range []float64{s.Min, s.Max, s.Sum, s.Mean, s.StdDev, s.Variance} {
+				row = append(row, statistic(v))
+			}
+			for _, q := range s.Quantiles {
+				row = append(row, statistic(q.Value))
+			}
+			row = append(row, "")
+		} else {
+			row = append(row, make([]string, 6+len(quantiles))...)
+			var top string
+			for i, v := range s.Top {
+				if i > 0 {
+					top += ", "
+				}
+				top += fmt.Sprintf("%s (%d)", v.Value, v.Count)
+			}
+			row = append(row, top)
+		}
+		table.Append(row)
+	}
+	table.SetCaption(true, fmt.Sprintf("%d Column(s)\n", len(summaries)))
+	table.Render()
+	return summaries, nil
+}
+
+// statistic formats a statistic for display, NaN is blank
+func statistic(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
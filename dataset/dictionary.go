@@ -0,0 +1,232 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	spss "go-spss"
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// The dictionaries of datasets loaded from SAV files are kept in two tables shared by every
+// dataset in the database, keyed by the dataset's table name. The weight of a dataset is kept
+// in a third as any dataset can be weighted, see SetWeight
+const (
+	filesTable     = "dataset_files"
+	variablesTable = "dataset_variables"
+	weightsTable   = "dataset_weights"
+)
+
+type fileRow struct {
+	Dataset   string    `db:"dataset"`
+	FileName  string    `db:"file_name"`
+	FileLabel string    `db:"file_label"`
+	Encoding  string    `db:"encoding"`
+	Created   time.Time `db:"created"`
+	Modified  time.Time `db:"modified"`
+}
+
+type variableRow struct {
+	Dataset      string `db:"dataset"`
+	Position     int    `db:"position"`
+	Column       string `db:"column_name"` // column in the dataset table
+	Name         string `db:"name"`        // variable name in the SAV file
+	Label        string `db:"label"`
+	Type         int    `db:"type"`
+	Format       string `db:"format"`
+	StorageWidth int    `db:"storage_width"`
+	DisplayWidth int    `db:"display_width"`
+	Measure      int    `db:"measure"`
+	LabelSet     string `db:"label_set"`
+	ValueLabels  string `db:"value_labels"` // JSON array of spss.ValueLabel
+	Missing      string `db:"missing"`      // JSON array of spss.MissingRange
+}
+
+func createDictionaryTables(sess sqlbuilder.SQLBuilder) error {
+	_, err := sess.Exec(fmt.Sprintf(`create table if not exists %s (
+		dataset text primary key,
+		file_name text not null,
+		file_label text not null,
+		encoding text not null,
+		created timestamp,
+		modified timestamp
+	)`, filesTable))
+	if err != nil {
+		return err
+	}
+	_, err = sess.Exec(fmt.Sprintf(`create table if not exists %s (
+		dataset text not null,
+		position integer not null,
+		column_name text not null,
+		name text not null,
+		label text not null,
+		type integer not null,
+		format text not null,
+		storage_width integer not null,
+		display_width integer not null,
+		measure integer not null,
+		label_set text not null,
+		value_labels text not null,
+		missing text not null,
+		primary key (dataset, column_name)
+	)`, variablesTable))
+	if err != nil {
+		return err
+	}
+	_, err = sess.Exec(fmt.Sprintf(`create table if not exists %s (
+		dataset text primary key,
+		column_name text not null
+	)`, weightsTable))
+	return err
+}
+
+// saveDictionary replaces the stored dictionary and weight of the dataset with meta. columns
+// holds the column storing each variable, a variable without one is not stored
+func (d Dataset) saveDictionary(tx sqlbuilder.Tx, meta *spss.Metadata, columns map[string]string) error {
+	if err := createDictionaryTables(tx); err != nil {
+		return fmt.Errorf("cannot create dictionary tables: %s", err)
+	}
+	if err := deleteDictionary(tx, d.tableName); err != nil {
+		return err
+	}
+
+	_, err := tx.InsertInto(filesTable).Values(fileRow{
+		Dataset:   d.tableName,
+		FileName:  meta.FileName,
+		FileLabel: meta.FileLabel,
+		Encoding:  meta.Encoding,
+		Created:   meta.Created,
+		Modified:  meta.Modified,
+	}).Exec()
+	if err != nil {
+		return fmt.Errorf("cannot save file metadata: %s", err)
+	}
+
+	position := 0
+	for _, v := range meta.Variables {
+		column, ok := columns[v.Name]
+		if !ok {
+			continue
+		}
+		labels, err := json.Marshal(v.ValueLabels)
+		if err != nil {
+			return fmt.Errorf("variable %s: %s", v.Name, err)
+		}
+		missing, err := json.Marshal(v.Missing)
+		if err != nil {
+			return fmt.Errorf("variable %s: %s", v.Name, err)
+		}
+		_, err = tx.InsertInto(variablesTable).Values(variableRow{
+			Dataset:      d.tableName,
+			Position:     position,
+			Column:       column,
+			Name:         v.Name,
+			Label:        v.Label,
+			Type:         int(v.Type),
+			Format:       v.Format,
+			StorageWidth: v.StorageWidth,
+			DisplayWidth: v.DisplayWidth,
+			Measure:      int(v.Measure),
+			LabelSet:     v.LabelSet,
+			ValueLabels:  string(labels),
+			Missing:      string(missing),
+		}).Exec()
+		if err != nil {
+			return fmt.Errorf("cannot save variable %s: %s", v.Name, err)
+		}
+		position++
+	}
+
+	if column, ok := columns[meta.Weight]; ok {
+		if _, err := tx.InsertInto(weightsTable).Values(weightRow{d.tableName, column}).Exec(); err != nil {
+			return fmt.Errorf("cannot save weight: %s", err)
+		}
+	}
+	return nil
+}
+
+func deleteDictionary(sess sqlbuilder.SQLBuilder, dataset string) error {
+	if _, err := sess.DeleteFrom(filesTable).Where(db.Cond{"dataset": dataset}).Exec(); err != nil {
+		return fmt.Errorf("cannot delete file metadata: %s", err)
+	}
+	if _, err := sess.DeleteFrom(variablesTable).Where(db.Cond{"dataset": dataset}).Exec(); err != nil {
+		return fmt.Errorf("cannot delete variable metadata: %s", err)
+	}
+	if _, err := sess.DeleteFrom(weightsTable).Where(db.Cond{"dataset": dataset}).Exec(); err != nil {
+		return fmt.Errorf("cannot delete weight: %s", err)
+	}
+	return nil
+}
+
+// Dictionary returns the SAV dictionary stored with the dataset when it was loaded by FromSav,
+// nil if there is none. Variables are named after the columns holding them, in column order,
+// and Weight is the column returned by Weight
+func (d Dataset) Dictionary() (*spss.Metadata, error) {
+	if err := createDictionaryTables(d.DB); err != nil {
+		return nil, fmt.Errorf(" -> Dictionary: cannot create dictionary tables: %s", err)
+	}
+
+	var file fileRow
+	err := d.DB.SelectFrom(filesTable).Where(db.Cond{"dataset": d.tableName}).One(&file)
+	if err == db.ErrNoMoreRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf(" -> Dictionary: cannot read file metadata: %s", err)
+	}
+	var rows []variableRow
+	err = d.DB.SelectFrom(variablesTable).Where(db.Cond{"dataset": d.tableName}).OrderBy("position").All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf(" -> Dictionary: cannot read variable metadata: %s", err)
+	}
+
+	meta := &spss.Metadata{
+		FileName:  file.FileName,
+		FileLabel: file.FileLabel,
+		Encoding:  file.Encoding,
+		Created:   file.Created,
+		Modified:  file.Modified,
+		LabelSets: make(map[string][]spss.ValueLabel),
+	}
+	positions := make(map[string]int)
+	for i, col := range d.columnNames() {
+		positions[col] = i
+	}
+	for _, row := range rows {
+		position, ok := positions[row.Column]
+		if !ok {
+			continue
+		}
+		v := spss.Variable{
+			Index:        position,
+			Name:         row.Column,
+			Label:        row.Label,
+			Format:       row.Format,
+			Type:         spss.ColumnType(row.Type),
+			StorageWidth: row.StorageWidth,
+			DisplayWidth: row.DisplayWidth,
+			Measure:      spss.Measure(row.Measure),
+			LabelSet:     row.LabelSet,
+		}
+		if err := json.Unmarshal([]byte(row.ValueLabels), &v.ValueLabels); err != nil {
+			return nil, fmt.Errorf(" -> Dictionary: variable %s: %s", row.Name, err)
+		}
+		if err := json.Unmarshal([]byte(row.Missing), &v.Missing); err != nil {
+			return nil, fmt.Errorf(" -> Dictionary: variable %s: %s", row.Name, err)
+		}
+		if v.LabelSet != "" {
+			meta.LabelSets[v.LabelSet] = v.ValueLabels
+		}
+		meta.Variables = append(meta.Variables, v)
+	}
+	sort.Slice(meta.Variables, func(i, j int) bool { return meta.Variables[i].Index < meta.Variables[j].Index })
+	meta.VarCount = len(meta.Variables)
+	meta.RowCount = d.NumRows()
+	if meta.Weight, err = d.Weight(); err != nil {
+		return nil, fmt.Errorf(" -> Dictionary%s", err)
+	}
+	return meta, nil
+}
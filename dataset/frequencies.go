@@ -0,0 +1,421 @@
+package dataset
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	spss "go-spss"
+)
+
+// systemMissing is the label of NULL in frequency tables and crosstabs, as SPSS names it
+const systemMissing = "System missing"
+
+// Category is a value of a column with its value label, or the value as text when it has none
+type Category struct {
+	Value interface{} // int64, float64 or string, nil for NULL
+	Label string
+}
+
+// Frequency is a line of a FrequencyTable. Counts are the sum of the weights of the rows holding
+// the value, or the number of rows when the table is not weighted
+type Frequency struct {
+	Category
+	Missing           bool    // NULL or a user missing value of the variable
+	Count             float64 // rows holding the value
+	Percent           float64 // of every row
+	ValidPercent      float64 // of the rows that are not missing, NaN for missing values
+	CumulativePercent float64 // valid percent of this and the values before it, NaN for missing values
+}
+
+// FrequencyTable counts the values of a column as FREQUENCIES does in SPSS. Valid values come
+// first in order, followed by the user missing values from the stored dictionary and NULL
+type FrequencyTable struct {
+	Column      string
+	Weight      string // column weighting the counts, empty when they are not weighted
+	Frequencies []Frequency
+	Valid       float64 // rows that are not missing
+	Missing     float64 // rows holding NULL or a user missing value
+	Total       float64
+}
+
+// Frequencies returns the frequency table of col, weighted by the weight of the dataset when it
+// has one, see SetWeight
+func (d Dataset) Frequencies(col string) (FrequencyTable, error) {
+	weight, err := d.Weight()
+	if err != nil {
+		return FrequencyTable{}, fmt.Errorf(" -> Frequencies%s", err)
+	}
+	f, err := d.WeightedFrequencies(col, weight)
+	if err != nil {
+		return FrequencyTable{}, fmt.Errorf(" -> Frequencies%s", err)
+	}
+	return f, nil
+}
+
+// WeightedFrequencies returns the frequency table of col with every row counted weight times.
+// Rows with a NULL, zero or negative weight are left out as SPSS does. An empty weight counts
+// every row once
+func (d Dataset) WeightedFrequencies(col, weight string) (FrequencyTable, error) {
+	f := FrequencyTable{Column: col, Weight: weight}
+	categories, err := d.categories(col)
+	if err != nil {
+		return f, fmt.Errorf(" -> WeightedFrequencies: %s", err)
+	}
+	counts, err := d.countBy(weight, col)
+	if err != nil {
+		return f, fmt.Errorf(" -> WeightedFrequencies: %s", err)
+	}
+
+	var valid, missing []Frequency
+	var null *Frequency
+	for _, c := range counts {
+		freq := Frequency{Category: categories.category(c.values[0]), Count: c.count}
+		freq.Missing = categories.isMissing(freq.Value)
+		f.Total += freq.Count
+		switch {
+		case freq.Value == nil:
+			null = &freq
+		case freq.Missing:
+			missing = append(missing, freq)
+		default:
+			valid = append(valid, freq)
+		}
+	}
+	if null != nil {
+		missing = append(missing, *null)
+	}
+
+	var cumulative float64
+	for _, freq := range valid {
+		f.Valid += freq.Count
+	}
+	for _, freq := range valid {
+		freq.Percent = percent(freq.Count, f.Total)
+		freq.ValidPercent = percent(freq.Count, f.Valid)
+		cumulative += freq.Count
+		freq.CumulativePercent = percent(cumulative, f.Valid)
+		f.Frequencies = append(f.Frequencies, freq)
+	}
+	for _, freq := range missing {
+		freq.Percent = percent(freq.Count, f.Total)
+		freq.ValidPercent, freq.CumulativePercent = math.NaN(), math.NaN()
+		f.Missing += freq.Count
+		f.Frequencies = append(f.Frequencies, freq)
+	}
+	return f, nil
+}
+
+// Render writes the frequency table to w as a table like Head, with percentages to one decimal
+// place
+func (f FrequencyTable) Render(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"", "Value", "Label", "Frequency", "Percent", "Valid Percent", "Cumulative Percent"})
+	for i, freq := range f.Frequencies {
+		// the first valid and the first missing value start their group
+		var group string
+		switch {
+		case i == 0 && !freq.Missing:
+			group = "Valid"
+		case freq.Missing && (i == 0 || !f.Frequencies[i-1].Missing):
+			group = "Missing"
+		}
+		table.Append([]string{group, categoryText(freq.Value), freq.Label, statistic(freq.Count),
+			percentText(freq.Percent), percentText(freq.ValidPercent), percentText(freq.CumulativePercent)})
+	}
+	table.Append([]string{"Total", "", "", statistic(f.Total), percentText(percent(f.Total, f.Total)), "", ""})
+	table.SetCaption(true, tableCaption(f.Column, f.Weight)+"\n")
+	table.Render()
+}
+
+// CrosstabTable counts the rows holding each combination of the values of two columns as
+// CROSSTABS does in SPSS. Rows missing either value, NULL or a user missing value from the
+// stored dictionary, are left out of the table and counted in Missing
+type CrosstabTable struct {
+	Row          string
+	Column       string
+	Weight       string     // column weighting the counts, empty when they are not weighted
+	Rows         []Category // values of Row in order
+	Columns      []Category // values of Column in order
+	Counts       [][]float64
+	RowTotals    []float64
+	ColumnTotals []float64
+	Total        float64
+	Missing      float64
+}
+
+// RowPercent returns the count of the cell i, j as a percentage of the count of row i
+func (c CrosstabTable) RowPercent(i, j int) float64 {
+	return percent(c.Counts[i][j], c.RowTotals[i])
+}
+
+// ColumnPercent returns the count of the cell i, j as a percentage of the count of column j
+func (c CrosstabTable) ColumnPercent(i, j int) float64 {
+	return percent(c.Counts[i][j], c.ColumnTotals[j])
+}
+
+// TotalPercent returns the count of the cell i, j as a percentage of the table total
+func (c CrosstabTable) TotalPercent(i, j int) float64 {
+	return percent(c.Counts[i][j], c.Total)
+}
+
+// Crosstab returns the crosstab of the columns row and col, weighted by the weight of the dataset
+// when it has one, see SetWeight
+func (d Dataset) Crosstab(row, col string) (CrosstabTable, error) {
+	weight, err := d.Weight()
+	if err != nil {
+		return CrosstabTable{}, fmt.Errorf(" -> Crosstab%s", err)
+	}
+	c, err := d.WeightedCrosstab(row, col, weight)
+	if err != nil {
+		return CrosstabTable{}, fmt.Errorf(" -> Crosstab%s", err)
+	}
+	return c, nil
+}
+
+// WeightedCrosstab returns the crosstab of the columns row and col with every row counted weight
+// times. Rows are left out as by WeightedFrequencies
+func (d Dataset) WeightedCrosstab(row, col, weight string) (CrosstabTable, error) {
+	c := CrosstabTable{Row: row, Column: col, Weight: weight}
+	rowCategories, err := d.categories(row)
+	if err != nil {
+		return c, fmt.Errorf(" -> WeightedCrosstab: %s", err)
+	}
+	colCategories, err := d.categories(col)
+	if err != nil {
+		return c, fmt.Errorf(" -> WeightedCrosstab: %s", err)
+	}
+	counts, err := d.countBy(weight, row, col)
+	if err != nil {
+		return c, fmt.Errorf(" -> WeightedCrosstab: %s", err)
+	}
+
+	rowIndex := make(map[interface{}]int)
+	colIndex := make(map[interface{}]int)
+	var cells []valueCount
+	for _, count := range counts {
+		r, v := count.values[0], count.values[1]
+		if rowCategories.isMissing(r) || colCategories.isMissing(v) {
+			c.Missing += count.count
+			continue
+		}
+		if _, ok := rowIndex[r]; !ok {
+			rowIndex[r] = len(c.Rows)
+			c.Rows = append(c.Rows, rowCategories.category(r))
+		}
+		if _, ok := colIndex[v]; !ok {
+			colIndex[v] = len(c.Columns)
+			c.Columns = append(c.Columns, colCategories.category(v))
+		}
+		cells = append(cells, count)
+	}
+	// rows come ordered by the query, columns are only ordered within a row
+	sort.SliceStable(c.Columns, func(i, j int) bool { return lessValue(c.Columns[i].Value, c.Columns[j].Value) })
+	for j, category := range c.Columns {
+		colIndex[category.Value] = j
+	}
+
+	c.Counts = make([][]float64, len(c.Rows))
+	for i := range c.Counts {
+		c.Counts[i] = make([]float64, len(c.Columns))
+	}
+	c.RowTotals = make([]float64, len(c.Rows))
+	c.ColumnTotals = make([]float64, len(c.Columns))
+	for _, cell := range cells {
+		i, j := rowIndex[cell.values[0]], colIndex[cell.values[1]]
+		c.Counts[i][j] += cell.count
+		c.RowTotals[i] += cell.count
+		c.ColumnTotals[j] += cell.count
+		c.Total += cell.count
+	}
+	return c, nil
+}
+
+// Render writes the crosstab to w as a table like Head. Each cell holds its count and row
+// percentage, the last row the column totals and their percentage of the table total
+func (c CrosstabTable) Render(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	header := []string{c.Row + " \\ " + c.Column}
+	for _, category := range c.Columns {
+		header = append(header, category.Label)
+	}
+	table.SetHeader(append(header, "Total"))
+	for i, category := range c.Rows {
+		line := []string{category.Label}
+		for j := range c.Columns {
+			line = append(line, cellText(c.Counts[i][j], c.RowPercent(i, j)))
+		}
+		table.Append(append(line, cellText(c.RowTotals[i], percent(c.RowTotals[i], c.RowTotals[i]))))
+	}
+	line := []string{"Total"}
+	for j := range c.Columns {
+		line = append(line, cellText(c.ColumnTotals[j], percent(c.ColumnTotals[j], c.Total)))
+	}
+	table.Append(append(line, cellText(c.Total, percent(c.Total, c.Total))))
+
+	caption := tableCaption(c.Row+" by "+c.Column, c.Weight)
+	if c.Missing > 0 {
+		caption += fmt.Sprintf(", %s missing", statistic(c.Missing))
+	}
+	table.SetCaption(true, caption+"\n")
+	table.Render()
+}
+
+// categories holds the value labels and missing values of a column from the stored dictionary
+type categories struct {
+	labels   map[interface{}]string
+	variable spss.Variable
+}
+
+// categories returns the value labels and missing values of the column col
+func (d Dataset) categories(col string) (categories, error) {
+	if ok, _ := d.doesColumnExist(col); !ok {
+		return categories{}, fmt.Errorf("column %s does not exist", col)
+	}
+	meta, err := d.Dictionary()
+	if err != nil || meta == nil {
+		return categories{}, err
+	}
+	labels, err := d.valueLabels([]string{col})
+	if err != nil {
+		return categories{}, err
+	}
+	c := categories{labels: labels[0]}
+	c.variable, _ = meta.Variable(col)
+	return c, nil
+}
+
+// category returns value with its label
+func (c categories) category(value interface{}) Category {
+	if value == nil {
+		return Category{Label: systemMissing}
+	}
+	if label, ok := columnLabel(c.labels, value); ok {
+		return Category{value, label}
+	}
+	return Category{value, categoryText(value)}
+}
+
+// isMissing reports whether value is NULL or a user missing value of the column
+func (c categories) isMissing(value interface{}) bool {
+	if n, ok := value.(int64); ok {
+		value = float64(n)
+	}
+	return c.variable.IsMissing(value)
+}
+
+// valueCount is the number of rows holding a combination of values, summing their weights when
+// weighted
+type valueCount struct {
+	values []interface{}
+	count  float64
+}
+
+// countBy counts the rows holding each combination of the values of cols, ordered by them.
+// With a weight, rows with a NULL or non-positive weight are left out and the weights summed
+func (d Dataset) countBy(weight string, cols ...string) ([]valueCount, error) {
+	count, where := "count(*)", ""
+	if weight != "" {
+		if err := d.weightColumn(weight); err != nil {
+			return nil, fmt.Errorf("weight: %s", err)
+		}
+		count, where = fmt.Sprintf("total(%s)", weight), fmt.Sprintf(" where %s > 0", weight)
+	}
+	list := strings.Join(cols, ", ")
+	rows, err := d.DB.Query(fmt.Sprintf("select %s, %s from %s%s group by %s order by %s",
+		list, count, d.tableName, where, list, list))
+	if err != nil {
+		return nil, fmt.Errorf("cannot count values: %s", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var counts []valueCount
+	for rows.Next() {
+		c := valueCount{values: make([]interface{}, len(cols))}
+		dest := make([]interface{}, len(cols)+1)
+		for i := range c.values {
+			dest[i] = &c.values[i]
+		}
+		dest[len(cols)] = &c.count
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("cannot read value: %s", err)
+		}
+		for i, v := range c.values {
+			if b, ok := v.([]byte); ok {
+				c.values[i] = string(b)
+			}
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot count values: %s", err)
+	}
+	return counts, nil
+}
+
+// lessValue orders values as SQLite does, numbers before strings
+func lessValue(a, b interface{}) bool {
+	x, aNumber := number(a)
+	y, bNumber := number(b)
+	switch {
+	case aNumber && bNumber:
+		return x < y
+	case aNumber != bNumber:
+		return aNumber
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// percent returns n as a percentage of total, NaN when total is zero
+func percent(n, total float64) float64 {
+	if total == 0 {
+		return math.NaN()
+	}
+	return n / total * 100
+}
+
+// categoryText formats a value of a frequency table or crosstab for display
+func categoryText(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return csvText(value, nil, CSVWriteOptions{})
+}
+
+// percentText formats a percentage for display to one decimal place, NaN is blank
+func percentText(p float64) string {
+	if math.IsNaN(p) {
+		return ""
+	}
+	return strconv.FormatFloat(p, 'f', 1, 64)
+}
+
+func cellText(count, p float64) string {
+	if text := percentText(p); text != "" {
+		return fmt.Sprintf("%s (%s%%)", statistic(count), text)
+	}
+	return statistic(count)
+}
+
+func tableCaption(name, weight string) string {
+	if weight == "" {
+		return name
+	}
+	return fmt.Sprintf("%s, weighted by %s", name, weight)
+}
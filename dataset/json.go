@@ -0,0 +1,390 @@
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go-spss/convert"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// ToJSON writes the dataset to a JSON file holding an array with an object per row, keyed by
+// column name. INTEGER and REAL values are numbers, TEXT values strings and NULL is null
+func (d Dataset) ToJSON(fileName string) error {
+	if err := d.toJSON(fileName); err != nil {
+		return fmt.Errorf(" -> ToJSON: %s", err)
+	}
+	return nil
+}
+
+func (d Dataset) toJSON(fileName string) (err error) {
+	names := d.columnNames()
+	keys := make([][]byte, len(names))
+	for i, name := range names {
+		keys[i], _ = json.Marshal(name)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot open output file: %s", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	out := bufio.NewWriter(f)
+
+	_, _ = out.WriteString("[")
+	n := 0
+	err = d.eachRow(names, func(values []interface{}) error {
+		if n > 0 {
+			_, _ = out.WriteString(",")
+		}
+		n++
+		_, _ = out.WriteString("\n  {")
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			value, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("row %d, column %s: %s", n, names[i], err)
+			}
+			if i > 0 {
+				_, _ = out.WriteString(", ")
+			}
+			_, _ = out.Write(keys[i])
+			_, _ = out.WriteString(": ")
+			_, _ = out.Write(value)
+		}
+		_, err := out.WriteString("}")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, _ = out.WriteString("\n]\n")
+	return out.Flush()
+}
+
+// ToNDJSON writes the dataset to a newline delimited JSON file with an object per row and line,
+// with the same values as ToJSON. With dictionary set the first line holds the dictionary of
+// the columns, with the labels, formats, measures, value labels and missing values stored by
+// FromSav, see convert.DictionaryKey
+func (d Dataset) ToNDJSON(fileName string, dictionary bool) error {
+	if err := d.toNDJSON(fileName, dictionary); err != nil {
+		return fmt.Errorf(" -> ToNDJSON: %s", err)
+	}
+	return nil
+}
+
+func (d Dataset) toNDJSON(fileName string, dictionary bool) (err error) {
+	label, names, headers, err := d.savHeaders()
+	if err != nil {
+		return err
+	}
+	var dict *convert.Dictionary
+	if dictionary {
+		header := convert.HeaderDictionary(label, headers, d.NumRows())
+		dict = &header
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot open output file: %s", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	out := bufio.NewWriter(f)
+
+	w, err := convert.NewNDJSONWriter(out, headers, dict)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(names))
+	err = d.eachRow(names, func(values []interface{}) error {
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = v
+		}
+		return w.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// FromNDJSON loads a newline delimited JSON file, an object per line, into a new Dataset named
+// after the file. The columns are the fields of the struct out, named by their spss tags, or
+// with a nil out every key found in the file, in the order the keys first appear. Their types
+// are inferred from the values as FromCSV does, booleans are stored as 0 and 1 and missing keys
+// and null are NULL. When the first line holds a dictionary, see convert.DictionaryKey, its
+// variables are loaded as FromSav does, numbers DOUBLE and strings TEXT, and it is stored with
+// the dataset, see Dictionary
+func (d *Dataset) FromNDJSON(fileName string, out interface{}) (dataset Dataset, err error) {
+	return d.logLoad(d.readNDJSON)(fileName, out)
+}
+
+func (d *Dataset) readNDJSON(in string, out interface{}) (dataset Dataset, err error) {
+
+	var empty Dataset
+
+	var columns []column
+	if out != nil {
+		if columns, err = structColumns(out); err != nil {
+			return empty, fmt.Errorf(" -> FromNDJSON: %s", err)
+		}
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return empty, fmt.Errorf(" -> FromNDJSON: cannot open ndjson file: %s", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// the first pass reads the dictionary, the keys and, without a struct, the column types
+	dict, dictLine, keys, columns, err := ndjsonSchema(f, columns)
+	if err != nil {
+		return empty, fmt.Errorf(" -> FromNDJSON: %s", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return empty, fmt.Errorf(" -> FromNDJSON: cannot rewind ndjson file: %s", err)
+	}
+
+	_, file := filepath.Split(in)
+	var name = identifier(strings.TrimSuffix(file, filepath.Ext(file)))
+
+	d, er := NewDataset(name, d.logger)
+	if er != nil {
+		return empty, fmt.Errorf(" -> FromNDJSON: cannot create a new DataSet: %s", er)
+	}
+
+	d.logger.Println("starting NDJSON file import")
+
+	d.tableMeta = make(map[string]reflect.Kind)
+	names := make([]string, len(columns))
+	variables := make(map[string]string, len(columns))
+	for i, col := range columns {
+		if err = d.AddColumn(col.Name, col.Type); err != nil {
+			return empty, fmt.Errorf(" -> FromNDJSON: cannot create column %s, of type %s", col.Name, col.Type)
+		}
+		d.tableMeta[col.Name] = col.Kind
+		names[i] = col.Name
+		variables[keys[i]] = col.Name
+	}
+
+	r := bufio.NewReader(f)
+	line := 0
+	err = d.bulkInsert(names, func(insert func(values []interface{}) error) error {
+		values := make([]interface{}, len(columns))
+		for {
+			raw, err := readLine(r, &line)
+			if err == nil && raw != nil && dict != nil && line == dictLine {
+				raw, err = readLine(r, &line)
+			}
+			if err != nil || raw == nil {
+				return err
+			}
+			_, object, err := jsonObject(raw)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			for i, col := range columns {
+				if values[i], err = jsonValue(object[keys[i]], col.Kind); err != nil {
+					return fmt.Errorf("line %d, key %s: %s", line, keys[i], err)
+				}
+			}
+			if err := insert(values); err != nil {
+				return err
+			}
+		}
+	}, func(tx sqlbuilder.Tx) error {
+		if dict == nil {
+			return nil
+		}
+		meta := dict.Metadata()
+		meta.FileName = file
+		return d.saveDictionary(tx, meta, variables)
+	})
+	if err != nil {
+		return empty, fmt.Errorf(" -> FromNDJSON: %s", err)
+	}
+	return *d, nil
+}
+
+// ndjsonSchema reads the whole file and returns its dictionary and the line holding it, if it
+// has one, and the columns with the key each is read from. Without columns from a struct, a
+// column is made for every variable of the dictionary and every other key of the objects
+func ndjsonSchema(in io.Reader, columns []column) (*convert.Dictionary, int, []string, []column, error) {
+	var dict *convert.Dictionary
+	var dictLine int
+	var keys []string
+	kinds := make(map[string]reflect.Kind)
+	fixed := make(map[string]bool) // keys typed by the dictionary
+	r := bufio.NewReader(in)
+	line := 0
+	for first := true; ; first = false {
+		raw, err := readLine(r, &line)
+		if err != nil {
+			return nil, 0, nil, nil, err
+		}
+		if raw == nil {
+			break
+		}
+		if first {
+			if dict, err = convert.NDJSONDictionary(raw); err != nil {
+				return nil, 0, nil, nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			if dict != nil {
+				dictLine = line
+				for _, v := range dict.Variables {
+					keys = append(keys, v.Name)
+					kinds[v.Name], fixed[v.Name] = reflect.Float64, true
+					if v.Type == convert.String {
+						kinds[v.Name] = reflect.String
+					}
+				}
+				continue
+			}
+		}
+
+		names, object, err := jsonObject(raw)
+		if err != nil {
+			return nil, 0, nil, nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		for _, key := range names {
+			kind, ok := kinds[key]
+			if !ok {
+				keys = append(keys, key)
+				kind = reflect.Int64
+			}
+			if !fixed[key] {
+				kind = jsonKind(kind, object[key])
+			}
+			kinds[key] = kind
+		}
+	}
+
+	if columns != nil {
+		keys = make([]string, len(columns))
+		for i, col := range columns {
+			keys[i] = col.Source
+		}
+		return dict, dictLine, keys, columns, nil
+	}
+	if len(keys) == 0 {
+		return nil, 0, nil, nil, errors.New("file has no rows")
+	}
+	for i, name := range uniqueNames(keys) {
+		col := column{Name: name, Kind: kinds[keys[i]], Field: -1}
+		col.Type, _ = kindColumnType(col.Kind)
+		columns = append(columns, col)
+	}
+	return dict, dictLine, keys, columns, nil
+}
+
+// readLine returns the next line of r that is not blank, nil at the end. line counts the lines
+// read
+func readLine(r *bufio.Reader, line *int) ([]byte, error) {
+	for {
+		raw, err := r.ReadBytes('\n')
+		if len(raw) > 0 {
+			*line++
+		}
+		if len(bytes.TrimSpace(raw)) > 0 {
+			return raw, nil
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// jsonObject decodes the JSON object raw, returning its keys in the order they are written.
+// Numbers are json.Number
+func jsonObject(raw []byte) ([]string, map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, errors.New("expected an object")
+	}
+	var keys []string
+	object := make(map[string]interface{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		switch value.(type) {
+		case nil, json.Number, string, bool:
+		default:
+			return nil, nil, fmt.Errorf("%s is not a number, string, boolean or null", key)
+		}
+		if _, ok := object[key]; !ok {
+			keys = append(keys, key)
+		}
+		object[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, object, nil
+}
+
+// jsonKind narrows the kind of a column to one that can hold value as well
+func jsonKind(kind reflect.Kind, value interface{}) reflect.Kind {
+	switch v := value.(type) {
+	case json.Number:
+		return inferKind(kind, v.String())
+	case string:
+		return reflect.String
+	}
+	return kind
+}
+
+// jsonValue converts a value decoded by jsonObject to the value stored in a column of kind
+func jsonValue(value interface{}, kind reflect.Kind) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if kind == reflect.String {
+			return strconv.FormatBool(v), nil
+		}
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case json.Number:
+		return parseValue(v.String(), kind)
+	case string:
+		return parseValue(v, kind)
+	}
+	return nil, fmt.Errorf("unexpected value %T", value)
+}
@@ -0,0 +1,336 @@
+package dataset
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// Query reads rows of a Dataset, see Select. A Query is not changed by its methods, each returns
+// a new Query so a query can be the start of several others
+type Query struct {
+	colLookup columnInfo // columns of the table when Select was called
+	columns   []string
+	sel       sqlbuilder.Selector // holds the session and the table name
+	ordered   bool
+	err       error
+}
+
+// Select starts a query of the columns cols, or of every column when there are none, for example
+//
+//	d.Select("Serial", "Age").Where("Age > ?", 16).OrderBy("-Age").Limit(10).All(&people)
+//
+// Rows are read in the order they were loaded unless OrderBy is used
+func (d Dataset) Select(cols ...string) Query {
+	q := Query{colLookup: d.columnMetadata(), columns: cols}
+	if len(q.columns) == 0 {
+		q.columns = d.columnNames()
+	}
+	columns := make([]interface{}, len(q.columns))
+	for i, col := range q.columns {
+		if _, ok := q.colLookup[col]; !ok {
+			q.err = fmt.Errorf(" -> Select: column %s does not exist", col)
+			return q
+		}
+		columns[i] = col
+	}
+	q.sel = d.DB.Select(columns...).From(d.tableName)
+	return q
+}
+
+// Where restricts the query to the rows matching conds, as DeleteWhere does, for example
+// Where("Age > ?", 16) or Where(db.Cond{"Sex": 1}). Calling it again adds conditions
+func (q Query) Where(conds ...interface{}) Query {
+	if q.err == nil {
+		q.sel = q.sel.Where(conds...)
+	}
+	return q
+}
+
+// OrderBy orders the rows by the columns cols, descending when a name starts with -
+func (q Query) OrderBy(cols ...string) Query {
+	if q.err != nil {
+		return q
+	}
+	columns := make([]interface{}, len(cols))
+	for i, col := range cols {
+		if _, ok := q.colLookup[strings.TrimPrefix(col, "-")]; !ok {
+			q.err = fmt.Errorf(" -> OrderBy: column %s does not exist", strings.TrimPrefix(col, "-"))
+			return q
+		}
+		columns[i] = col
+	}
+	q.sel = q.sel.OrderBy(columns...)
+	q.ordered = true
+	return q
+}
+
+// Limit reads at most n rows
+func (q Query) Limit(n int) Query {
+	if q.err == nil {
+		q.sel = q.sel.Limit(n)
+	}
+	return q
+}
+
+// Offset skips the first n rows
+func (q Query) Offset(n int) Query {
+	if q.err == nil {
+		q.sel = q.sel.Offset(n)
+	}
+	return q
+}
+
+// All reads every row into out, a pointer to a slice of structs or of map[string]interface{},
+// see Rows.Scan
+func (q Query) All(out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf(" -> All: %T is not a pointer to a slice", out)
+	}
+	rows, err := q.Iterator()
+	if err != nil {
+		return fmt.Errorf(" -> All%s", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	slice := reflect.MakeSlice(v.Elem().Type(), 0, 0)
+	for rows.Next() {
+		row := reflect.New(slice.Type().Elem())
+		if err := rows.Scan(row.Interface()); err != nil {
+			return fmt.Errorf(" -> All%s", err)
+		}
+		slice = reflect.Append(slice, row.Elem())
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf(" -> All%s", err)
+	}
+	v.Elem().Set(slice)
+	return nil
+}
+
+// One reads the first row into out, a pointer to a struct or a map[string]interface{}, and
+// returns false when there are no rows
+func (q Query) One(out interface{}) (bool, error) {
+	rows, err := q.Limit(1).Iterator()
+	if err != nil {
+		return false, fmt.Errorf(" -> One%s", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return false, fmt.Errorf(" -> One%s", err)
+		}
+		return false, nil
+	}
+	if err := rows.Scan(out); err != nil {
+		return false, fmt.Errorf(" -> One%s", err)
+	}
+	return true, nil
+}
+
+// Iterator runs the query and returns its rows to be read one at a time, for results too large
+// for All. The rows must be closed
+func (q Query) Iterator() (*Rows, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	sel := q.sel
+	if !q.ordered {
+		sel = sel.OrderBy("Row")
+	}
+	rows, err := sel.Query()
+	if err != nil {
+		return nil, fmt.Errorf(" -> Iterator: query failed: %s", err)
+	}
+	return &Rows{rows: rows, names: q.columns, values: make([]interface{}, len(q.columns))}, nil
+}
+
+// Rows are the rows read by a Query, see Iterator
+type Rows struct {
+	rows   *sql.Rows
+	names  []string
+	values []interface{}
+	fields map[reflect.Type][]int // struct field of each column, -1 when there is none
+	err    error
+}
+
+// Next reads the next row, returning false after the last or on an error, see Err
+func (r *Rows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	dest := make([]interface{}, len(r.values))
+	for i := range r.values {
+		dest[i] = &r.values[i]
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		r.err = fmt.Errorf(" -> Next: cannot read row: %s", err)
+		return false
+	}
+	return true
+}
+
+// Scan copies the row read by Next into out. out is a pointer to a map[string]interface{},
+// which is given a key for every column holding an int64, a float64, a string or nil for NULL,
+// or a pointer to a struct, whose fields are matched to the columns by their spss tags as
+// FromSav does. NULL is NaN in float fields and the zero value in others. Columns without a
+// field are skipped
+func (r *Rows) Scan(out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf(" -> Scan: %T is not a pointer", out)
+	}
+	v = v.Elem()
+
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Elem().Kind() == reflect.Interface:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for i, name := range r.names {
+			value := r.values[i]
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			if value == nil {
+				v.SetMapIndex(reflect.ValueOf(name), reflect.Zero(v.Type().Elem()))
+			} else {
+				v.SetMapIndex(reflect.ValueOf(name), reflect.ValueOf(value))
+			}
+		}
+	case v.Kind() == reflect.Struct:
+		fields, err := r.structFields(v.Type())
+		if err != nil {
+			return fmt.Errorf(" -> Scan: %s", err)
+		}
+		for i, field := range fields {
+			if field < 0 {
+				continue
+			}
+			if err := setField(v.Field(field), r.values[i]); err != nil {
+				return fmt.Errorf(" -> Scan: column %s: %s", r.names[i], err)
+			}
+		}
+	default:
+		return fmt.Errorf(" -> Scan: %T is not a pointer to a struct or a map[string]interface{}", out)
+	}
+	return nil
+}
+
+// Err returns the error that stopped Next, if any
+func (r *Rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	if err := r.rows.Err(); err != nil {
+		return fmt.Errorf(" -> Next: cannot read row: %s", err)
+	}
+	return nil
+}
+
+// Close releases the rows, it is safe to call more than once
+func (r *Rows) Close() error {
+	return r.rows.Close()
+}
+
+// structFields returns the field of the struct t holding each column
+func (r *Rows) structFields(t reflect.Type) ([]int, error) {
+	if fields, ok := r.fields[t]; ok {
+		return fields, nil
+	}
+	columns, err := structColumns(reflect.New(t).Interface())
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(columns))
+	for _, col := range columns {
+		index[strings.ToLower(col.Name)] = col.Field
+	}
+	fields := make([]int, len(r.names))
+	for i, name := range r.names {
+		fields[i] = -1
+		if field, ok := index[strings.ToLower(name)]; ok {
+			fields[i] = field
+		}
+	}
+	if r.fields == nil {
+		r.fields = make(map[reflect.Type][]int)
+	}
+	r.fields[t] = fields
+	return fields, nil
+}
+
+// setField sets the field f to a value read from SQLite, converting between numbers and text
+func setField(f reflect.Value, value interface{}) error {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if value == nil {
+		f.Set(reflect.Zero(f.Type()))
+		if f.Kind() == reflect.Float32 || f.Kind() == reflect.Float64 {
+			f.SetFloat(math.NaN())
+		}
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(csvText(value, nil, CSVWriteOptions{}))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch v := value.(type) {
+		case int64:
+			f.SetFloat(float64(v))
+		case float64:
+			f.SetFloat(v)
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", v)
+			}
+			f.SetFloat(n)
+		}
+		return nil
+	}
+
+	var n int64
+	switch v := value.(type) {
+	case int64:
+		n = v
+	case float64:
+		if v != math.Trunc(v) {
+			return fmt.Errorf("%v is not an integer", v)
+		}
+		n = int64(v)
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, f.Type())
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || f.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%d overflows %s", n, f.Type())
+		}
+		f.SetUint(uint64(n))
+	default:
+		return fmt.Errorf("cannot set a %s field", f.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	spss "go-spss"
+)
+
+// ToSav writes the dataset to a SAV file. INTEGER columns are written as numbers without
+// decimals, FLOAT and DOUBLE columns as numbers and TEXT columns as strings, with NULL numbers
+// written as system missing. Columns loaded by FromSav keep the label, format, measure, value
+// labels and missing values of their variable from the stored dictionary, see Dictionary, and
+// the weight of the dataset is the weight variable of the file
+func (d Dataset) ToSav(fileName string) error {
+	label, names, headers, err := d.savHeaders()
+	if err != nil {
+		return err
+	}
+	// the rows are streamed from the table instead of being copied into memory first
+	err = spss.ExportRows(context.Background(), fileName, label, headers, func(write func(row []interface{}) error) error {
+		return d.savRows(names, headers, write)
+	}, nil)
+	var writeErr *spss.WriteError
+	if errors.As(err, &writeErr) {
+		return fmt.Errorf(" -> ToSav: cannot write sav file: %s", err)
+	}
+	if err != nil {
+		return fmt.Errorf(" -> ToSav: %s", err)
+	}
+	return nil
+}
+
+// savHeaders returns the file label from the stored dictionary and the names and headers of the
+// columns, with the weight of the dataset marked
+func (d Dataset) savHeaders() (string, []string, []spss.Header, error) {
+	meta, err := d.Dictionary()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var label string
+	variables := make(map[string]spss.Variable)
+	if meta != nil {
+		label = meta.FileLabel
+		for _, v := range meta.Variables {
+			variables[v.Name] = v
+		}
+	}
+
+	weight, err := d.Weight()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	names := d.columnNames()
+	types := d.columnMetadata()
+	headers := make([]spss.Header, len(names))
+	for i, name := range names {
+		headers[i] = savHeader(name, types[name])
+		if v, ok := variables[name]; ok {
+			applyVariable(&headers[i], v)
+		}
+		headers[i].Weight = name == weight
+	}
+	return label, names, headers, nil
+}
+
+// savHeader returns the header of a column declared as declType, using SQLite's rules for the
+// affinity of a column type
+func savHeader(name, declType string) spss.Header {
+	h := spss.Header{SavType: spss.ReadstatTypeDouble, Name: name}
+	t := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(t, "INT"):
+		h.Format = "F8.0"
+	case t == string(spss.FLOAT):
+		h.SavType = spss.ReadstatTypeFloat
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"), t == "":
+		h.SavType = spss.ReadstatTypeString
+	}
+	return h
+}
+
+// applyVariable copies the dictionary of v onto h. Value labels and missing values are
+// converted when the column no longer has the type of the variable, those that cannot be are
+// dropped
+func applyVariable(h *spss.Header, v spss.Variable) {
+	isString := h.SavType == spss.ReadstatTypeString
+	h.Label = v.Label
+	h.Measure = v.Measure
+	if !isString && v.Type != spss.ReadstatTypeString && v.Format != "" {
+		h.Format = v.Format
+	}
+
+	for _, l := range v.ValueLabels {
+		if value, ok := dictionaryValue(l.Value, isString); ok {
+			h.ValueLabels = append(h.ValueLabels, spss.ValueLabel{Value: value, Label: l.Label})
+		}
+	}
+	for _, m := range v.Missing {
+		lo, okLo := dictionaryValue(m.Lo, isString)
+		hi, okHi := dictionaryValue(m.Hi, isString)
+		if okLo && okHi && (!isString || lo == hi) {
+			h.Missing = append(h.Missing, spss.MissingRange{Lo: lo, Hi: hi})
+		}
+	}
+}
+
+// dictionaryValue converts a value label or missing value to a string or a float64
+func dictionaryValue(value interface{}, isString bool) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if isString {
+			return v, true
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case float64:
+		if isString {
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+// savRows passes the rows of the columns names to write in row order, as the values expected
+// by headers
+func (d Dataset) savRows(names []string, headers []spss.Header, write func(row []interface{}) error) error {
+	row := make([]interface{}, len(names))
+	n := 0
+	return d.eachRow(names, func(values []interface{}) error {
+		n++
+		for i, h := range headers {
+			v, err := savDataValue(values[i], h.SavType)
+			if err != nil {
+				return fmt.Errorf("row %d, column %s: %s", n, names[i], err)
+			}
+			row[i] = v
+		}
+		return write(row)
+	})
+}
+
+// savDataValue converts a value read from SQLite to the value written for a variable of savType
+func savDataValue(value interface{}, savType spss.ColumnType) (interface{}, error) {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if savType == spss.ReadstatTypeString {
+		switch v := value.(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return nil, fmt.Errorf("unexpected value %T", value)
+	}
+
+	var f float64
+	switch v := value.(type) {
+	case nil:
+		f = math.NaN()
+	case int64:
+		f = float64(v)
+	case float64:
+		f = v
+	case string:
+		if strings.TrimSpace(v) == "" {
+			f = math.NaN()
+			break
+		}
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected value %T", value)
+	}
+	if savType == spss.ReadstatTypeFloat {
+		return float32(f), nil
+	}
+	return f, nil
+}
@@ -0,0 +1,146 @@
+package dataset
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	spss "go-spss"
+)
+
+// column is a column of a Dataset table built from a struct field or a file's header
+type column struct {
+	Name   string // column name
+	Source string // variable, header or key the column of a struct field is read from
+	Kind   reflect.Kind
+	Type   spss.ColumnTypes
+	Field  int // index of the struct field, -1 when there is no struct
+}
+
+// structColumns returns the columns for the exported fields of the struct out, or of the struct
+// out points to. A field is read from the variable named by its spss tag, or by its own name
+// without one, and is skipped when the tag is "-". Column names are made identifiers by uniqueNames
+func structColumns(out interface{}) ([]column, error) {
+	t := reflect.TypeOf(out)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a struct type", out)
+	}
+
+	var columns []column
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("spss"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		columnType, err := kindColumnType(f.Type.Kind())
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", f.Name, err)
+		}
+		columns = append(columns, column{Source: name, Kind: f.Type.Kind(), Type: columnType, Field: i})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%T has no exported fields", out)
+	}
+	sources := make([]string, len(columns))
+	for i, col := range columns {
+		sources[i] = col.Source
+	}
+	for i, name := range uniqueNames(sources) {
+		columns[i].Name = name
+	}
+	return columns, nil
+}
+
+// kindColumnType maps the kind of a struct field onto the SQLite column type storing it
+func kindColumnType(kind reflect.Kind) (spss.ColumnTypes, error) {
+	switch kind {
+	case reflect.String:
+		return spss.STRING, nil
+	case reflect.Int8, reflect.Uint8:
+		return spss.INT, nil
+	case reflect.Int, reflect.Int32, reflect.Uint32:
+		return spss.INT, nil
+	case reflect.Int64, reflect.Uint64:
+		return spss.INT, nil
+	case reflect.Float32:
+		return spss.FLOAT, nil
+	case reflect.Float64:
+		return spss.DOUBLE, nil
+	}
+	return "", fmt.Errorf("cannot store a %s in a column", kind)
+}
+
+// parseValue converts the text s to the value stored in a column of kind. Empty numbers are
+// NULL
+func parseValue(s string, kind reflect.Kind) (interface{}, error) {
+	if kind == reflect.String {
+		return s, nil
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch kind {
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return f, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// integers are often written with a decimal point by SPSS and spreadsheets
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != float64(int64(f)) {
+			return nil, fmt.Errorf("%q is not an integer", s)
+		}
+		n = int64(f)
+	}
+	return n, nil
+}
+
+// identifier makes name usable as an unquoted SQLite table or column name
+func identifier(name string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(name) {
+		if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit(rune(id[0])) {
+		id = "V" + id
+	}
+	return id
+}
+
+// uniqueNames makes every name an identifier, numbering repeats so the names stay distinct.
+// Names are compared without case as SQLite does, and Row is taken by the primary key
+func uniqueNames(names []string) []string {
+	ids := make([]string, len(names))
+	used := map[string]bool{"row": true}
+	for i, name := range names {
+		id := identifier(name)
+		for n := 2; used[strings.ToLower(id)]; n++ {
+			id = fmt.Sprintf("%s_%d", identifier(name), n)
+		}
+		used[strings.ToLower(id)] = true
+		ids[i] = id
+	}
+	return ids
+}
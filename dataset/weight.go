@@ -0,0 +1,143 @@
+package dataset
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	spss "go-spss"
+	"upper.io/db.v3"
+)
+
+type weightRow struct {
+	Dataset string `db:"dataset"`
+	Column  string `db:"column_name"`
+}
+
+// SetWeight weights the statistics of the dataset by the numeric column col, as WEIGHT BY does in
+// SPSS. FromSav weights a dataset by the weight variable of its file. An empty col turns
+// weighting off
+func (d Dataset) SetWeight(col string) error {
+	if col != "" {
+		if err := d.weightColumn(col); err != nil {
+			return fmt.Errorf(" -> SetWeight: %s", err)
+		}
+	}
+	if err := createDictionaryTables(d.DB); err != nil {
+		return fmt.Errorf(" -> SetWeight: cannot create dictionary tables: %s", err)
+	}
+	if _, err := d.DB.DeleteFrom(weightsTable).Where(db.Cond{"dataset": d.tableName}).Exec(); err != nil {
+		return fmt.Errorf(" -> SetWeight: cannot delete weight: %s", err)
+	}
+	if col == "" {
+		return nil
+	}
+	if _, err := d.DB.InsertInto(weightsTable).Values(weightRow{d.tableName, col}).Exec(); err != nil {
+		return fmt.Errorf(" -> SetWeight: cannot save weight: %s", err)
+	}
+	return nil
+}
+
+// Weight returns the column weighting the dataset, empty when it is not weighted or the column
+// has since been dropped
+func (d Dataset) Weight() (string, error) {
+	if err := createDictionaryTables(d.DB); err != nil {
+		return "", fmt.Errorf(" -> Weight: cannot create dictionary tables: %s", err)
+	}
+	var weight weightRow
+	err := d.DB.SelectFrom(weightsTable).Where(db.Cond{"dataset": d.tableName}).One(&weight)
+	if err == db.ErrNoMoreRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf(" -> Weight: cannot read weight: %s", err)
+	}
+	if d.weightColumn(weight.Column) != nil {
+		return "", nil
+	}
+	return weight.Column, nil
+}
+
+// weightColumn checks col exists and is numeric
+func (d Dataset) weightColumn(col string) error {
+	ok, colLookup := d.doesColumnExist(col)
+	if !ok {
+		return fmt.Errorf("column %s does not exist", col)
+	}
+	if savHeader(col, colLookup[col]).SavType == spss.ReadstatTypeString {
+		return fmt.Errorf("column %s is not numeric", col)
+	}
+	return nil
+}
+
+// WeightedMean returns the mean of col with every row counted weight times. Rows with a NULL,
+// zero or negative weight are left out as SPSS does, the mean is NaN when no rows are left
+func (d Dataset) WeightedMean(col, weight string) (float64, error) {
+	res, err := d.weighted(fmt.Sprintf("sum(%s * %s) / sum(%s)", col, weight, weight), col, weight)
+	if err != nil {
+		return 0.0, fmt.Errorf(" -> WeightedMean: %s", err)
+	}
+	return res, nil
+}
+
+// Total returns the sum of col, weighted by the weight of the dataset when it has one
+func (d Dataset) Total(col string) (float64, error) {
+	weight, err := d.Weight()
+	if err != nil {
+		return 0.0, fmt.Errorf(" -> Total%s", err)
+	}
+	if weight == "" {
+		res, err := d.weighted(fmt.Sprintf("total(%s)", col), col, "")
+		if err != nil {
+			return 0.0, fmt.Errorf(" -> Total: %s", err)
+		}
+		return res, nil
+	}
+	res, err := d.WeightedTotal(col, weight)
+	if err != nil {
+		return 0.0, fmt.Errorf(" -> Total%s", err)
+	}
+	return res, nil
+}
+
+// WeightedTotal returns the sum of col with every row counted weight times, the estimate of the
+// population total of a survey. Rows are left out as by WeightedMean
+func (d Dataset) WeightedTotal(col, weight string) (float64, error) {
+	res, err := d.weighted(fmt.Sprintf("total(%s * %s)", col, weight), col, weight)
+	if err != nil {
+		return 0.0, fmt.Errorf(" -> WeightedTotal: %s", err)
+	}
+	return res, nil
+}
+
+// weighted computes the aggregate expr over the rows where col is not NULL and, when weight is
+// not empty, weight is positive. A NULL result is NaN
+func (d Dataset) weighted(expr, col, weight string) (float64, error) {
+	ok, colLookup := d.doesColumnExist(col)
+	if !ok {
+		return 0.0, fmt.Errorf("column %s does not exist", col)
+	}
+	if savHeader(col, colLookup[col]).SavType == spss.ReadstatTypeString {
+		return 0.0, fmt.Errorf("column %s is not numeric", col)
+	}
+	where := fmt.Sprintf("%s is not null", col)
+	if weight != "" {
+		if err := d.weightColumn(weight); err != nil {
+			return 0.0, fmt.Errorf("weight: %s", err)
+		}
+		where += fmt.Sprintf(" and %s > 0", weight)
+	}
+
+	row, err := d.DB.QueryRow(fmt.Sprintf("select %s from %s where %s", expr, d.tableName, where))
+	if err != nil {
+		return 0.0, err
+	}
+	var res sql.NullFloat64
+	if err := row.Scan(&res); err != nil {
+		return 0.0, err
+	}
+	if !res.Valid {
+		return math.NaN(), nil
+	}
+	return res.Float64, nil
+}
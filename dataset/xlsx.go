@@ -0,0 +1,71 @@
+package dataset
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"go-spss/convert"
+)
+
+// ToXLSX writes the dataset to an Excel workbook with the rows on the Data sheet and the name,
+// label, type, format, measure, value labels and missing values of every column on the
+// Variables sheet, see convert.XLSXWriter. With labels set, values with a value label in the
+// stored dictionary are written as the label
+func (d Dataset) ToXLSX(fileName string, labels bool) error {
+	if err := d.toXLSX(fileName, labels); err != nil {
+		return fmt.Errorf(" -> ToXLSX: %s", err)
+	}
+	return nil
+}
+
+func (d Dataset) toXLSX(fileName string, labels bool) (err error) {
+	label, names, headers, err := d.savHeaders()
+	if err != nil {
+		return err
+	}
+	var valueLabels []map[interface{}]string
+	if labels {
+		if valueLabels, err = d.valueLabels(names); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot open output file: %s", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	out := bufio.NewWriter(f)
+
+	w, err := convert.NewXLSXWriter(out, label, headers)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(names))
+	err = d.eachRow(names, func(values []interface{}) error {
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			if valueLabels != nil {
+				if l, ok := columnLabel(valueLabels[i], v); ok {
+					v = l
+				}
+			}
+			row[i] = v
+		}
+		return w.Write(row)
+	})
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
@@ -1,7 +1,7 @@
 package spss
 
 import (
-	"encoding/csv"
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -11,6 +11,11 @@ type Reader interface {
 	Read(rows interface{}) error
 }
 
+// ContextReader is implemented by readers that support cancellation and progress reporting
+type ContextReader interface {
+	ReadContext(ctx context.Context, rows interface{}, progress ProgressFunc, opts ...Option) error
+}
+
 // Example buffered implementation
 type BufferInput struct {
 	inputType string
@@ -26,6 +31,10 @@ type FileInput struct {
 }
 
 func (f FileInput) Read(out interface{}) error {
+	return f.ReadContext(context.Background(), out, nil)
+}
+
+func (f FileInput) ReadContext(ctx context.Context, out interface{}, progress ProgressFunc, opts ...Option) error {
 	outValue, outType := getConcreteReflectValueAndType(out) // Get the concrete type (not pointer) (Slice<?> or Array<?>)
 	if err := ensureOutType(outType); err != nil {
 		return err
@@ -36,7 +45,7 @@ func (f FileInput) Read(out interface{}) error {
 		return err
 	}
 
-	spssRows, err := Import(f.inputType)
+	spssRows, err := ImportContext(ctx, f.inputType, progress, opts...)
 	if err != nil {
 		return err
 	}
@@ -70,11 +79,13 @@ func (f FileInput) Read(out interface{}) error {
 
 	if FailIfUnmatchedStructTags {
 		if err := maybeMissingStructFields(outInnerStructInfo.Fields, headers); err != nil {
+			err.File = f.inputType
 			return err
 		}
 	}
 	if FailIfDoubleHeaderNames {
 		if err := maybeDoubleHeaderNames(headers); err != nil {
+			err.File = f.inputType
 			return err
 		}
 	}
@@ -84,10 +95,13 @@ func (f FileInput) Read(out interface{}) error {
 		for j, csvColumnContent := range csvRow {
 			if fieldInfo, ok := spssHeadersLabels[j]; ok { // Position found accordingly to header name
 				if err := setInnerField(&outInner, outInnerWasPointer, fieldInfo.IndexChain, csvColumnContent, fieldInfo.omitEmpty); err != nil { // Set field of struct
-					return &csv.ParseError{
-						Line:   i + 2, //add 2 to account for the header & 0-indexing of arrays
-						Column: j + 1,
-						Err:    err,
+					return &ParseError{
+						Code:     ErrConvert,
+						File:     f.inputType,
+						Row:      i + 1,
+						Column:   j + 1,
+						Variable: headers[j],
+						Err:      err,
 					}
 				}
 			}
@@ -123,20 +137,20 @@ func mismatchStructFields(structInfo []fieldInfo, headers []string) []string {
 	return missing
 }
 
-func maybeMissingStructFields(structInfo []fieldInfo, headers []string) error {
+func maybeMissingStructFields(structInfo []fieldInfo, headers []string) *SchemaError {
 	missing := mismatchStructFields(structInfo, headers)
 	if len(missing) != 0 {
-		return fmt.Errorf("found unmatched struct field with tags %v", missing)
+		return &SchemaError{Missing: missing}
 	}
 	return nil
 }
 
 // Check that no header name is repeated twice
-func maybeDoubleHeaderNames(headers []string) error {
+func maybeDoubleHeaderNames(headers []string) *SchemaError {
 	headerMap := make(map[string]bool, len(headers))
 	for _, v := range headers {
 		if _, ok := headerMap[v]; ok {
-			return fmt.Errorf("repeated header name: %v", v)
+			return &SchemaError{Duplicate: v}
 		}
 		headerMap[v] = true
 	}
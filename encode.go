@@ -1,6 +1,7 @@
 package spss
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"reflect"
@@ -11,6 +12,11 @@ type Writer interface {
 	Write(rows interface{}) error
 }
 
+// ContextWriter is implemented by writers that support cancellation and progress reporting
+type ContextWriter interface {
+	WriteContext(ctx context.Context, rows interface{}, progress ProgressFunc, opts ...Option) error
+}
+
 // Example buffered implementation
 type BufferOutput struct {
 	inputType string
@@ -26,6 +32,10 @@ type FileOutput struct {
 }
 
 func (f FileOutput) Write(rows interface{}) error {
+	return f.WriteContext(context.Background(), rows, nil)
+}
+
+func (f FileOutput) WriteContext(ctx context.Context, rows interface{}, progress ProgressFunc, opts ...Option) error {
 
 	inValue, inType := getConcreteReflectValueAndType(rows) // Get the concrete type (not pointer) (Slice<?> or Array<?>)
 	if err := ensureInType(inType); err != nil {
@@ -60,7 +70,7 @@ func (f FileOutput) Write(rows interface{}) error {
 			return fmt.Errorf("cannot convert type for struct variable %s into SPSS type", fieldInfo.keys[0])
 		}
 
-		header = append(header, Header{spssType, fieldInfo.keys[0], fieldInfo.keys[0] + ""})
+		header = append(header, Header{SavType: spssType, Name: fieldInfo.keys[0], Label: fieldInfo.keys[0] + ""})
 	}
 
 	if inValue.Kind() != reflect.Slice {
@@ -69,6 +79,9 @@ func (f FileOutput) Write(rows interface{}) error {
 
 	inLen := inValue.Len()
 	for i := 0; i < inLen; i++ { // Iterate over container rows
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		var dataItem []interface{}
 		for j, fieldInfo := range inInnerStructInfo.Fields {
 			header[j].Label = ""
@@ -87,7 +100,8 @@ func (f FileOutput) Write(rows interface{}) error {
 			case reflect.Int, reflect.Int32, reflect.Uint32:
 				spssType, _ = strconv.Atoi(inInnerFieldValue)
 			case reflect.Float32:
-				spssType, _ = strconv.ParseFloat(inInnerFieldValue, 32)
+				f, _ := strconv.ParseFloat(inInnerFieldValue, 32)
+				spssType = float32(f)
 			case reflect.Float64:
 				spssType, _ = strconv.ParseFloat(inInnerFieldValue, 64)
 			default:
@@ -100,13 +114,11 @@ func (f FileOutput) Write(rows interface{}) error {
 
 	}
 
-	val := Export(f.inputType, "Test SAV from GO", header, data)
-
-	if val != 0 {
-		return fmt.Errorf("cannot open or write to file: %s", f.inputType)
+	if err := ExportContext(ctx, f.inputType, "Test SAV from GO", header, data, progress, opts...); err != nil {
+		return err
 	}
 
-	log.Printf("Finished writing to: %s, return value: %d", f.inputType, val)
+	log.Printf("Finished writing to: %s", f.inputType)
 
 	return nil
 }
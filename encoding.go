@@ -0,0 +1,35 @@
+package spss
+
+import (
+	"strings"
+)
+
+// Option sets an option of a single read or write, see WithEncoding
+type Option func(*options)
+
+type options struct {
+	encoding string
+}
+
+// WithEncoding sets the character encoding of a SAV file. Reading, it overrides the encoding
+// recorded in the file, for example "WINDOWS-1252" for legacy files with a missing or incorrect
+// encoding record, and strings are still returned as UTF-8. Writing, it is the encoding strings
+// are written in, UTF-8 when it is not set
+func WithEncoding(encoding string) Option {
+	return func(o *options) {
+		o.encoding = encoding
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func isUTF8(encoding string) bool {
+	e := strings.ToUpper(strings.Replace(encoding, "-", "", -1))
+	return e == "" || e == "UTF8"
+}
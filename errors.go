@@ -0,0 +1,145 @@
+package spss
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorCode is a ReadStat error code. ErrorCodes are errors so a failure can be tested with
+// errors.Is, for example errors.Is(err, spss.ErrOpen)
+type ErrorCode int
+
+const (
+	ErrOpen                   ErrorCode = 1
+	ErrRead                   ErrorCode = 2
+	ErrMalloc                 ErrorCode = 3
+	ErrUserAbort              ErrorCode = 4
+	ErrParse                  ErrorCode = 5
+	ErrUnsupportedCompression ErrorCode = 6
+	ErrUnsupportedCharset     ErrorCode = 7
+	ErrColumnCountMismatch    ErrorCode = 8
+	ErrRowCountMismatch       ErrorCode = 9
+	ErrRowWidthMismatch       ErrorCode = 10
+	ErrBadFormatString        ErrorCode = 11
+	ErrValueTypeMismatch      ErrorCode = 12
+	ErrWrite                  ErrorCode = 13
+	ErrWriterNotInitialized   ErrorCode = 14
+	ErrSeek                   ErrorCode = 15
+	ErrConvert                ErrorCode = 16
+)
+
+var errorMessages = map[ErrorCode]string{
+	ErrOpen:                   "unable to open file",
+	ErrRead:                   "unable to read from file",
+	ErrMalloc:                 "unable to allocate memory",
+	ErrUserAbort:              "the parsing was aborted",
+	ErrParse:                  "invalid file, or file has unsupported features",
+	ErrUnsupportedCompression: "file has an unsupported compression scheme",
+	ErrUnsupportedCharset:     "file has an unsupported character set",
+	ErrColumnCountMismatch:    "file did not contain the expected number of columns",
+	ErrRowCountMismatch:       "file did not contain the expected number of rows",
+	ErrRowWidthMismatch:       "a row in the file was not the expected length",
+	ErrBadFormatString:        "a provided format string could not be understood",
+	ErrValueTypeMismatch:      "a provided value was incompatible with the variable type",
+	ErrWrite:                  "unable to write data",
+	ErrWriterNotInitialized:   "the writer object was not properly initialized",
+	ErrSeek:                   "unable to seek within file",
+	ErrConvert:                "unable to convert string to the requested encoding",
+}
+
+func (c ErrorCode) Error() string {
+	if msg, ok := errorMessages[c]; ok {
+		return msg
+	}
+	return fmt.Sprintf("readstat error %d", int(c))
+}
+
+// ParseError is returned when a SAV file cannot be read or a value in it cannot be converted.
+// Row and Column are 1 based and zero when not known
+type ParseError struct {
+	Code     ErrorCode
+	File     string
+	Row      int
+	Column   int
+	Variable string
+	Message  string
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	b.WriteString("spss: ")
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(": ")
+	}
+	if e.Row > 0 {
+		fmt.Fprintf(&b, "row %d: ", e.Row)
+	}
+	if e.Variable != "" {
+		fmt.Fprintf(&b, "variable %s: ", e.Variable)
+	} else if e.Column > 0 {
+		fmt.Fprintf(&b, "column %d: ", e.Column)
+	}
+	switch {
+	case e.Err != nil:
+		b.WriteString(e.Err.Error())
+	case e.Message != "":
+		b.WriteString(e.Message)
+	default:
+		b.WriteString(e.Code.Error())
+	}
+	return b.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the ReadStat error code of e
+func (e *ParseError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && e.Code != 0 && code == e.Code
+}
+
+// WriteError is returned when a SAV file cannot be written
+type WriteError struct {
+	Code    ErrorCode
+	File    string
+	Message string
+	Err     error
+}
+
+func (e *WriteError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("spss: %s: %s", e.File, e.Err)
+	case e.Message != "":
+		return fmt.Sprintf("spss: %s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("spss: %s: %s", e.File, e.Code)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the ReadStat error code of e
+func (e *WriteError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && e.Code != 0 && code == e.Code
+}
+
+// SchemaError is returned when the spss tags of a struct do not match the variables in a file
+type SchemaError struct {
+	File      string
+	Missing   []string
+	Duplicate string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Duplicate != "" {
+		return fmt.Sprintf("spss: %s: repeated header name: %v", e.File, e.Duplicate)
+	}
+	return fmt.Sprintf("spss: %s: found unmatched struct field with tags %v", e.File, e.Missing)
+}
@@ -0,0 +1,194 @@
+package spss
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateOptions controls the Go source written by Generate
+type GenerateOptions struct {
+	Package  string // package clause of the generated file, main if empty
+	TypeName string // name of the row struct, Record if empty
+	Source   string // file name mentioned in the generated header, defaults to the metadata file name
+}
+
+// Generate writes a Go source file declaring a struct with one field per variable in meta.
+// Fields carry spss tags and the variable label as their doc comment. Numeric variables are
+// float64 and string variables string. Every value label set becomes a named type with a
+// constant per label and a String method, and the variables using the set are declared with it
+func Generate(w io.Writer, meta *Metadata, opts GenerateOptions) error {
+	if opts.Package == "" {
+		opts.Package = "main"
+	}
+	if opts.TypeName == "" {
+		opts.TypeName = "Record"
+	}
+	if opts.Source == "" {
+		opts.Source = meta.FileName
+	}
+	if !isIdentifier(opts.Package) || !isIdentifier(opts.TypeName) {
+		return fmt.Errorf("spss: invalid package or type name %q %q", opts.Package, opts.TypeName)
+	}
+
+	used := map[string]bool{opts.TypeName: true}
+	setTypes := make(map[string]string)
+	numericLabels := false
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by go-spss gen from %s; DO NOT EDIT.\n\n", opts.Source)
+	fmt.Fprintf(&b, "package %s\n\n", opts.Package)
+
+	var types bytes.Buffer
+	for _, v := range meta.Variables {
+		if len(v.ValueLabels) == 0 {
+			continue
+		}
+		key := labelSetKey(v)
+		if _, ok := setTypes[key]; ok {
+			continue
+		}
+		name := uniqueIdentifier(opts.TypeName+goIdentifier(v.Name), used)
+		setTypes[key] = name
+		writeLabelType(&types, name, v, used)
+		numericLabels = numericLabels || v.Type != ReadstatTypeString
+	}
+	if numericLabels {
+		b.WriteString("import \"strconv\"\n\n")
+	}
+
+	if meta.FileLabel != "" {
+		fmt.Fprintf(&b, "// %s is a row of %s\n", opts.TypeName, commentText(meta.FileLabel))
+	} else {
+		fmt.Fprintf(&b, "// %s is a row of %s\n", opts.TypeName, commentText(opts.Source))
+	}
+	fmt.Fprintf(&b, "type %s struct {\n", opts.TypeName)
+	fields := make(map[string]bool)
+	for _, v := range meta.Variables {
+		typ := "float64"
+		if v.Type == ReadstatTypeString {
+			typ = "string"
+		}
+		if name, ok := setTypes[labelSetKey(v)]; ok && len(v.ValueLabels) > 0 {
+			typ = name
+		}
+		if v.Label != "" {
+			fmt.Fprintf(&b, "\t// %s\n", commentText(v.Label))
+		}
+		fmt.Fprintf(&b, "\t%s %s `spss:%s`\n", uniqueIdentifier(goIdentifier(v.Name), fields), typ, strconv.Quote(v.Name))
+	}
+	b.WriteString("}\n\n")
+	b.Write(types.Bytes())
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("spss: formatting generated source: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func writeLabelType(b *bytes.Buffer, name string, v Variable, used map[string]bool) {
+	base, zero := "float64", "strconv.FormatFloat(float64(v), 'f', -1, 64)"
+	if v.Type == ReadstatTypeString {
+		base, zero = "string", "string(v)"
+	}
+
+	fmt.Fprintf(b, "// %s holds the labelled values of %s\n", name, v.Name)
+	fmt.Fprintf(b, "type %s %s\n\n", name, base)
+
+	consts := make([]string, len(v.ValueLabels))
+	b.WriteString("const (\n")
+	for i, l := range v.ValueLabels {
+		value := goLiteral(l.Value)
+		ident := goIdentifier(l.Label)
+		if ident == "V" {
+			ident = goIdentifier(strings.TrimPrefix(value, "-"))
+		}
+		consts[i] = uniqueIdentifier(name+ident, used)
+		fmt.Fprintf(b, "\t// %s is %s\n", consts[i], commentText(l.Label))
+		fmt.Fprintf(b, "\t%s %s = %s\n", consts[i], name, value)
+	}
+	b.WriteString(")\n\n")
+
+	fmt.Fprintf(b, "// String returns the value label of v\n")
+	fmt.Fprintf(b, "func (v %s) String() string {\n\tswitch v {\n", name)
+	seen := make(map[string]bool)
+	for i, l := range v.ValueLabels {
+		value := goLiteral(l.Value)
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		fmt.Fprintf(b, "\tcase %s:\n\t\treturn %s\n", consts[i], strconv.Quote(l.Label))
+	}
+	fmt.Fprintf(b, "\t}\n\treturn %s\n}\n\n", zero)
+}
+
+// labelSetKey identifies the value labels of v, variables sharing a label set share a type
+func labelSetKey(v Variable) string {
+	if v.LabelSet != "" {
+		return v.LabelSet
+	}
+	return "\x00" + v.Name
+}
+
+func goLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
+
+// goIdentifier turns a variable name or label into an exported Go identifier, for example
+// q1_a becomes Q1A and "Not at all" becomes NotAtAll
+func goIdentifier(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	id := b.String()
+	if first := []rune(id); len(first) == 0 || !unicode.IsUpper(first[0]) {
+		id = "V" + id
+	}
+	return id
+}
+
+func uniqueIdentifier(id string, used map[string]bool) string {
+	name := id
+	for i := 2; used[name]; i++ {
+		name = id + strconv.Itoa(i)
+	}
+	used[name] = true
+	return name
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// commentText puts s on a single line so it can be used in a // comment
+func commentText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
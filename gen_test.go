@@ -0,0 +1,49 @@
+package spss
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func Test_generate(t *testing.T) {
+
+	meta := &Metadata{
+		FileName: "survey.sav",
+		Variables: []Variable{
+			{Name: "Serial", Label: "Serial number", Type: ReadstatTypeDouble},
+			{Name: "q1_sex", Label: "Sex of\nrespondent", Type: ReadstatTypeDouble, LabelSet: "labels0",
+				ValueLabels: []ValueLabel{{1.0, "Male"}, {2.0, "Female"}, {-9.0, "-"}}},
+			{Name: "q2_sex", Type: ReadstatTypeDouble, LabelSet: "labels0",
+				ValueLabels: []ValueLabel{{1.0, "Male"}, {2.0, "Female"}, {-9.0, "-"}}},
+			{Name: "Version", Type: ReadstatTypeString},
+		},
+	}
+
+	var src bytes.Buffer
+	if err := Generate(&src, meta, GenerateOptions{Package: "survey", TypeName: "Survey"}); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "survey.go", src.Bytes(), 0); err != nil {
+		t.Errorf("Generate returned invalid Go: %v\n%s", err, src.String())
+	}
+
+	got := strings.Join(strings.Fields(src.String()), " ")
+	for _, want := range []string{
+		"// Code generated by go-spss gen from survey.sav; DO NOT EDIT.",
+		"Serial float64 `spss:\"Serial\"`",
+		"// Sex of respondent",
+		"Q1Sex SurveyQ1Sex `spss:\"q1_sex\"`",
+		"Q2Sex SurveyQ1Sex `spss:\"q2_sex\"`",
+		"SurveyQ1SexFemale SurveyQ1Sex = 2",
+		"SurveyQ1SexV9 SurveyQ1Sex = -9",
+		"Version string `spss:\"Version\"`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Generate output is missing, got: %s, want: %s.", got, want)
+		}
+	}
+}
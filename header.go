@@ -0,0 +1,125 @@
+package spss
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Header describes a variable to write. The dictionary fields after Label are optional
+type Header struct {
+	SavType ColumnType
+	Name    string
+	Label   string
+
+	Format      string // print and write format of a numeric variable such as F8.2, a default for the type when empty
+	Measure     Measure
+	ValueLabels []ValueLabel   // Value is a float64 for numeric variables and a string for strings
+	Missing     []MissingRange // up to three discrete values, or one range and one discrete value
+	Weight      bool           // the variable weights the cases, at most one numeric variable of a file
+}
+
+type DataItem struct {
+	Value []interface{}
+}
+
+// validateHeaders checks the value labels and missing values of every header match its type and
+// fit in a SAV dictionary. Strings can only have discrete missing values of up to 8 bytes and
+// only one numeric variable can be the weight
+func validateHeaders(headers []Header) error {
+	weight := ""
+	for _, h := range headers {
+		isString := h.SavType == ReadstatTypeString
+		if h.Weight {
+			if isString {
+				return fmt.Errorf("variable %s: a string cannot be the weight", h.Name)
+			}
+			if weight != "" {
+				return fmt.Errorf("variable %s: %s is already the weight", h.Name, weight)
+			}
+			weight = h.Name
+		}
+		for _, l := range h.ValueLabels {
+			if !dictionaryValue(l.Value, isString) {
+				return fmt.Errorf("variable %s: invalid value label value %T", h.Name, l.Value)
+			}
+		}
+		values, ranges := 0, 0
+		for _, m := range h.Missing {
+			if !dictionaryValue(m.Lo, isString) || !dictionaryValue(m.Hi, isString) {
+				return fmt.Errorf("variable %s: invalid missing value %T", h.Name, m.Lo)
+			}
+			if s, ok := m.Lo.(string); ok && len(s) > 8 {
+				return fmt.Errorf("variable %s: missing value %q is longer than 8 bytes", h.Name, s)
+			}
+			values++
+			if m.Lo != m.Hi {
+				if isString {
+					return fmt.Errorf("variable %s: a string cannot have a missing range", h.Name)
+				}
+				values++
+				ranges++
+			}
+		}
+		if values > 3 || ranges > 1 {
+			return fmt.Errorf("variable %s: too many missing values", h.Name)
+		}
+	}
+	return nil
+}
+
+func dictionaryValue(v interface{}, isString bool) bool {
+	if isString {
+		_, ok := v.(string)
+		return ok
+	}
+	_, ok := v.(float64)
+	return ok
+}
+
+// validateData checks every value matches the type of its header before anything is
+// allocated or written
+func validateData(headers []Header, data []DataItem) error {
+	for i, r := range data {
+		if err := validateRow(headers, i, r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRow checks the values of row i, counting from 0, match the types of headers
+func validateRow(headers []Header, i int, row []interface{}) error {
+	if len(row) != len(headers) {
+		return fmt.Errorf("row %d has %d values, expected %d", i+1, len(row), len(headers))
+	}
+	for j, col := range row {
+		var ok bool
+		var expected string
+		switch headers[j].SavType {
+		case ReadstatTypeString:
+			_, ok = col.(string)
+			expected = "string"
+		case ReadstatTypeInt8:
+			_, ok = col.(int)
+			expected = "int8"
+		case ReadstatTypeInt16:
+			_, ok = col.(int)
+			expected = "int16"
+		case ReadstatTypeInt32:
+			_, ok = col.(int)
+			expected = "int32"
+		case ReadstatTypeFloat:
+			_, ok = col.(float32)
+			expected = "float32"
+		case ReadstatTypeDouble:
+			_, ok = col.(float64)
+			expected = "double"
+		case ReadstatTypeStringRef:
+			return errors.New("string references not supported")
+		}
+		if !ok {
+			return fmt.Errorf("row %d, variable %s: invalid type %T, %s expected", i+1, headers[j].Name, col, expected)
+		}
+	}
+	return nil
+}
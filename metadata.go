@@ -0,0 +1,146 @@
+package spss
+
+import "time"
+
+// Measure is the SPSS measurement level of a variable
+type Measure int
+
+const (
+	MeasureUnknown Measure = iota
+	MeasureNominal
+	MeasureOrdinal
+	MeasureScale
+)
+
+func (m Measure) String() string {
+	switch m {
+	case MeasureNominal:
+		return "nominal"
+	case MeasureOrdinal:
+		return "ordinal"
+	case MeasureScale:
+		return "scale"
+	}
+	return "unknown"
+}
+
+// ValueLabel maps a single value of a variable to its label. Value is a float64 for
+// numeric variables and a string for string variables
+type ValueLabel struct {
+	Value interface{}
+	Label string
+}
+
+// MissingRange is a user defined missing value or range of values. For discrete missing
+// values Lo and Hi are the same
+type MissingRange struct {
+	Lo interface{}
+	Hi interface{}
+}
+
+// Variable describes a single column in the SAV dictionary
+type Variable struct {
+	Index        int
+	Name         string
+	Label        string
+	Format       string
+	Type         ColumnType
+	StorageWidth int
+	DisplayWidth int
+	Measure      Measure
+	LabelSet     string
+	ValueLabels  []ValueLabel
+	Missing      []MissingRange
+}
+
+// IsMissing reports whether value is system missing (nil) or a user defined missing value
+func (v Variable) IsMissing(value interface{}) bool {
+	switch val := value.(type) {
+	case nil:
+		return true
+	case float64:
+		for _, m := range v.Missing {
+			lo, okLo := m.Lo.(float64)
+			hi, okHi := m.Hi.(float64)
+			if okLo && okHi && val >= lo && val <= hi {
+				return true
+			}
+		}
+	case string:
+		for _, m := range v.Missing {
+			if m.Lo == val || m.Hi == val {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LabelFor returns the value label for value, or an empty string if there is none
+func (v Variable) LabelFor(value interface{}) string {
+	for _, l := range v.ValueLabels {
+		if l.Value == value {
+			return l.Label
+		}
+	}
+	return ""
+}
+
+// Metadata is the dictionary of a SAV file
+type Metadata struct {
+	FileName      string
+	FileLabel     string
+	Encoding      string
+	RowCount      int
+	VarCount      int
+	Created       time.Time
+	Modified      time.Time
+	FormatVersion int
+	Is64Bit       bool
+	Compressed    bool
+	Weight        string // name of the weight variable, empty when the file is not weighted
+	Variables     []Variable
+	LabelSets     map[string][]ValueLabel
+}
+
+// Variable returns the variable called name
+func (m Metadata) Variable(name string) (Variable, bool) {
+	for _, v := range m.Variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variable{}, false
+}
+
+// Names returns the variable names in file order
+func (m Metadata) Names() []string {
+	names := make([]string, len(m.Variables))
+	for i, v := range m.Variables {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// resolveLabels attaches each label set to the variables that reference it. ReadStat does not
+// guarantee value labels are reported before the variables that use them so this is done once
+// the dictionary has been read
+func (m *Metadata) resolveLabels() {
+	for i := range m.Variables {
+		if m.Variables[i].LabelSet == "" {
+			continue
+		}
+		m.Variables[i].ValueLabels = m.LabelSets[m.Variables[i].LabelSet]
+	}
+}
+
+// Inspect reads only the dictionary of a SAV file, returning the variables, labels and row count
+// without reading the data section
+func Inspect(fileName string, opts ...Option) (*Metadata, error) {
+	meta, err := inspect(fileName, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	meta.resolveLabels()
+	return meta, nil
+}
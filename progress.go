@@ -0,0 +1,17 @@
+package spss
+
+// progressRows is how often, in rows, progress is reported and cancellation checked by the
+// native reader and writer
+const progressRows = 10000
+
+// Progress is reported periodically while a SAV file is read or written. Total is -1 when the
+// number of rows in a file being read is not known
+type Progress struct {
+	Rows    int
+	Total   int
+	Percent float64
+}
+
+// ProgressFunc receives progress reports. It is called on the goroutine doing the read or write
+// so should return quickly
+type ProgressFunc func(Progress)
@@ -0,0 +1,163 @@
+package spss
+
+import (
+	"context"
+	"math"
+	"strconv"
+)
+
+// Record is a single row of a SAV file read without a predeclared struct. Values are looked up
+// by variable name using the file's own dictionary
+type Record struct {
+	schema *recordSchema
+	values []interface{}
+}
+
+type recordSchema struct {
+	metadata *Metadata
+	index    map[string]int
+}
+
+// ReadRecords reads every row of a SAV file using the file's dictionary instead of a struct
+// with spss tags
+func ReadRecords(fileName string) ([]Record, *Metadata, error) {
+	return ReadRecordsContext(context.Background(), fileName, nil)
+}
+
+// ReadRecordsContext is ReadRecords with cancellation, progress reporting and options
+func ReadRecordsContext(ctx context.Context, fileName string, progress ProgressFunc, opts ...Option) ([]Record, *Metadata, error) {
+	var records []Record
+	schema := &recordSchema{}
+
+	meta, err := parseSav(ctx, fileName, progress, func(row []interface{}) error {
+		values := make([]interface{}, len(row))
+		copy(values, row)
+		records = append(records, Record{schema, values})
+		return nil
+	}, newOptions(opts))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta.resolveLabels()
+	schema.metadata = meta
+	schema.index = make(map[string]int, len(meta.Variables))
+	for i, v := range meta.Variables {
+		schema.index[v.Name] = i
+	}
+
+	return records, meta, nil
+}
+
+// ReadRows passes every row of a SAV file to fn as it is read, so the file is never held in
+// memory. The row is reused for the next one, fn must copy any values it keeps. Use Inspect to
+// get the dictionary before the rows
+func ReadRows(ctx context.Context, fileName string, progress ProgressFunc, fn func(row []interface{}) error, opts ...Option) (*Metadata, error) {
+	meta, err := parseSav(ctx, fileName, progress, fn, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	meta.resolveLabels()
+	return meta, nil
+}
+
+// ReadMaps reads every row of a SAV file into a map keyed by variable name. Numeric values are
+// float64, strings are string and system missing values are nil
+func ReadMaps(fileName string) ([]map[string]interface{}, error) {
+	records, _, err := ReadRecords(fileName)
+	if err != nil {
+		return nil, err
+	}
+	maps := make([]map[string]interface{}, len(records))
+	for i, r := range records {
+		maps[i] = r.Map()
+	}
+	return maps, nil
+}
+
+// Metadata returns the dictionary of the file the record was read from
+func (r Record) Metadata() *Metadata {
+	return r.schema.metadata
+}
+
+// Has reports whether the file has a variable called name
+func (r Record) Has(name string) bool {
+	_, ok := r.schema.index[name]
+	return ok
+}
+
+// Value returns the raw value of variable name: a float64, a string or nil when system missing
+// or the variable does not exist
+func (r Record) Value(name string) interface{} {
+	if i, ok := r.schema.index[name]; ok {
+		return r.values[i]
+	}
+	return nil
+}
+
+// Values returns the raw values in file order
+func (r Record) Values() []interface{} {
+	return r.values
+}
+
+// Float returns a numeric variable, NaN if it is system missing. Strings are parsed if possible
+func (r Record) Float(name string) float64 {
+	switch v := r.Value(name).(type) {
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+// Int returns a numeric variable truncated to an integer, zero if it is missing
+func (r Record) Int(name string) int64 {
+	f := r.Float(name)
+	if math.IsNaN(f) {
+		return 0
+	}
+	return int64(f)
+}
+
+// String returns a string variable, numeric variables are formatted without trailing zeros and
+// system missing values are an empty string
+func (r Record) String(name string) string {
+	switch v := r.Value(name).(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// IsMissing reports whether variable name is system missing or one of the variable's user
+// defined missing values
+func (r Record) IsMissing(name string) bool {
+	i, ok := r.schema.index[name]
+	if !ok || r.values[i] == nil {
+		return true
+	}
+	return r.schema.metadata.Variables[i].IsMissing(r.values[i])
+}
+
+// Label returns the value label of variable name, or an empty string if there is none
+func (r Record) Label(name string) string {
+	i, ok := r.schema.index[name]
+	if !ok {
+		return ""
+	}
+	return r.schema.metadata.Variables[i].LabelFor(r.values[i])
+}
+
+// Map returns the record as a map keyed by variable name
+func (r Record) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(r.values))
+	for i, v := range r.schema.metadata.Variables {
+		m[v.Name] = r.values[i]
+	}
+	return m
+}
@@ -0,0 +1,230 @@
+package spss
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SAV record types
+const (
+	recordVariable      = 2
+	recordValueLabels   = 3
+	recordLabelVars     = 4
+	recordDocument      = 6
+	recordExtension     = 7
+	recordEndDictionary = 999
+)
+
+// SAV extension record subtypes
+const (
+	extIntegerInfo       = 3
+	extFloatInfo         = 4
+	extDisplay           = 11
+	extLongNames         = 13
+	extVeryLongString    = 14
+	extNumberOfCases     = 16
+	extEncoding          = 20
+	extLongStringLabels  = 21
+	extLongStringMissing = 22
+)
+
+// SAV compression codes
+const (
+	compressNone     = 0
+	compressBytecode = 1
+	compressZlib     = 2
+)
+
+// bytecode compression opcodes, codes 1 to 251 are the number code - bias
+const (
+	opcodePadding = 0
+	opcodeEOF     = 252
+	opcodeRaw     = 253
+	opcodeSpaces  = 254
+	opcodeSysmis  = 255
+)
+
+const (
+	savBias          = 100.0
+	savSegmentWidth  = 252 // data bytes in each segment of a very long string
+	savMaxShortWidth = 255
+	savMaxWidth      = 32767
+	savLayoutCode    = 2
+)
+
+var (
+	savSysmis  = -math.MaxFloat64
+	savHighest = math.MaxFloat64
+	savLowest  = math.Nextafter(-math.MaxFloat64, 0)
+)
+
+// format types used in print and write formats
+var formatNames = map[int]string{
+	1: "A", 2: "AHEX", 3: "COMMA", 4: "DOLLAR", 5: "F", 6: "IB", 7: "PIBHEX", 8: "P", 9: "PIB", 10: "PK",
+	11: "RB", 12: "RBHEX", 15: "Z", 16: "N", 17: "E", 20: "DATE", 21: "TIME", 22: "DATETIME", 23: "ADATE",
+	24: "JDATE", 25: "DTIME", 26: "WKDAY", 27: "MONTH", 28: "MOYR", 29: "QYR", 30: "WKYR", 31: "PCT",
+	32: "DOT", 33: "CCA", 34: "CCB", 35: "CCC", 36: "CCD", 37: "CCE", 38: "EDATE", 39: "SDATE",
+	40: "MTIME", 41: "YMDHMS",
+}
+
+const (
+	formatA = 1
+	formatF = 5
+)
+
+func formatString(format int32) string {
+	typ := int(format>>16) & 0xff
+	width := int(format>>8) & 0xff
+	decimals := int(format) & 0xff
+	name, ok := formatNames[typ]
+	if !ok {
+		return ""
+	}
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d", name, width)
+	}
+	return fmt.Sprintf("%s%d.%d", name, width, decimals)
+}
+
+// parseFormat parses a format such as F8.2 or DATE11
+func parseFormat(s string) (int32, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexAny(s, "0123456789")
+	if i <= 0 {
+		return 0, false
+	}
+	typ := -1
+	for t, name := range formatNames {
+		if name == s[:i] {
+			typ = t
+		}
+	}
+	width, decimals := s[i:], "0"
+	if j := strings.IndexByte(width, '.'); j >= 0 {
+		width, decimals = width[:j], width[j+1:]
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil || typ < 0 || w < 1 || w > 255 {
+		return 0, false
+	}
+	d, err := strconv.Atoi(decimals)
+	if err != nil || d < 0 || d > 16 || d >= w {
+		return 0, false
+	}
+	return makeFormat(typ, w, d), true
+}
+
+func makeFormat(typ, width, decimals int) int32 {
+	return int32(typ<<16 | width<<8 | decimals)
+}
+
+// --------------------------------------------------------------------------
+// Character encodings. Only UTF-8 and the single byte western code pages found in legacy
+// files are supported natively, anything else is read as UTF-8 with invalid bytes replaced
+
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// codePages maps the character code in the machine integer info record to an encoding name
+var codePages = map[int32]string{
+	2:     "US-ASCII",
+	3:     "WINDOWS-1252",
+	1252:  "WINDOWS-1252",
+	20127: "US-ASCII",
+	28591: "ISO-8859-1",
+	65001: "UTF-8",
+}
+
+type charset int
+
+const (
+	charsetUTF8 charset = iota
+	charsetWindows1252
+	charsetLatin1
+)
+
+func lookupCharset(encoding string) (charset, bool) {
+	switch strings.ToUpper(strings.Replace(strings.Replace(encoding, "-", "", -1), "_", "", -1)) {
+	case "", "UTF8":
+		return charsetUTF8, true
+	case "WINDOWS1252", "CP1252", "USASCII", "ASCII":
+		return charsetWindows1252, true
+	case "ISO88591", "LATIN1", "CP28591":
+		return charsetLatin1, true
+	}
+	return charsetUTF8, false
+}
+
+func (c charset) decode(b []byte) string {
+	switch c {
+	case charsetWindows1252, charsetLatin1:
+		var s strings.Builder
+		s.Grow(len(b))
+		for _, ch := range b {
+			switch {
+			case ch < 0x80:
+				s.WriteByte(ch)
+			case ch < 0xa0 && c == charsetWindows1252:
+				s.WriteRune(windows1252[ch-0x80])
+			default:
+				s.WriteRune(rune(ch))
+			}
+		}
+		return s.String()
+	}
+	return strings.ToValidUTF8(string(b), "\uFFFD")
+}
+
+func (c charset) encode(s string) []byte {
+	if c == charsetUTF8 {
+		return []byte(s)
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b = append(b, byte(r))
+		case r >= 0xa0 && r <= 0xff:
+			b = append(b, byte(r))
+		default:
+			ch := byte('?')
+			if c == charsetWindows1252 {
+				for i, w := range windows1252 {
+					if w == r && w != utf8.RuneError {
+						ch = byte(0x80 + i)
+						break
+					}
+				}
+			}
+			b = append(b, ch)
+		}
+	}
+	return b
+}
+
+// trimString removes the space padding from a fixed width string
+func trimString(b []byte) []byte {
+	end := len(b)
+	for end > 0 && (b[end-1] == ' ' || b[end-1] == 0) {
+		end--
+	}
+	return b[:end]
+}
+
+// truncateUTF8 cuts b to at most n bytes without splitting a character
+func truncateUTF8(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return b[:n]
+}
@@ -0,0 +1,688 @@
+package spss
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// exportNative writes a SAV file without ReadStat. It is the writer of the native backend, and of
+// the readstat backend for encodings other than UTF-8
+func exportNative(ctx context.Context, fileName string, label string, headers []Header, data []DataItem, progress ProgressFunc, o options) error {
+
+	if err := validateHeaders(headers); err != nil {
+		return &WriteError{Code: ErrValueTypeMismatch, File: fileName, Err: err}
+	}
+	if err := validateData(headers, data); err != nil {
+		return &WriteError{Code: ErrValueTypeMismatch, File: fileName, Err: err}
+	}
+	w, err := newSavWriter(fileName, o)
+	if err != nil {
+		return err
+	}
+
+	widths := make([]int, len(headers))
+	for _, r := range data {
+		w.measure(headers, widths, r.Value)
+	}
+	i := 0
+	return w.export(ctx, fileName, label, headers, widths, len(data), func() ([]interface{}, error) {
+		i++
+		return data[i-1].Value, nil
+	}, progress)
+}
+
+// ExportRows writes a SAV file from rows passed one at a time to the write function given to
+// rows, for data too large to hold in memory as DataItems. Values are as in Export and write
+// copies them, so a row can be reused. Strings are as wide as their longest value, so the rows
+// are kept in a temporary file until rows returns. The native writer is used with both backends
+func ExportRows(ctx context.Context, fileName string, label string, headers []Header, rows func(write func(row []interface{}) error) error, progress ProgressFunc, opts ...Option) error {
+
+	if err := validateHeaders(headers); err != nil {
+		return &WriteError{Code: ErrValueTypeMismatch, File: fileName, Err: err}
+	}
+	w, err := newSavWriter(fileName, newOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "go-spss-rows-*")
+	if err != nil {
+		return &WriteError{Code: ErrOpen, File: fileName, Err: err}
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	spool := bufio.NewWriter(tmp)
+
+	widths := make([]int, len(headers))
+	n := 0
+	err = rows(func(row []interface{}) error {
+		if n%progressRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := validateRow(headers, n, row); err != nil {
+			return &WriteError{Code: ErrValueTypeMismatch, File: fileName, Err: err}
+		}
+		w.measure(headers, widths, row)
+		n++
+		return spoolRow(spool, headers, row)
+	})
+	if err != nil {
+		return err
+	}
+	if err := spool.Flush(); err != nil {
+		return &WriteError{Code: ErrWrite, File: fileName, Err: err}
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return &WriteError{Code: ErrWrite, File: fileName, Err: err}
+	}
+
+	r := bufio.NewReader(tmp)
+	row := make([]interface{}, len(headers))
+	return w.export(ctx, fileName, label, headers, widths, n, func() ([]interface{}, error) {
+		return row, unspoolRow(r, headers, row)
+	}, progress)
+}
+
+// spoolRow writes row to the temporary file of ExportRows, numbers as 8 bytes and strings after
+// their length
+func spoolRow(b *bufio.Writer, headers []Header, row []interface{}) error {
+	var n [8]byte
+	for i, v := range row {
+		if headers[i].SavType == ReadstatTypeString {
+			s := v.(string)
+			binary.LittleEndian.PutUint32(n[:4], uint32(len(s)))
+			_, _ = b.Write(n[:4])
+			_, _ = b.WriteString(s)
+			continue
+		}
+		binary.LittleEndian.PutUint64(n[:], math.Float64bits(number(v)))
+		_, _ = b.Write(n[:])
+	}
+	// bufio keeps the first error, returned by the next write or Flush
+	_, err := b.Write(nil)
+	return err
+}
+
+// unspoolRow reads a row written by spoolRow into row
+func unspoolRow(r *bufio.Reader, headers []Header, row []interface{}) error {
+	var n [8]byte
+	for i, h := range headers {
+		if h.SavType == ReadstatTypeString {
+			if _, err := io.ReadFull(r, n[:4]); err != nil {
+				return err
+			}
+			b := make([]byte, binary.LittleEndian.Uint32(n[:4]))
+			if _, err := io.ReadFull(r, b); err != nil {
+				return err
+			}
+			row[i] = string(b)
+			continue
+		}
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return err
+		}
+		row[i] = math.Float64frombits(binary.LittleEndian.Uint64(n[:]))
+	}
+	return nil
+}
+
+// newSavWriter returns a writer of strings in the encoding of o, UTF-8 when it is not set
+func newSavWriter(fileName string, o options) (*savWriter, error) {
+	encoding := o.encoding
+	if encoding == "" {
+		encoding = "UTF-8"
+	}
+	cs, ok := lookupCharset(encoding)
+	if !ok {
+		return nil, &WriteError{Code: ErrUnsupportedCharset, File: fileName, Message: fmt.Sprintf("cannot write encoding %s", encoding)}
+	}
+	return &savWriter{charset: cs}, nil
+}
+
+// measure widens widths to the encoded length of every string of row
+func (w *savWriter) measure(headers []Header, widths []int, row []interface{}) {
+	for i, h := range headers {
+		if h.SavType == ReadstatTypeString {
+			if n := len(w.charset.encode(row[i].(string))); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+}
+
+// export writes a file of headers, with strings widths wide, and rows rows read one at a time
+// by next
+func (w *savWriter) export(ctx context.Context, fileName string, label string, headers []Header, widths []int, rows int, next func() ([]interface{}, error), progress ProgressFunc) error {
+	if err := w.layout(headers, widths); err != nil {
+		return &WriteError{Code: ErrBadFormatString, File: fileName, Err: err}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return &WriteError{Code: ErrOpen, File: fileName, Err: err}
+	}
+	// a file that is not written completely is removed rather than left to be read as truncated
+	written := false
+	defer func() {
+		_ = f.Close()
+		if !written {
+			_ = os.Remove(fileName)
+		}
+	}()
+	w.w = bufio.NewWriter(f)
+	w.writeDictionary(label, rows)
+
+	for i := 0; i < rows; i++ {
+		if i%progressRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(Progress{i, rows, float64(i) / float64(rows) * 100})
+			}
+		}
+		row, err := next()
+		if err != nil {
+			return &WriteError{Code: ErrWrite, File: fileName, Err: err}
+		}
+		w.writeCase(row)
+	}
+	w.flushCommands()
+
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err == nil {
+		w.err = f.Close()
+	}
+	if w.err != nil {
+		return &WriteError{Code: ErrWrite, File: fileName, Err: w.err}
+	}
+	written = true
+
+	if progress != nil {
+		progress(Progress{rows, rows, 100})
+	}
+	return nil
+}
+
+// savSegment is one variable record. Strings wider than 255 bytes are written as several segments
+type savSegment struct {
+	name   string
+	width  int
+	offset int
+}
+
+type savColumn struct {
+	header   Header
+	name     string
+	width    int
+	format   int32 // print and write format of a number
+	label    []byte
+	segments []savSegment
+}
+
+type savWriter struct {
+	w        *bufio.Writer
+	err      error
+	charset  charset
+	columns  []savColumn
+	elements int
+
+	cmd  [8]byte
+	ncmd int
+	raw  bytes.Buffer
+}
+
+func (w *savWriter) write(v interface{}) {
+	if w.err == nil {
+		w.err = binary.Write(w.w, binary.LittleEndian, v)
+	}
+}
+
+func (w *savWriter) writeString(s []byte, n int) {
+	b := bytes.Repeat([]byte{' '}, n)
+	copy(b, s)
+	w.write(b)
+}
+
+// layout works out the width, format, short names and segments of every column. widths are the
+// lengths of the longest value of every string
+func (w *savWriter) layout(headers []Header, widths []int) error {
+	used := make(map[string]bool)
+	for i, h := range headers {
+		c := savColumn{header: h, label: truncateUTF8(w.charset.encode(h.Label), 255)}
+		switch {
+		case h.SavType == ReadstatTypeString:
+		case h.Format != "":
+			format, ok := parseFormat(h.Format)
+			if !ok {
+				return fmt.Errorf("variable %s: invalid format %q", h.Name, h.Format)
+			}
+			c.format = format
+		case h.SavType == ReadstatTypeFloat || h.SavType == ReadstatTypeDouble:
+			c.format = makeFormat(formatF, 8, 2)
+		default:
+			c.format = makeFormat(formatF, 8, 0)
+		}
+		if h.SavType == ReadstatTypeString {
+			c.width = widths[i]
+			if c.width == 0 {
+				c.width = 1
+			}
+			// wide enough for the labelled values too, so they are not cut short
+			for _, l := range h.ValueLabels {
+				if n := len(w.charset.encode(l.Value.(string))); n > c.width {
+					c.width = n
+				}
+			}
+			if c.width > savMaxWidth {
+				c.width = savMaxWidth
+			}
+		}
+		c.name = shortName(h.Name, used)
+
+		if c.width <= savMaxShortWidth {
+			c.segments = []savSegment{{c.name, c.width, w.elements}}
+			w.elements += elements(c.width)
+		} else {
+			n := (c.width + savSegmentWidth - 1) / savSegmentWidth
+			for s := 0; s < n; s++ {
+				segment := savSegment{c.name, savMaxShortWidth, w.elements}
+				if s > 0 {
+					segment.name = shortName(c.name, used)
+				}
+				if s == n-1 {
+					segment.width = c.width - savSegmentWidth*s
+				}
+				c.segments = append(c.segments, segment)
+				w.elements += elements(segment.width)
+			}
+		}
+		w.columns = append(w.columns, c)
+	}
+	return nil
+}
+
+// weight is the case element of the weight variable counting from 1, 0 when there is none
+func (w *savWriter) weight() int {
+	for _, c := range w.columns {
+		if c.header.Weight {
+			return c.segments[0].offset + 1
+		}
+	}
+	return 0
+}
+
+// elements is the number of 8 byte case elements used by a variable of width
+func elements(width int) int {
+	if width == 0 {
+		return 1
+	}
+	return (width + 7) / 8
+}
+
+// shortName creates a unique upper case name of at most 8 bytes for the variable records, the
+// full name is written to the long variable names record
+func shortName(name string, used map[string]bool) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if r < 0x80 && b.Len() < 8 && (r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '@' || r == '#' || r == '$' || r == '.') {
+			b.WriteRune(r)
+		}
+	}
+	base := b.String()
+	if base == "" || base[0] < 'A' || base[0] > 'Z' {
+		base = "V" + base
+		if len(base) > 8 {
+			base = base[:8]
+		}
+	}
+	short := base
+	for i := 1; used[short]; i++ {
+		suffix := fmt.Sprintf("%d", i)
+		prefix := base
+		if len(prefix)+len(suffix) > 8 {
+			prefix = prefix[:8-len(suffix)]
+		}
+		short = prefix + suffix
+	}
+	used[short] = true
+	return short
+}
+
+func (w *savWriter) writeDictionary(label string, rows int) {
+	now := time.Now()
+
+	w.write([]byte("$FL2"))
+	w.writeString([]byte("@(#) SPSS DATA FILE - go-spss"), 60)
+	w.write(int32(savLayoutCode))
+	w.write(int32(w.elements))
+	w.write(int32(compressBytecode))
+	w.write(int32(w.weight()))
+	w.write(int32(rows))
+	w.write(savBias)
+	w.writeString([]byte(now.Format("02 Jan 06")), 9)
+	w.writeString([]byte(now.Format("15:04:05")), 8)
+	w.writeString(truncateUTF8(w.charset.encode(label), 64), 64)
+	w.writeString(nil, 3)
+
+	for _, c := range w.columns {
+		for i, s := range c.segments {
+			format := c.format
+			if s.width > 0 {
+				format = makeFormat(formatA, s.width, 0)
+			}
+			hasLabel := int32(0)
+			if i == 0 && len(c.label) > 0 {
+				hasLabel = 1
+			}
+			var nMissing int32
+			var missing [][]byte
+			if i == 0 {
+				nMissing, missing = w.missingValues(c)
+			}
+
+			w.write(int32(recordVariable))
+			w.write(int32(s.width))
+			w.write(hasLabel)
+			w.write(nMissing)
+			w.write(format)
+			w.write(format)
+			w.writeString([]byte(s.name), 8)
+			if hasLabel == 1 {
+				w.write(int32(len(c.label)))
+				w.writeString(c.label, (len(c.label)+3)/4*4)
+			}
+			for _, value := range missing {
+				w.write(value)
+			}
+
+			for j := 1; j < elements(s.width); j++ {
+				w.write(int32(recordVariable))
+				w.write([]int32{-1, 0, 0, 0, 0})
+				w.writeString(nil, 8)
+			}
+		}
+	}
+
+	for _, c := range w.columns {
+		w.writeValueLabels(c)
+	}
+
+	encoding, charCode := "UTF-8", int32(65001)
+	switch w.charset {
+	case charsetWindows1252:
+		encoding, charCode = "windows-1252", 1252
+	case charsetLatin1:
+		encoding, charCode = "ISO-8859-1", 28591
+	}
+
+	w.write([]int32{recordExtension, extIntegerInfo, 4, 8, 1, 0, 0, -1, 1, 1, 2, charCode})
+	w.write([]int32{recordExtension, extFloatInfo, 8, 3})
+	w.write([]float64{savSysmis, savHighest, savLowest})
+
+	var display []int32
+	for _, c := range w.columns {
+		for _, s := range c.segments {
+			measure := c.header.Measure
+			if s.width == 0 {
+				if measure == MeasureUnknown {
+					measure = MeasureScale
+				}
+				display = append(display, int32(measure), 8, 1)
+			} else {
+				if measure == MeasureUnknown {
+					measure = MeasureNominal
+				}
+				display = append(display, int32(measure), int32(minInt(s.width, 40)), 0)
+			}
+		}
+	}
+	w.write([]int32{recordExtension, extDisplay, 4, int32(len(display))})
+	w.write(display)
+
+	var names, veryLong bytes.Buffer
+	for i, c := range w.columns {
+		if i > 0 {
+			names.WriteByte('\t')
+		}
+		names.WriteString(c.name)
+		names.WriteByte('=')
+		names.Write(w.charset.encode(c.header.Name))
+		if len(c.segments) > 1 {
+			fmt.Fprintf(&veryLong, "%s=%05d\x00\t", c.name, c.width)
+		}
+	}
+	w.writeExtension(extLongNames, names.Bytes())
+	if veryLong.Len() > 0 {
+		w.writeExtension(extVeryLongString, veryLong.Bytes())
+	}
+
+	w.write([]int32{recordExtension, extNumberOfCases, 8, 2})
+	w.write([]int64{1, int64(rows)})
+	w.writeExtension(extEncoding, []byte(encoding))
+	w.writeLongStrings()
+
+	w.write([]int32{recordEndDictionary, 0})
+}
+
+// writeLongStrings writes the value labels and missing values of strings longer than 8 bytes,
+// which do not fit in the value label and variable records
+func (w *savWriter) writeLongStrings() {
+	var labels, missing bytes.Buffer
+	for _, c := range w.columns {
+		if c.width <= 8 {
+			continue
+		}
+		name := w.charset.encode(c.header.Name)
+		if len(c.header.ValueLabels) > 0 {
+			putInt32(&labels, len(name))
+			labels.Write(name)
+			putInt32(&labels, c.width, len(c.header.ValueLabels))
+			for _, l := range c.header.ValueLabels {
+				value := bytes.Repeat([]byte{' '}, c.width)
+				copy(value, w.charset.encode(l.Value.(string)))
+				label := truncateUTF8(w.charset.encode(l.Label), 120)
+				putInt32(&labels, len(value))
+				labels.Write(value)
+				putInt32(&labels, len(label))
+				labels.Write(label)
+			}
+		}
+		if len(c.header.Missing) > 0 {
+			putInt32(&missing, len(name))
+			missing.Write(name)
+			missing.WriteByte(byte(len(c.header.Missing)))
+			putInt32(&missing, 8)
+			for _, m := range c.header.Missing {
+				missing.Write(w.dictionaryValue(m.Lo))
+			}
+		}
+	}
+	if labels.Len() > 0 {
+		w.writeExtension(extLongStringLabels, labels.Bytes())
+	}
+	if missing.Len() > 0 {
+		w.writeExtension(extLongStringMissing, missing.Bytes())
+	}
+}
+
+// dictionaryValue encodes a value label or missing value as the 8 bytes stored in the dictionary
+func (w *savWriter) dictionaryValue(v interface{}) []byte {
+	b := make([]byte, 8)
+	switch v := v.(type) {
+	case string:
+		copy(b, bytes.Repeat([]byte{' '}, 8))
+		copy(b, w.charset.encode(v))
+	case float64:
+		switch {
+		case math.IsInf(v, -1):
+			v = savLowest
+		case math.IsInf(v, 1):
+			v = savHighest
+		}
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// missingValues returns the missing value count of the variable record of c and the values
+// following it, a range first. Those of strings longer than 8 bytes are written by
+// writeLongStrings instead
+func (w *savWriter) missingValues(c savColumn) (int32, [][]byte) {
+	if len(c.header.Missing) == 0 || c.width > 8 {
+		return 0, nil
+	}
+	var values, ranges [][]byte
+	for _, m := range c.header.Missing {
+		if m.Lo == m.Hi {
+			values = append(values, w.dictionaryValue(m.Lo))
+		} else {
+			ranges = append(ranges, w.dictionaryValue(m.Lo), w.dictionaryValue(m.Hi))
+		}
+	}
+	if len(ranges) > 0 {
+		return -int32(len(ranges) + len(values)), append(ranges, values...)
+	}
+	return int32(len(values)), values
+}
+
+// writeValueLabels writes the value labels of c followed by the record of the variable they
+// belong to. Like missing values, labels of strings longer than 8 bytes are written by
+// writeLongStrings
+func (w *savWriter) writeValueLabels(c savColumn) {
+	if len(c.header.ValueLabels) == 0 || c.width > 8 {
+		return
+	}
+	w.write(int32(recordValueLabels))
+	w.write(int32(len(c.header.ValueLabels)))
+	for _, l := range c.header.ValueLabels {
+		label := truncateUTF8(w.charset.encode(l.Label), 120)
+		w.write(w.dictionaryValue(l.Value))
+		w.write(byte(len(label)))
+		w.writeString(label, (len(label)+8)/8*8-1)
+	}
+	w.write([]int32{recordLabelVars, 1, int32(c.segments[0].offset + 1)})
+}
+
+// putInt32 appends 32 bit integers to the data of an extension record
+func putInt32(b *bytes.Buffer, values ...int) {
+	for _, v := range values {
+		b.Write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+	}
+}
+
+func (w *savWriter) writeExtension(subtype int32, data []byte) {
+	w.write([]int32{recordExtension, subtype, 1, int32(len(data))})
+	w.write(data)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (w *savWriter) writeCase(values []interface{}) {
+	for i, c := range w.columns {
+		if c.width == 0 {
+			w.writeNumber(number(values[i]))
+			continue
+		}
+		s := w.charset.encode(values[i].(string))
+		for j, segment := range c.segments {
+			start := j * savSegmentWidth
+			end := start + segment.width
+			if j < len(c.segments)-1 {
+				end = start + savSegmentWidth
+			}
+			var b []byte
+			if start < len(s) {
+				b = s[start:minInt(end, len(s))]
+			}
+			padded := bytes.Repeat([]byte{' '}, elements(segment.width)*8)
+			copy(padded, b)
+			for k := 0; k < len(padded); k += 8 {
+				w.writeChunk(padded[k : k+8])
+			}
+		}
+	}
+}
+
+// number converts a value to a SAV number, NaN is written as system missing like ReadStat does
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float32:
+		if math.IsNaN(float64(n)) {
+			return savSysmis
+		}
+		return float64(n)
+	case float64:
+		if math.IsNaN(n) {
+			return savSysmis
+		}
+		return n
+	}
+	return savSysmis
+}
+
+// writeNumber adds a number to the bytecode compressed data
+func (w *savWriter) writeNumber(f float64) {
+	switch {
+	case f == savSysmis:
+		w.command(opcodeSysmis, nil)
+	case f == math.Trunc(f) && f >= 1-savBias && f <= 251-savBias:
+		w.command(byte(f+savBias), nil)
+	default:
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+		w.command(opcodeRaw, b)
+	}
+}
+
+// writeChunk adds 8 bytes of a string to the bytecode compressed data
+func (w *savWriter) writeChunk(b []byte) {
+	if bytes.Equal(b, []byte("        ")) {
+		w.command(opcodeSpaces, nil)
+		return
+	}
+	w.command(opcodeRaw, b)
+}
+
+func (w *savWriter) command(code byte, raw []byte) {
+	w.cmd[w.ncmd] = code
+	w.ncmd++
+	w.raw.Write(raw)
+	if w.ncmd == 8 {
+		w.flushCommands()
+	}
+}
+
+func (w *savWriter) flushCommands() {
+	if w.ncmd == 0 {
+		return
+	}
+	for i := w.ncmd; i < 8; i++ {
+		w.cmd[i] = opcodePadding
+	}
+	w.write(w.cmd[:])
+	w.write(w.raw.Bytes())
+	w.raw.Reset()
+	w.ncmd = 0
+}
@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 #include <stdint.h>
 #include <stdio.h>
 #include <stdlib.h>
@@ -38,6 +41,7 @@ void add_to_header(const char *d, struct Data *data) {
 int handle_metadata(readstat_metadata_t *metadata, void *ctx) {
     struct Data *sav = (struct Data *) ctx;
     sav->var_count = readstat_get_var_count(metadata);
+    sav->row_count = readstat_get_row_count(metadata);
     return READSTAT_HANDLER_OK;
 }
 
@@ -60,6 +64,7 @@ int handle_variable(int index, readstat_variable_t *variable, const char *val_la
 int handle_value(int obs_index, readstat_variable_t *variable, readstat_value_t value, void *ctx) {
     struct Data *sav = (struct Data *) ctx;
     int var_index = readstat_variable_get_index(variable);
+    sav->var_index = var_index;
 
     readstat_type_t type = readstat_value_type(value);
 
@@ -137,6 +142,7 @@ int handle_value(int obs_index, readstat_variable_t *variable, readstat_value_t
 
     if (var_index == sav->var_count - 1) {
         add_to_data("\n", sav);
+        sav->rows++;
     } else {
         add_to_data(",", sav);
     }
@@ -144,7 +150,29 @@ int handle_value(int obs_index, readstat_variable_t *variable, readstat_value_t
     return READSTAT_HANDLER_OK;
 }
 
-struct Data * parse_sav(const char *input_file) {
+int handle_progress(double progress, void *ctx) {
+    struct Data *sav = (struct Data *) ctx;
+    if (goReadProgress(sav->ctx, progress, sav->rows, sav->row_count)) {
+        return READSTAT_HANDLER_ABORT;
+    }
+    return READSTAT_HANDLER_OK;
+}
+
+void handle_error(const char *error_message, void *ctx) {
+    struct Data *sav = (struct Data *) ctx;
+    goReadError(sav->ctx, (char *) error_message);
+}
+
+// set_encoding overrides the file's own encoding when one is given and always has ReadStat
+// convert strings to UTF-8 before they reach the handlers
+void set_encoding(readstat_parser_t *parser, const char *encoding) {
+    if (encoding != NULL && *encoding != 0) {
+        readstat_set_file_character_encoding(parser, encoding);
+    }
+    readstat_set_handler_character_encoding(parser, "UTF-8");
+}
+
+struct Data * parse_sav(const char *input_file, int ctx, const char *encoding) {
 
     if (input_file == 0) {
         return NULL;
@@ -155,8 +183,18 @@ struct Data * parse_sav(const char *input_file) {
     readstat_set_metadata_handler(parser, &handle_metadata);
     readstat_set_variable_handler(parser, &handle_variable);
     readstat_set_value_handler(parser, &handle_value);
+    readstat_set_progress_handler(parser, &handle_progress);
+    readstat_set_error_handler(parser, &handle_error);
+    set_encoding(parser, encoding);
 
     struct Data *sav_data = (struct Data *) malloc(sizeof(struct Data));
+    sav_data->ctx = ctx;
+    sav_data->var_count = 0;
+    sav_data->row_count = -1;
+    sav_data->rows = 0;
+    sav_data->var_index = -1;
+    sav_data->error = READSTAT_OK;
+    sav_data->error_message = NULL;
     sav_data->data = NULL;
     sav_data->used = 0;
     sav_data->have = 0;
@@ -172,13 +210,163 @@ struct Data * parse_sav(const char *input_file) {
 
     readstat_parser_free(parser);
 
+    // on failure the partial data is returned so the caller can report where the parse stopped
     if (error != READSTAT_OK) {
-      return NULL;
+      sav_data->error = error;
+      sav_data->error_message = readstat_error_message(error);
+      return sav_data;
     }
 
     // remove the final newline character
-    sav_data->data[sav_data->used -1] = 0;
+    if (sav_data->used > 0) {
+      sav_data->data[sav_data->used -1] = 0;
+    }
 
     return sav_data;
 
 }
+
+double value_as_double(readstat_value_t value) {
+    switch (readstat_value_type(value)) {
+        case READSTAT_TYPE_INT8:
+            return readstat_int8_value(value);
+        case READSTAT_TYPE_INT16:
+            return readstat_int16_value(value);
+        case READSTAT_TYPE_INT32:
+            return readstat_int32_value(value);
+        case READSTAT_TYPE_FLOAT:
+            return readstat_float_value(value);
+        case READSTAT_TYPE_DOUBLE:
+            return readstat_double_value(value);
+        default:
+            return 0;
+    }
+}
+
+int handle_inspect_metadata(readstat_metadata_t *metadata, void *ctx) {
+    int id = *(int *) ctx;
+    goInspectMetadata(id, (char *) readstat_get_file_label(metadata), (char *) readstat_get_file_encoding(metadata),
+                      readstat_get_row_count(metadata), readstat_get_var_count(metadata),
+                      (long) readstat_get_creation_time(metadata), (long) readstat_get_modified_time(metadata),
+                      readstat_get_file_format_version(metadata), readstat_get_file_format_is_64bit(metadata),
+                      readstat_get_compression(metadata) != READSTAT_COMPRESS_NONE);
+    return READSTAT_HANDLER_OK;
+}
+
+int handle_inspect_variable(int index, readstat_variable_t *variable, const char *val_labels, void *ctx) {
+    int id = *(int *) ctx;
+    goInspectVariable(id, index, (char *) readstat_variable_get_name(variable),
+                      (char *) readstat_variable_get_label(variable), (char *) readstat_variable_get_format(variable),
+                      readstat_variable_get_type(variable), (int) readstat_variable_get_storage_width(variable),
+                      readstat_variable_get_display_width(variable), readstat_variable_get_measure(variable),
+                      (char *) val_labels);
+
+    int ranges = readstat_variable_get_missing_ranges_count(variable);
+    for (int i = 0; i < ranges; i++) {
+        readstat_value_t lo = readstat_variable_get_missing_range_lo(variable, i);
+        readstat_value_t hi = readstat_variable_get_missing_range_hi(variable, i);
+        if (readstat_value_type(lo) == READSTAT_TYPE_STRING) {
+            goInspectMissing(id, 1, 0, 0,
+                             (char *) readstat_string_value(lo), (char *) readstat_string_value(hi));
+        } else {
+            goInspectMissing(id, 0, value_as_double(lo), value_as_double(hi), NULL, NULL);
+        }
+    }
+
+    return READSTAT_HANDLER_OK;
+}
+
+int handle_inspect_value_label(const char *val_labels, readstat_value_t value, const char *label, void *ctx) {
+    int id = *(int *) ctx;
+    if (readstat_value_type(value) == READSTAT_TYPE_STRING) {
+        goInspectValueLabel(id, (char *) val_labels, 1, 0, (char *) readstat_string_value(value), (char *) label);
+    } else {
+        goInspectValueLabel(id, (char *) val_labels, 0, value_as_double(value), NULL, (char *) label);
+    }
+    return READSTAT_HANDLER_OK;
+}
+
+int handle_inspect_fweight(readstat_variable_t *variable, void *ctx) {
+    goInspectWeight(*(int *) ctx, (char *) readstat_variable_get_name(variable));
+    return READSTAT_HANDLER_OK;
+}
+
+void handle_inspect_error(const char *error_message, void *ctx) {
+    goReadError(*(int *) ctx, (char *) error_message);
+}
+
+// inspect_sav reads the dictionary only. No value handler is registered so ReadStat
+// stops before the data section.
+int inspect_sav(const char *input_file, int ctx, const char *encoding) {
+
+    if (input_file == 0) {
+        return READSTAT_ERROR_OPEN;
+    }
+
+    readstat_error_t error;
+    readstat_parser_t *parser = readstat_parser_init();
+    readstat_set_metadata_handler(parser, &handle_inspect_metadata);
+    readstat_set_variable_handler(parser, &handle_inspect_variable);
+    readstat_set_value_label_handler(parser, &handle_inspect_value_label);
+    readstat_set_fweight_handler(parser, &handle_inspect_fweight);
+    readstat_set_error_handler(parser, &handle_inspect_error);
+    set_encoding(parser, encoding);
+
+    error = readstat_parse_sav(parser, input_file, &ctx);
+
+    readstat_parser_free(parser);
+
+    return error;
+}
+
+int handle_record_value(int obs_index, readstat_variable_t *variable, readstat_value_t value, void *ctx) {
+    int id = *(int *) ctx;
+    int var_index = readstat_variable_get_index(variable);
+    int abort;
+
+    if (readstat_value_is_system_missing(value)) {
+        abort = goRecordValue(id, var_index, 0, 1, 0, NULL);
+    } else if (readstat_value_type(value) == READSTAT_TYPE_STRING) {
+        abort = goRecordValue(id, var_index, 1, 0, 0, (char *) readstat_string_value(value));
+    } else {
+        abort = goRecordValue(id, var_index, 0, 0, value_as_double(value), NULL);
+    }
+
+    if (abort) {
+        return READSTAT_HANDLER_ABORT;
+    }
+    return READSTAT_HANDLER_OK;
+}
+
+int handle_record_progress(double progress, void *ctx) {
+    if (goRecordProgress(*(int *) ctx, progress)) {
+        return READSTAT_HANDLER_ABORT;
+    }
+    return READSTAT_HANDLER_OK;
+}
+
+// read_values reads the dictionary and passes each value to Go with its type, rather than
+// building the text buffers used by parse_sav
+int read_values(const char *input_file, int ctx, const char *encoding) {
+
+    if (input_file == 0) {
+        return READSTAT_ERROR_OPEN;
+    }
+
+    readstat_error_t error;
+    readstat_parser_t *parser = readstat_parser_init();
+    readstat_set_metadata_handler(parser, &handle_inspect_metadata);
+    readstat_set_variable_handler(parser, &handle_inspect_variable);
+    readstat_set_value_label_handler(parser, &handle_inspect_value_label);
+    readstat_set_fweight_handler(parser, &handle_inspect_fweight);
+    readstat_set_value_handler(parser, &handle_record_value);
+    readstat_set_progress_handler(parser, &handle_record_progress);
+    readstat_set_error_handler(parser, &handle_inspect_error);
+    set_encoding(parser, encoding);
+
+    error = readstat_parse_sav(parser, input_file, &ctx);
+
+    readstat_parser_free(parser);
+
+    return error;
+}
@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 package spss
 
 // #cgo windows amd64 CFLAGS: -O3 -IC:/msys64/mingw64/include
@@ -7,29 +10,51 @@ package spss
 // #cgo linux amd64 CFLAGS: -I/usr/local/include -g
 // #cgo linux LDFLAGS: -L/usr/local/lib -lreadstat
 // #include <stdlib.h>
+// #include "readstat.h"
 // #include "sav_reader.h"
 import "C"
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"os"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
 )
 
+// Backend names the SAV implementation compiled in, readstat or native
+const Backend = "readstat"
+
 func Import(fileName string) ([][]string, error) {
+	return ImportContext(context.Background(), fileName, nil)
+}
+
+// ImportContext is Import with cancellation, progress reporting and options. ReadStat's progress
+// handler is used to report on and abort the parse
+func ImportContext(ctx context.Context, fileName string, progress ProgressFunc, opts ...Option) ([][]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		return nil, fmt.Errorf(" -> Import: file %s not found", fileName)
+		return nil, &ParseError{Code: ErrOpen, File: fileName, Err: err}
 	}
 
 	name := C.CString(fileName)
 	defer C.free(unsafe.Pointer(name))
 
-	var res = C.parse_sav(name)
+	encoding := C.CString(newOptions(opts).encoding)
+	defer C.free(unsafe.Pointer(encoding))
+
+	p := &parseContext{ctx: ctx, progress: progress}
+	id := registerContext(p)
+	defer releaseContext(id)
+
+	var res = C.parse_sav(name, C.int(id), encoding)
 	if res == nil {
-		return nil, errors.New("read from SPSS file failed")
+		return nil, &ParseError{Code: ErrMalloc, File: fileName}
 	}
 
 	var str [][]string
@@ -52,13 +77,31 @@ func Import(fileName string) ([][]string, error) {
 
 	v := C.struct_Data(*res)
 
-	header := []string{C.GoString(v.header)}
+	if v.error != 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := &ParseError{Code: ErrorCode(v.error), File: fileName, Message: p.message}
+		if e.Message == "" {
+			e.Message = C.GoString(v.error_message)
+		}
+		if v.var_index >= 0 {
+			e.Row = int(v.rows) + 1
+			e.Column = int(v.var_index) + 1
+			if names := strings.Split(strings.TrimSpace(C.GoString(v.header)), TagSeparator); int(v.var_index) < len(names) {
+				e.Variable = names[v.var_index]
+			}
+		}
+		return nil, e
+	}
+
+	header := []string{strings.ToValidUTF8(C.GoString(v.header), "\uFFFD")}
 	for _, l := range header {
 		s := strings.Split(l, TagSeparator)
 		str = append(str, s)
 	}
 
-	data := strings.Split(C.GoString(v.data), EOL)
+	data := strings.Split(strings.ToValidUTF8(C.GoString(v.data), "\uFFFD"), EOL)
 
 	for _, l := range data {
 		s := strings.Split(l, TagSeparator)
@@ -67,3 +110,246 @@ func Import(fileName string) ([][]string, error) {
 
 	return str, nil
 }
+
+// parseContext holds the Go side state of a single ReadStat parse. ReadStat callbacks cannot
+// carry Go pointers so each parse is registered and referenced by id
+type parseContext struct {
+	ctx      context.Context
+	progress ProgressFunc
+	metadata *Metadata
+	message  string
+
+	fn       func(row []interface{}) error
+	row      []interface{}
+	rows     int
+	varIndex int
+	err      error
+}
+
+// report passes progress to the caller, returning false if the context has been cancelled
+func (p *parseContext) report(rows, total int, percent float64) bool {
+	if p.progress != nil {
+		p.progress(Progress{rows, total, percent})
+	}
+	return p.ctx.Err() == nil
+}
+
+var contexts = struct {
+	sync.RWMutex
+	next int
+	m    map[int]*parseContext
+}{m: make(map[int]*parseContext)}
+
+func registerContext(p *parseContext) int {
+	contexts.Lock()
+	defer contexts.Unlock()
+	contexts.next++
+	contexts.m[contexts.next] = p
+	return contexts.next
+}
+
+func lookupContext(id C.int) *parseContext {
+	contexts.RLock()
+	defer contexts.RUnlock()
+	return contexts.m[int(id)]
+}
+
+func releaseContext(id int) {
+	contexts.Lock()
+	defer contexts.Unlock()
+	delete(contexts.m, id)
+}
+
+// goString converts a string from ReadStat, which has already been converted to UTF-8,
+// guarding against anything iconv let through
+func goString(s *C.char) string {
+	return strings.ToValidUTF8(C.GoString(s), "\uFFFD")
+}
+
+func inspect(fileName string, o options) (*Metadata, error) {
+
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, &ParseError{Code: ErrOpen, File: fileName, Err: err}
+	}
+
+	name := C.CString(fileName)
+	defer C.free(unsafe.Pointer(name))
+
+	encoding := C.CString(o.encoding)
+	defer C.free(unsafe.Pointer(encoding))
+
+	meta := &Metadata{FileName: fileName, LabelSets: make(map[string][]ValueLabel)}
+	p := &parseContext{metadata: meta}
+	id := registerContext(p)
+	defer releaseContext(id)
+
+	if res := C.inspect_sav(name, C.int(id), encoding); res != 0 {
+		e := &ParseError{Code: ErrorCode(res), File: fileName, Message: p.message}
+		if e.Message == "" {
+			e.Message = C.GoString(C.readstat_error_message(C.readstat_error_t(res)))
+		}
+		return nil, e
+	}
+
+	return meta, nil
+}
+
+// parseSav reads the dictionary of fileName and, when fn is not nil, passes every row to fn.
+// Numeric values are float64 and strings are string, system missing values are nil
+func parseSav(ctx context.Context, fileName string, progress ProgressFunc, fn func(row []interface{}) error, o options) (*Metadata, error) {
+
+	if fn == nil {
+		return inspect(fileName, o)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, &ParseError{Code: ErrOpen, File: fileName, Err: err}
+	}
+
+	name := C.CString(fileName)
+	defer C.free(unsafe.Pointer(name))
+
+	encoding := C.CString(o.encoding)
+	defer C.free(unsafe.Pointer(encoding))
+
+	meta := &Metadata{FileName: fileName, LabelSets: make(map[string][]ValueLabel)}
+	p := &parseContext{ctx: ctx, progress: progress, metadata: meta, fn: fn, varIndex: -1}
+	id := registerContext(p)
+	defer releaseContext(id)
+
+	if res := C.read_values(name, C.int(id), encoding); res != 0 {
+		if p.err != nil {
+			return nil, p.err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := &ParseError{Code: ErrorCode(res), File: fileName, Message: p.message}
+		if e.Message == "" {
+			e.Message = C.GoString(C.readstat_error_message(C.readstat_error_t(res)))
+		}
+		if p.varIndex >= 0 && p.varIndex < len(meta.Variables) {
+			e.Row = p.rows + 1
+			e.Column = p.varIndex + 1
+			e.Variable = meta.Variables[p.varIndex].Name
+		}
+		return nil, e
+	}
+
+	if p.progress != nil {
+		p.progress(Progress{p.rows, meta.RowCount, 100})
+	}
+
+	return meta, nil
+}
+
+//export goRecordValue
+func goRecordValue(id, varIndex, isString, isMissing C.int, value C.double, stringValue *C.char) C.int {
+	p := lookupContext(id)
+	if p.row == nil {
+		p.row = make([]interface{}, len(p.metadata.Variables))
+	}
+	p.varIndex = int(varIndex)
+
+	switch {
+	case isMissing != 0:
+		p.row[varIndex] = nil
+	case isString != 0:
+		p.row[varIndex] = goString(stringValue)
+	default:
+		p.row[varIndex] = float64(value)
+	}
+
+	if int(varIndex) == len(p.row)-1 {
+		if err := p.fn(p.row); err != nil {
+			p.err = err
+			return 1
+		}
+		p.rows++
+	}
+	return 0
+}
+
+//export goRecordProgress
+func goRecordProgress(id C.int, progress C.double) C.int {
+	p := lookupContext(id)
+	if p.report(p.rows, p.metadata.RowCount, float64(progress)*100) {
+		return 0
+	}
+	return 1
+}
+
+//export goInspectMetadata
+func goInspectMetadata(id C.int, label, encoding *C.char, rows, vars C.int, created, modified C.long, version, is64Bit, compressed C.int) {
+	p := lookupContext(id)
+	p.metadata.FileLabel = goString(label)
+	p.metadata.Encoding = C.GoString(encoding)
+	p.metadata.RowCount = int(rows)
+	p.metadata.VarCount = int(vars)
+	p.metadata.Created = time.Unix(int64(created), 0)
+	p.metadata.Modified = time.Unix(int64(modified), 0)
+	p.metadata.FormatVersion = int(version)
+	p.metadata.Is64Bit = is64Bit != 0
+	p.metadata.Compressed = compressed != 0
+}
+
+//export goInspectVariable
+func goInspectVariable(id, index C.int, name, label, format *C.char, savType, storageWidth, displayWidth, measure C.int, labelSet *C.char) {
+	p := lookupContext(id)
+	p.metadata.Variables = append(p.metadata.Variables, Variable{
+		Index:        int(index),
+		Name:         goString(name),
+		Label:        goString(label),
+		Format:       C.GoString(format),
+		Type:         ColumnType(savType),
+		StorageWidth: int(storageWidth),
+		DisplayWidth: int(displayWidth),
+		Measure:      Measure(measure),
+		LabelSet:     C.GoString(labelSet),
+	})
+}
+
+//export goInspectMissing
+func goInspectMissing(id, isString C.int, lo, hi C.double, loString, hiString *C.char) {
+	p := lookupContext(id)
+	v := &p.metadata.Variables[len(p.metadata.Variables)-1]
+	if isString != 0 {
+		v.Missing = append(v.Missing, MissingRange{goString(loString), goString(hiString)})
+		return
+	}
+	v.Missing = append(v.Missing, MissingRange{float64(lo), float64(hi)})
+}
+
+//export goInspectValueLabel
+func goInspectValueLabel(id C.int, labelSet *C.char, isString C.int, value C.double, stringValue, label *C.char) {
+	p := lookupContext(id)
+	set := C.GoString(labelSet)
+	var v interface{} = float64(value)
+	if isString != 0 {
+		v = goString(stringValue)
+	}
+	p.metadata.LabelSets[set] = append(p.metadata.LabelSets[set], ValueLabel{v, goString(label)})
+}
+
+//export goInspectWeight
+func goInspectWeight(id C.int, name *C.char) {
+	lookupContext(id).metadata.Weight = goString(name)
+}
+
+//export goReadProgress
+func goReadProgress(id C.int, progress C.double, rows, total C.int) C.int {
+	if lookupContext(id).report(int(rows), int(total), float64(progress)*100) {
+		return 0
+	}
+	return 1
+}
+
+//export goReadError
+func goReadError(id C.int, message *C.char) {
+	p := lookupContext(id)
+	p.message = strings.TrimSpace(goString(message))
+}
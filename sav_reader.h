@@ -1,12 +1,30 @@
 #ifndef _SAV_READER_H
 #define _SAV_READER_H
 
-struct Data* parse_sav(const char *input_file);
+struct Data* parse_sav(const char *input_file, int ctx, const char *encoding);
+int inspect_sav(const char *input_file, int ctx, const char *encoding);
+int read_values(const char *input_file, int ctx, const char *encoding);
 
 extern void goAddData(char *, char *);
+extern void goInspectMetadata(int, char *, char *, int, int, long, long, int, int, int);
+extern void goInspectVariable(int, int, char *, char *, char *, int, int, int, int, char *);
+extern void goInspectMissing(int, int, double, double, char *, char *);
+extern void goInspectValueLabel(int, char *, int, double, char *, char *);
+extern void goInspectWeight(int, char *);
+extern int goReadProgress(int, double, int, int);
+extern void goReadError(int, char *);
+extern int goRecordValue(int, int, int, int, double, char *);
+extern int goRecordProgress(int, double);
 
 struct Data {
+    int ctx;
     int var_count;
+    int row_count;
+    int rows;
+    int var_index;
+
+    int error;
+    const char *error_message;
 
     char *data;
     unsigned long used;
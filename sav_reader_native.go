@@ -0,0 +1,882 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package spss
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backend names the SAV implementation compiled in, readstat or native
+const Backend = "native"
+
+func Import(fileName string) ([][]string, error) {
+	return ImportContext(context.Background(), fileName, nil)
+}
+
+// ImportContext is Import with cancellation, progress reporting and options
+func ImportContext(ctx context.Context, fileName string, progress ProgressFunc, opts ...Option) ([][]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var str [][]string
+
+	meta, err := parseSav(ctx, fileName, progress, func(row []interface{}) error {
+		line := make([]string, len(row))
+		for i, v := range row {
+			switch value := v.(type) {
+			case string:
+				line[i] = strings.Replace(value, TagSeparator, " ", -1)
+			case float64:
+				line[i] = strconv.FormatFloat(value, 'f', 6, 64)
+			default:
+				// system missing values are reported as zero, matching ReadStat
+				line[i] = "0.000000"
+			}
+		}
+		str = append(str, line)
+		return nil
+	}, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return append([][]string{meta.Names()}, str...), nil
+}
+
+func inspect(fileName string, o options) (*Metadata, error) {
+	return parseSav(context.Background(), fileName, nil, nil, o)
+}
+
+// parseSav reads the dictionary of fileName and, when fn is not nil, passes every row to fn.
+// Numeric values are float64 and strings are string, system missing values are nil
+func parseSav(ctx context.Context, fileName string, progress ProgressFunc, fn func(row []interface{}) error, o options) (*Metadata, error) {
+	r, err := openSav(fileName)
+	if err != nil {
+		return nil, err
+	}
+	r.fileEncoding = o.encoding
+	defer func() {
+		_ = r.file.Close()
+	}()
+
+	if err := r.readDictionary(); err != nil {
+		return nil, err
+	}
+	meta := r.metadata()
+
+	if fn == nil {
+		return meta, nil
+	}
+
+	if err := r.startData(); err != nil {
+		return nil, err
+	}
+
+	row := make([]interface{}, len(r.variables))
+	for rows := 0; ; rows++ {
+		if rows%progressRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if progress != nil {
+				progress(Progress{rows, meta.RowCount, r.percent(rows)})
+			}
+		}
+
+		ok, err := r.readCase()
+		if err != nil {
+			return nil, r.parseError(err, rows+1)
+		}
+		if !ok {
+			if progress != nil {
+				progress(Progress{rows, meta.RowCount, 100})
+			}
+			break
+		}
+
+		for i, v := range r.variables {
+			row[i] = r.value(v)
+		}
+		if err := fn(row); err != nil {
+			return nil, err
+		}
+	}
+
+	return meta, nil
+}
+
+// savVariable is a variable record as stored in the file. Strings wider than 255 bytes are
+// stored as several segment variables which are merged once the dictionary has been read
+type savVariable struct {
+	name      string
+	longName  string
+	width     int
+	offset    int
+	elements  int
+	label     []byte
+	print     int32
+	nMissing  int32
+	missing   []byte
+	labelSet  int
+	segments  []*savVariable
+	measure   int32
+	display   int32
+	alignment int32
+}
+
+type savLabelSet struct {
+	values [][]byte
+	labels [][]byte
+}
+
+type savReader struct {
+	name         string
+	file         *os.File
+	size         int64
+	in           *countingReader
+	r            *bufio.Reader
+	order        binary.ByteOrder
+	zsav         bool
+	elements     int
+	compression  int32
+	weight       int // case element of the weight variable counting from 1, 0 when unweighted
+	ncases       int64
+	bias         float64
+	sysmis       float64
+	created      time.Time
+	fileLabel    []byte
+	charCode     int32
+	vars         []*savVariable
+	variables    []*savVariable
+	labelSets    []*savLabelSet
+	ext          map[int32][]byte
+	charset      charset
+	encoding     string
+	fileEncoding string // overrides the encoding recorded in the file when set
+
+	data    *bufio.Reader
+	cmd     [8]byte
+	cmdPos  int
+	caseBuf []byte
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func openSav(fileName string) (*savReader, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, &ParseError{Code: ErrOpen, File: fileName, Err: err}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, &ParseError{Code: ErrRead, File: fileName, Err: err}
+	}
+	in := &countingReader{r: f}
+	r := &savReader{
+		name:     fileName,
+		file:     f,
+		size:     info.Size(),
+		in:       in,
+		r:        bufio.NewReader(in),
+		sysmis:   savSysmis,
+		ext:      make(map[int32][]byte),
+		charCode: -1,
+	}
+	if err := r.readHeader(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// offset is the position in the file of the next unread byte
+func (r *savReader) offset() int64 {
+	return r.in.n - int64(r.r.Buffered())
+}
+
+func (r *savReader) percent(rows int) float64 {
+	if r.ncases > 0 {
+		return float64(rows) / float64(r.ncases) * 100
+	}
+	if r.size > 0 && !r.zsav {
+		return float64(r.offset()) / float64(r.size) * 100
+	}
+	return 0
+}
+
+func (r *savReader) errorf(code ErrorCode, format string, a ...interface{}) error {
+	return &ParseError{Code: code, File: r.name, Message: fmt.Sprintf(format, a...)}
+}
+
+func (r *savReader) parseError(err error, row int) error {
+	if e, ok := err.(*ParseError); ok {
+		return e
+	}
+	code := ErrRead
+	if err == io.ErrUnexpectedEOF {
+		code = ErrRowCountMismatch
+	}
+	return &ParseError{Code: code, File: r.name, Row: row, Err: err}
+}
+
+func (r *savReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &ParseError{Code: ErrRead, File: r.name, Err: err}
+	}
+	return b, nil
+}
+
+// readLength reads a record of n bytes, n having been read from the file. Lengths that are
+// negative or run past the end of the file are rejected before anything is allocated
+func (r *savReader) readLength(n int64, what string) ([]byte, error) {
+	if n < 0 || n > r.size-r.offset() {
+		return nil, r.errorf(ErrParse, "%s has invalid length %d", what, n)
+	}
+	return r.read(int(n))
+}
+
+func (r *savReader) readInt32() (int32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(r.order.Uint32(b)), nil
+}
+
+func (r *savReader) float(b []byte) float64 {
+	return math.Float64frombits(r.order.Uint64(b))
+}
+
+func (r *savReader) readHeader() error {
+	b, err := r.read(176)
+	if err != nil {
+		return err
+	}
+	switch string(b[0:4]) {
+	case "$FL2":
+	case "$FL3":
+		r.zsav = true
+	default:
+		return r.errorf(ErrParse, "not an SPSS system file")
+	}
+
+	layout := b[64:68]
+	switch {
+	case binary.LittleEndian.Uint32(layout) == 2 || binary.LittleEndian.Uint32(layout) == 3:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(layout) == 2 || binary.BigEndian.Uint32(layout) == 3:
+		r.order = binary.BigEndian
+	default:
+		return r.errorf(ErrParse, "unknown layout code")
+	}
+
+	r.compression = int32(r.order.Uint32(b[72:76]))
+	r.weight = int(int32(r.order.Uint32(b[76:80])))
+	r.ncases = int64(int32(r.order.Uint32(b[80:84])))
+	r.bias = r.float(b[84:92])
+	r.created, _ = time.Parse("02 Jan 06 15:04:05", string(b[92:101])+" "+string(b[101:109]))
+	r.fileLabel = trimString(b[109:173])
+
+	switch r.compression {
+	case compressNone, compressBytecode:
+	case compressZlib:
+		if !r.zsav {
+			return r.errorf(ErrUnsupportedCompression, "zlib compression in a $FL2 file")
+		}
+	default:
+		return r.errorf(ErrUnsupportedCompression, "unknown compression %d", r.compression)
+	}
+	return nil
+}
+
+func (r *savReader) readDictionary() error {
+	for {
+		recType, err := r.readInt32()
+		if err != nil {
+			return err
+		}
+		switch recType {
+		case recordVariable:
+			err = r.readVariable()
+		case recordValueLabels:
+			err = r.readValueLabels()
+		case recordDocument:
+			var n int32
+			if n, err = r.readInt32(); err == nil {
+				_, err = r.readLength(int64(n)*80, "document record")
+			}
+		case recordExtension:
+			err = r.readExtension()
+		case recordEndDictionary:
+			if _, err = r.readInt32(); err != nil {
+				return err
+			}
+			return r.finishDictionary()
+		default:
+			return r.errorf(ErrParse, "unknown record type %d", recType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *savReader) readVariable() error {
+	b, err := r.read(28)
+	if err != nil {
+		return err
+	}
+	typ := int32(r.order.Uint32(b[0:4]))
+	hasLabel := r.order.Uint32(b[4:8])
+	nMissing := int32(r.order.Uint32(b[8:12]))
+
+	v := &savVariable{
+		name:     string(trimString(b[20:28])),
+		width:    int(typ),
+		offset:   r.elements,
+		elements: 1,
+		print:    int32(r.order.Uint32(b[12:16])),
+		nMissing: nMissing,
+		labelSet: -1,
+	}
+	r.elements++
+
+	if hasLabel == 1 {
+		n, err := r.readInt32()
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return r.errorf(ErrParse, "variable %s has invalid label length %d", v.name, n)
+		}
+		if v.label, err = r.readLength((int64(n)+3)/4*4, "variable label"); err != nil {
+			return err
+		}
+		v.label = v.label[:n]
+	}
+
+	if nMissing != 0 {
+		// up to three discrete values, or a range as -2 and a range and a value as -3
+		if nMissing < -3 || nMissing > 3 || nMissing == -1 {
+			return r.errorf(ErrParse, "variable %s has invalid missing value count %d", v.name, nMissing)
+		}
+		n := nMissing
+		if n < 0 {
+			n = -n
+		}
+		if v.missing, err = r.read(int(n) * 8); err != nil {
+			return err
+		}
+	}
+
+	if typ == -1 {
+		if len(r.vars) == 0 {
+			return r.errorf(ErrParse, "continuation record without a variable")
+		}
+		r.vars[len(r.vars)-1].elements++
+		return nil
+	}
+	if typ < 0 || typ > savMaxShortWidth {
+		return r.errorf(ErrParse, "variable %s has invalid width %d", v.name, typ)
+	}
+	r.vars = append(r.vars, v)
+	return nil
+}
+
+func (r *savReader) readValueLabels() error {
+	count, err := r.readInt32()
+	if err != nil {
+		return err
+	}
+	set := &savLabelSet{}
+	for i := 0; i < int(count); i++ {
+		b, err := r.read(9)
+		if err != nil {
+			return err
+		}
+		n := int(b[8])
+		label, err := r.read((n+8)/8*8 - 1)
+		if err != nil {
+			return err
+		}
+		set.values = append(set.values, b[:8])
+		set.labels = append(set.labels, label[:n])
+	}
+
+	recType, err := r.readInt32()
+	if err != nil {
+		return err
+	}
+	if recType != recordLabelVars {
+		return r.errorf(ErrParse, "value labels not followed by variable index record")
+	}
+	n, err := r.readInt32()
+	if err != nil {
+		return err
+	}
+	index := len(r.labelSets)
+	r.labelSets = append(r.labelSets, set)
+	for i := 0; i < int(n); i++ {
+		element, err := r.readInt32()
+		if err != nil {
+			return err
+		}
+		for _, v := range r.vars {
+			if v.offset == int(element)-1 {
+				v.labelSet = index
+			}
+		}
+	}
+	return nil
+}
+
+func (r *savReader) readExtension() error {
+	b, err := r.read(12)
+	if err != nil {
+		return err
+	}
+	subtype := int32(r.order.Uint32(b[0:4]))
+	size := int64(r.order.Uint32(b[4:8]))
+	count := int64(r.order.Uint32(b[8:12]))
+	data, err := r.readLength(size*count, fmt.Sprintf("extension record %d", subtype))
+	if err != nil {
+		return err
+	}
+
+	switch subtype {
+	case extIntegerInfo:
+		if len(data) >= 32 {
+			r.charCode = int32(r.order.Uint32(data[28:32]))
+		}
+	case extFloatInfo:
+		if len(data) >= 8 {
+			r.sysmis = r.float(data[0:8])
+		}
+	case extNumberOfCases:
+		if len(data) >= 16 && r.ncases < 0 {
+			r.ncases = int64(r.order.Uint64(data[8:16]))
+		}
+	}
+	r.ext[subtype] = data
+	return nil
+}
+
+// finishDictionary applies the extension records to the variables once the whole dictionary is read
+func (r *savReader) finishDictionary() error {
+	r.encoding = string(trimString(r.ext[extEncoding]))
+	if r.encoding == "" {
+		r.encoding = codePages[r.charCode]
+	}
+	encoding := r.encoding
+	if r.fileEncoding != "" {
+		encoding = r.fileEncoding
+	}
+	if encoding == "" {
+		encoding = "WINDOWS-1252"
+	}
+	r.charset, _ = lookupCharset(encoding)
+
+	longNames := make(map[string]string)
+	for _, pair := range strings.Split(string(r.ext[extLongNames]), "\t") {
+		if i := strings.Index(pair, "="); i > 0 {
+			longNames[strings.ToUpper(pair[:i])] = r.charset.decode([]byte(pair[i+1:]))
+		}
+	}
+
+	veryLong := make(map[string]int)
+	for _, pair := range strings.Split(string(r.ext[extVeryLongString]), "\t") {
+		pair = strings.Trim(pair, "\x00")
+		if i := strings.Index(pair, "="); i > 0 {
+			width, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+			if err != nil {
+				return r.errorf(ErrParse, "bad very long string record: %s", pair)
+			}
+			veryLong[strings.ToUpper(pair[:i])] = width
+		}
+	}
+
+	display := r.ext[extDisplay]
+	fields := 0
+	if len(r.vars) > 0 {
+		fields = len(display) / 4 / len(r.vars)
+	}
+	if (fields == 2 || fields == 3) && len(display) == fields*4*len(r.vars) {
+		for i, v := range r.vars {
+			p := display[i*fields*4:]
+			v.measure = int32(r.order.Uint32(p[0:4]))
+			v.display = int32(r.order.Uint32(p[4:8]))
+			if fields == 3 {
+				v.alignment = int32(r.order.Uint32(p[8:12]))
+			}
+		}
+	}
+
+	for i := 0; i < len(r.vars); i++ {
+		v := r.vars[i]
+		v.longName = r.charset.decode([]byte(v.name))
+		if name, ok := longNames[strings.ToUpper(v.name)]; ok {
+			v.longName = name
+		}
+		r.variables = append(r.variables, v)
+
+		width, ok := veryLong[strings.ToUpper(v.name)]
+		if !ok {
+			continue
+		}
+		segments := (width + savSegmentWidth - 1) / savSegmentWidth
+		if i+segments > len(r.vars) {
+			return r.errorf(ErrParse, "very long string %s has missing segments", v.longName)
+		}
+		v.segments = r.vars[i : i+segments]
+		v.width = width
+		i += segments - 1
+	}
+	if err := r.readLongStrings(); err != nil {
+		return err
+	}
+
+	r.caseBuf = make([]byte, r.elements*8)
+	return nil
+}
+
+// extFields reads the length prefixed fields of an extension record, bad is set once a field
+// runs past the end of the record
+type extFields struct {
+	order binary.ByteOrder
+	b     []byte
+	bad   bool
+}
+
+func (f *extFields) bytes(n int) []byte {
+	if f.bad || n < 0 || n > len(f.b) {
+		f.bad = true
+		return nil
+	}
+	b := f.b[:n]
+	f.b = f.b[n:]
+	return b
+}
+
+func (f *extFields) int32() int {
+	b := f.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int(int32(f.order.Uint32(b)))
+}
+
+// readLongStrings applies the value labels and missing values of strings longer than 8 bytes
+func (r *savReader) readLongStrings() error {
+	byName := make(map[string]*savVariable)
+	for _, v := range r.variables {
+		byName[strings.ToUpper(v.longName)] = v
+	}
+
+	f := &extFields{order: r.order, b: r.ext[extLongStringLabels]}
+	for len(f.b) > 0 && !f.bad {
+		name := r.charset.decode(f.bytes(f.int32()))
+		f.int32() // width
+		set := &savLabelSet{}
+		for n := f.int32(); n > 0 && !f.bad; n-- {
+			set.values = append(set.values, f.bytes(f.int32()))
+			set.labels = append(set.labels, f.bytes(f.int32()))
+		}
+		if v, ok := byName[strings.ToUpper(name)]; ok && !f.bad {
+			v.labelSet = len(r.labelSets)
+			r.labelSets = append(r.labelSets, set)
+		}
+	}
+	if f.bad {
+		return r.errorf(ErrParse, "bad long string value labels record")
+	}
+
+	f = &extFields{order: r.order, b: r.ext[extLongStringMissing]}
+	for len(f.b) > 0 && !f.bad {
+		name := r.charset.decode(f.bytes(f.int32()))
+		n := f.bytes(1)
+		if f.bad || n[0] > 3 || f.int32() != 8 {
+			return r.errorf(ErrParse, "bad long string missing values record")
+		}
+		missing := f.bytes(int(n[0]) * 8)
+		if v, ok := byName[strings.ToUpper(name)]; ok && !f.bad {
+			v.nMissing = int32(n[0])
+			v.missing = missing
+		}
+	}
+	if f.bad {
+		return r.errorf(ErrParse, "bad long string missing values record")
+	}
+	return nil
+}
+
+func (r *savReader) metadata() *Metadata {
+	meta := &Metadata{
+		FileName:      r.name,
+		FileLabel:     r.charset.decode(r.fileLabel),
+		Encoding:      r.encoding,
+		RowCount:      int(r.ncases),
+		VarCount:      len(r.variables),
+		Created:       r.created,
+		Modified:      r.created,
+		FormatVersion: 2,
+		Compressed:    r.compression != compressNone,
+		LabelSets:     make(map[string][]ValueLabel),
+	}
+	if r.zsav {
+		meta.FormatVersion = 3
+	}
+
+	for i, v := range r.variables {
+		variable := Variable{
+			Index:        i,
+			Name:         v.longName,
+			Label:        r.charset.decode(v.label),
+			Format:       formatString(v.print),
+			Type:         ReadstatTypeDouble,
+			StorageWidth: 8,
+			DisplayWidth: int(v.display),
+			Measure:      Measure(v.measure),
+		}
+		if v.width > 0 {
+			variable.Type = ReadstatTypeString
+			variable.StorageWidth = v.width
+		}
+		variable.Missing = r.missing(v)
+		if v.labelSet >= 0 {
+			variable.LabelSet = fmt.Sprintf("labels%d", v.labelSet)
+		}
+		if r.weight > 0 && v.offset == r.weight-1 && v.width == 0 {
+			meta.Weight = variable.Name
+		}
+		meta.Variables = append(meta.Variables, variable)
+	}
+
+	for i, set := range r.labelSets {
+		name := fmt.Sprintf("labels%d", i)
+		isString := false
+		for _, v := range r.variables {
+			if v.labelSet == i && v.width > 0 {
+				isString = true
+			}
+		}
+		for j, value := range set.values {
+			label := ValueLabel{Label: r.charset.decode(set.labels[j])}
+			if isString {
+				label.Value = r.charset.decode(trimString(value))
+			} else {
+				label.Value = r.float(value)
+			}
+			meta.LabelSets[name] = append(meta.LabelSets[name], label)
+		}
+	}
+
+	return meta
+}
+
+func (r *savReader) missing(v *savVariable) []MissingRange {
+	var missing []MissingRange
+	values := make([]interface{}, len(v.missing)/8)
+	for i := range values {
+		b := v.missing[i*8 : i*8+8]
+		if v.width > 0 {
+			values[i] = r.charset.decode(trimString(b))
+		} else {
+			values[i] = r.float(b)
+		}
+	}
+	if v.nMissing < 0 && len(values) >= 2 {
+		missing = append(missing, MissingRange{values[0], values[1]})
+		values = values[2:]
+	}
+	for _, value := range values {
+		missing = append(missing, MissingRange{value, value})
+	}
+	return missing
+}
+
+// startData positions the reader at the first case, setting up decompression of .zsav files
+func (r *savReader) startData() error {
+	r.cmdPos = 8
+	if r.compression != compressZlib {
+		r.data = r.r
+		return nil
+	}
+
+	header, err := r.read(24)
+	if err != nil {
+		return err
+	}
+	trailerOffset := int64(r.order.Uint64(header[8:16]))
+	trailerLength := int64(r.order.Uint64(header[16:24]))
+	if trailerLength < 24 || trailerOffset < 0 || trailerOffset > r.size || trailerLength > r.size-trailerOffset {
+		return r.errorf(ErrParse, "bad zlib trailer")
+	}
+
+	trailer := make([]byte, trailerLength)
+	if _, err := r.file.ReadAt(trailer, trailerOffset); err != nil {
+		return &ParseError{Code: ErrRead, File: r.name, Err: err}
+	}
+	blocks := int(r.order.Uint32(trailer[20:24]))
+	if int64(24+blocks*24) > trailerLength {
+		return r.errorf(ErrParse, "bad zlib trailer")
+	}
+
+	z := &zlibBlocks{}
+	for i := 0; i < blocks; i++ {
+		p := trailer[24+i*24:]
+		offset := int64(r.order.Uint64(p[8:16]))
+		size := int64(r.order.Uint32(p[20:24]))
+		z.blocks = append(z.blocks, io.NewSectionReader(r.file, offset, size))
+	}
+	r.data = bufio.NewReader(z)
+	return nil
+}
+
+// zlibBlocks reads the concatenated, individually compressed, blocks of a .zsav file
+type zlibBlocks struct {
+	blocks []*io.SectionReader
+	cur    io.ReadCloser
+}
+
+func (z *zlibBlocks) Read(p []byte) (int, error) {
+	for {
+		if z.cur == nil {
+			if len(z.blocks) == 0 {
+				return 0, io.EOF
+			}
+			cur, err := zlib.NewReader(z.blocks[0])
+			if err != nil {
+				return 0, err
+			}
+			z.cur = cur
+			z.blocks = z.blocks[1:]
+		}
+		n, err := z.cur.Read(p)
+		if err == io.EOF {
+			_ = z.cur.Close()
+			z.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// readCase reads the next case into caseBuf, returning false at the end of the data
+func (r *savReader) readCase() (bool, error) {
+	for i := 0; i < r.elements; i++ {
+		ok, err := r.readElement(r.caseBuf[i*8 : i*8+8])
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			if i == 0 {
+				return false, nil
+			}
+			return false, io.ErrUnexpectedEOF
+		}
+	}
+	return true, nil
+}
+
+func (r *savReader) readElement(b []byte) (bool, error) {
+	if r.compression == compressNone {
+		if _, err := io.ReadFull(r.data, b); err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	for {
+		if r.cmdPos == 8 {
+			if _, err := io.ReadFull(r.data, r.cmd[:]); err != nil {
+				if err == io.EOF {
+					return false, nil
+				}
+				return false, err
+			}
+			r.cmdPos = 0
+		}
+		code := r.cmd[r.cmdPos]
+		r.cmdPos++
+
+		switch code {
+		case opcodePadding:
+			continue
+		case opcodeEOF:
+			return false, nil
+		case opcodeRaw:
+			if _, err := io.ReadFull(r.data, b); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return false, err
+			}
+		case opcodeSpaces:
+			copy(b, "        ")
+		case opcodeSysmis:
+			r.order.PutUint64(b, math.Float64bits(r.sysmis))
+		default:
+			r.order.PutUint64(b, math.Float64bits(float64(code)-r.bias))
+		}
+		return true, nil
+	}
+}
+
+// value decodes variable v from the current case
+func (r *savReader) value(v *savVariable) interface{} {
+	if v.width == 0 {
+		f := r.float(r.caseBuf[v.offset*8:])
+		if f == r.sysmis {
+			return nil
+		}
+		return f
+	}
+
+	if v.segments == nil {
+		return r.charset.decode(trimString(r.caseBuf[v.offset*8 : v.offset*8+v.width]))
+	}
+
+	var b bytes.Buffer
+	for i, s := range v.segments {
+		n := savSegmentWidth
+		if i == len(v.segments)-1 {
+			n = v.width - savSegmentWidth*i
+		}
+		b.Write(r.caseBuf[s.offset*8 : s.offset*8+n])
+	}
+	return r.charset.decode(trimString(b.Bytes()))
+}
@@ -0,0 +1,94 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package spss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// malformedSav writes a small SAV file and returns its bytes, with the offset of the first
+// variable record and of the first case
+func malformedSav(t *testing.T) ([]byte, int, int) {
+	fileName := filepath.Join(t.TempDir(), "valid.sav")
+	headers := []Header{
+		{SavType: ReadstatTypeDouble, Name: "Serial", Label: "Serial number"},
+		{SavType: ReadstatTypeString, Name: "Version", Label: "Version"},
+	}
+	data := []DataItem{
+		{[]interface{}{1.0, "v1"}},
+		{[]interface{}{2.0, "v2"}},
+	}
+	if err := Export(fileName, "malformed", headers, data); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := bytes.Index(b, []byte{0xe7, 0x03, 0, 0, 0, 0, 0, 0})
+	if end < 0 {
+		t.Fatal("no end of dictionary record")
+	}
+	return b, 176, end + 8
+}
+
+func record(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[i*4:], v)
+	}
+	return b
+}
+
+func Test_readMalformed(t *testing.T) {
+
+	valid, variable, data := malformedSav(t)
+	splice := func(at int, insert []byte) []byte {
+		b := append([]byte{}, valid[:at]...)
+		return append(append(b, insert...), valid[at:]...)
+	}
+	patch := func(at int, with []byte) []byte {
+		b := append([]byte{}, valid...)
+		copy(b[at:], with)
+		return b
+	}
+	minusOne := ^uint32(0)
+
+	zsav := patch(data, append(record(0, 0), record(0, 0, minusOne, minusOne>>1)...))
+	copy(zsav, "$FL3")
+	copy(zsav[72:], record(compressZlib))
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"negative label length", patch(variable+4+28, record(minusOne))},
+		{"label past the end", patch(variable+4+28, record(1<<30))},
+		{"missing value count", patch(variable+4+8, record(99))},
+		{"negative document count", splice(variable, record(recordDocument, minusOne))},
+		{"huge document count", splice(variable, record(recordDocument, 1<<30))},
+		{"huge extension", splice(variable, record(recordExtension, 3, minusOne, minusOne))},
+		{"huge zlib trailer", zsav},
+		{"long string labels past the end", splice(variable, record(recordExtension, extLongStringLabels, 1, 4, 99))},
+		{"long string missing values past the end", splice(variable, record(recordExtension, extLongStringMissing, 1, 4, 99))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "malformed.sav")
+			if err := os.WriteFile(fileName, test.file, 0666); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := ReadRecords(fileName)
+			var parseError *ParseError
+			if !errors.As(err, &parseError) || parseError.Code != ErrParse {
+				t.Errorf("ReadRecords did not reject the file, got: %v, want: %v.", err, ErrParse)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package spss
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -50,3 +55,213 @@ func Test_dataset(t *testing.T) {
 	t.Logf("Test finished - reader")
 
 }
+
+func Test_inspect(t *testing.T) {
+
+	meta, err := Inspect("testdata/ips1710bv2.sav")
+	if err != nil {
+		panic(err)
+	}
+
+	if len(meta.Variables) != meta.VarCount {
+		t.Errorf("Inspect variable count is incorrect, got: %d, want: %d.", len(meta.Variables), meta.VarCount)
+	}
+
+	if _, ok := meta.Variable("Serial"); !ok {
+		t.Errorf("Inspect did not find variable: %s", "Serial")
+	}
+
+	t.Logf("Total Rows: %d, Variables: %d\n", meta.RowCount, meta.VarCount)
+}
+
+// writeLabelledSav writes a small SAV file with variable and value labels to a temporary
+// directory, for tests that cannot rely on testdata
+func writeLabelledSav(t *testing.T) string {
+	headers := []Header{
+		{SavType: ReadstatTypeDouble, Name: "Serial", Label: "Serial number"},
+		{
+			SavType: ReadstatTypeDouble, Name: "Sex", Label: "Sex of respondent",
+			ValueLabels: []ValueLabel{{Value: 1.0, Label: "Male"}, {Value: 2.0, Label: "Female"}},
+		},
+		{
+			SavType: ReadstatTypeString, Name: "Version", Label: "Questionnaire version",
+			ValueLabels: []ValueLabel{{Value: "v1", Label: "First version"}},
+		},
+	}
+	data := []DataItem{
+		{[]interface{}{1.0, 1.0, "v1"}},
+		{[]interface{}{2.0, 2.0, "v2"}},
+		{[]interface{}{3.0, 1.0, "v1"}},
+	}
+	fileName := filepath.Join(t.TempDir(), "labelled.sav")
+	if err := Export(fileName, "labelled", headers, data); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func Test_inspectDictionary(t *testing.T) {
+
+	fileName := writeLabelledSav(t)
+
+	// drop the data section, Inspect must not need it
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := bytes.Index(b, []byte{0xe7, 0x03, 0, 0, 0, 0, 0, 0})
+	if end < 0 {
+		t.Fatal("no end of dictionary record")
+	}
+	if err := os.WriteFile(fileName, b[:end+8], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := Inspect(fileName)
+	if err != nil {
+		t.Fatalf("Inspect read the data section, got: %v", err)
+	}
+	if meta.RowCount != 3 || meta.VarCount != 3 || len(meta.Variables) != 3 {
+		t.Errorf("Inspect returned the wrong size, got: %d rows %d variables, want: 3 rows 3 variables.", meta.RowCount, meta.VarCount)
+	}
+	if meta.FileLabel != "labelled" {
+		t.Errorf("Inspect returned the wrong file label, got: %q, want: %q.", meta.FileLabel, "labelled")
+	}
+	sex, ok := meta.Variable("Sex")
+	if !ok || sex.Label != "Sex of respondent" {
+		t.Errorf("Inspect returned the wrong variable label, got: %+v", sex)
+	}
+	if sex.LabelFor(2.0) != "Female" || sex.LabelFor(3.0) != "" {
+		t.Errorf("Inspect returned the wrong value labels, got: %+v", sex.ValueLabels)
+	}
+	if version, _ := meta.Variable("Version"); version.LabelFor("v1") != "First version" {
+		t.Errorf("Inspect returned the wrong string value labels, got: %+v", version.ValueLabels)
+	}
+}
+
+func Test_readContextCancelled(t *testing.T) {
+
+	var spssFile []Mydataset
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ReadContext(ctx, "testdata/ips1710bv2.sav", &spssFile, func(p Progress) {
+		t.Logf("Rows: %d, Percent: %.2f\n", p.Rows, p.Percent)
+	})
+	if err != context.Canceled {
+		t.Errorf("ReadContext did not honour cancellation, got: %v, want: %v.", err, context.Canceled)
+	}
+}
+
+func Test_readContextCancelledFile(t *testing.T) {
+
+	fileName := writeLabelledSav(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	type labelled struct {
+		Serial  float64 `spss:"Serial"`
+		Version string  `spss:"Version"`
+	}
+	var rows []labelled
+	if err := ReadContext(ctx, fileName, &rows, nil); err != context.Canceled {
+		t.Errorf("ReadContext did not honour cancellation, got: %v, want: %v.", err, context.Canceled)
+	}
+	if len(rows) != 0 {
+		t.Errorf("ReadContext read rows after cancellation, got: %d", len(rows))
+	}
+
+	read := 0
+	_, err := ReadRows(ctx, fileName, nil, func(row []interface{}) error {
+		read++
+		return nil
+	})
+	if err != context.Canceled || read != 0 {
+		t.Errorf("ReadRows did not honour cancellation, got: %v after %d rows, want: %v.", err, read, context.Canceled)
+	}
+}
+
+func Test_readErrors(t *testing.T) {
+
+	var spssFile []Mydataset
+
+	err := ReadFromSPSSFile("testdata/does_not_exist.sav", &spssFile)
+	if !errors.Is(err, ErrOpen) {
+		t.Errorf("Read of missing file returned wrong error, got: %v, want: %v.", err, ErrOpen)
+	}
+
+	type unmatched struct {
+		Serial  float64 `spss:"Serial"`
+		Unknown string  `spss:"NotInTheFile"`
+	}
+	var rows []unmatched
+
+	err = ReadFromSPSSFile("testdata/ips1710bv2.sav", &rows)
+	var schemaError *SchemaError
+	if !errors.As(err, &schemaError) {
+		t.Errorf("Read with unmatched tags returned wrong error, got: %v, want: *SchemaError.", err)
+	}
+}
+
+func Test_readSchemaError(t *testing.T) {
+
+	fileName := writeLabelledSav(t)
+	type unmatched struct {
+		Serial  float64 `spss:"Serial"`
+		Unknown string  `spss:"NotInTheFile"`
+	}
+	var rows []unmatched
+
+	err := ReadFromSPSSFile(fileName, &rows)
+	var schemaError *SchemaError
+	if !errors.As(err, &schemaError) {
+		t.Fatalf("Read with unmatched tags returned wrong error, got: %v, want: *SchemaError.", err)
+	}
+	if schemaError.File != fileName || len(schemaError.Missing) != 1 || schemaError.Missing[0] != "NotInTheFile" {
+		t.Errorf("Read with unmatched tags returned the wrong SchemaError, got: %+v", schemaError)
+	}
+
+	type mistyped struct {
+		Serial  float64 `spss:"Serial"`
+		Version float64 `spss:"Version"`
+	}
+	var values []mistyped
+
+	err = ReadFromSPSSFile(fileName, &values)
+	var parseError *ParseError
+	if !errors.As(err, &parseError) || parseError.Code != ErrConvert || parseError.Variable != "Version" || parseError.Row != 1 {
+		t.Errorf("Read into a field of the wrong type returned wrong error, got: %v, want: a conversion error.", err)
+	}
+}
+
+func Test_readRecords(t *testing.T) {
+
+	headers := []Header{
+		{SavType: ReadstatTypeDouble, Name: "Serial", Label: "Serial number"},
+		{SavType: ReadstatTypeString, Name: "Version", Label: "Version"},
+	}
+	data := []DataItem{
+		{[]interface{}{123456.0, "v1"}},
+		{[]interface{}{789012.0, "v2"}},
+	}
+	fileName := filepath.Join(t.TempDir(), "test_records.sav")
+	if err := Export(fileName, "records", headers, data); err != nil {
+		panic(err)
+	}
+
+	records, meta, err := ReadRecords(fileName)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(records) != 2 || meta.VarCount != 2 {
+		t.Errorf("ReadRecords returned the wrong size, got: %d rows %d variables, want: 2 rows 2 variables.", len(records), meta.VarCount)
+	}
+	if records[1].Float("Serial") != 789012 || records[1].String("Version") != "v2" {
+		t.Errorf("ReadRecords returned the wrong values, got: %v", records[1].Map())
+	}
+	if records[0].IsMissing("Serial") || !records[0].IsMissing("Unknown") {
+		t.Errorf("ReadRecords IsMissing is incorrect for: %v", records[0].Map())
+	}
+}
@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 #include "sav_writer.h"
 #include <fcntl.h>
 #include <stdio.h>
@@ -5,17 +8,56 @@
 #include <unistd.h>
 
 const int MAX_STRING = 4096;
+const int PROGRESS_ROWS = 10000;  // report progress every PROGRESS_ROWS rows
 
 readstat_variable_t *save_header(file_header *const *sav_header, int column_cnt,
                                  readstat_writer_t *writer);
 
+static void save_dictionary(readstat_writer_t *writer, readstat_variable_t *variable,
+                            const file_header *header, int index) {
+    int is_string = header->sav_type == READSTAT_TYPE_STRING;
+
+    if (header->format[0] != '\0') {
+        readstat_variable_set_format(variable, header->format);
+    }
+    if (header->measure != READSTAT_MEASURE_UNKNOWN) {
+        readstat_variable_set_measure(variable, (readstat_measure_t) header->measure);
+    }
+
+    if (header->label_cnt > 0) {
+        char name[32];
+        snprintf(name, sizeof(name), "labels%d", index);
+        readstat_label_set_t *label_set =
+                readstat_add_label_set(writer, is_string ? READSTAT_TYPE_STRING : READSTAT_TYPE_DOUBLE, name);
+        for (int i = 0; i < header->label_cnt; i++) {
+            if (is_string) {
+                readstat_label_string_value(label_set, header->label_string_values[i], header->labels[i]);
+            } else {
+                readstat_label_double_value(label_set, header->label_double_values[i], header->labels[i]);
+            }
+        }
+        readstat_variable_set_label_set(variable, label_set);
+    }
+
+    for (int i = 0; i < header->missing_cnt; i++) {
+        if (is_string) {
+            readstat_variable_add_missing_string_value(variable, header->missing_string_values[i]);
+        } else if (header->missing_lo[i] == header->missing_hi[i]) {
+            readstat_variable_add_missing_double_value(variable, header->missing_lo[i]);
+        } else {
+            readstat_variable_add_missing_double_range(variable, header->missing_lo[i], header->missing_hi[i]);
+        }
+    }
+}
+
 static ssize_t write_bytes(const void *data, size_t len, void *ctx) {
     int fd = *(int *) ctx;
     return write(fd, data, len);
 }
 
 int save_sav(const char *output_file, const char *label, file_header **sav_header, int column_cnt,
-             int data_rows, data_item **sav_data) {
+             int data_rows, data_item **sav_data, int ctx) {
+    readstat_error_t error = READSTAT_OK;
     readstat_writer_t *writer = readstat_writer_init();
     readstat_set_data_writer(writer, &write_bytes);
     readstat_writer_set_file_label(writer, label);
@@ -32,45 +74,56 @@ int save_sav(const char *output_file, const char *label, file_header **sav_heade
                 readstat_add_variable(writer, sav_header[i]->name, sav_header[i]->sav_type, cnt);
         sav_header[i]->variable = variable;
         readstat_variable_set_label(variable, sav_header[i]->label);
+        save_dictionary(writer, variable, sav_header[i], i);
+        if (sav_header[i]->weight) {
+            readstat_writer_set_fweight_variable(writer, variable);
+        }
     }
 
     int fd = open(output_file, O_WRONLY | O_CREAT | O_TRUNC, 0666);
 
     if (fd == -1) {
-        return -1;
+        readstat_writer_free(writer);
+        return READSTAT_ERROR_OPEN;
     }
-    readstat_begin_writing_sav(writer, &fd, data_rows);
+
+    error = readstat_begin_writing_sav(writer, &fd, data_rows);
 
     int cnt = 0;
 
-    for (int i = 0; i < data_rows; i++) {
-        readstat_begin_row(writer);
+    for (int i = 0; i < data_rows && error == READSTAT_OK; i++) {
+        if (i % PROGRESS_ROWS == 0 && goWriteProgress(ctx, i, data_rows)) {
+            error = READSTAT_ERROR_USER_ABORT;
+            break;
+        }
+
+        error = readstat_begin_row(writer);
 
-        for (int j = 0; j < column_cnt; j++) {
+        for (int j = 0; j < column_cnt && error == READSTAT_OK; j++) {
             readstat_variable_t *variable = sav_header[j]->variable;
             switch (sav_data[cnt]->sav_type) {
                 case READSTAT_TYPE_STRING:
-                    readstat_insert_string_value(writer, variable, (const char *) sav_data[cnt]->string_value);
+                    error = readstat_insert_string_value(writer, variable, (const char *) sav_data[cnt]->string_value);
                     break;
 
                 case READSTAT_TYPE_INT8:
-                    readstat_insert_int8_value(writer, variable, sav_data[cnt]->int_value);
+                    error = readstat_insert_int8_value(writer, variable, sav_data[cnt]->int_value);
                     break;
 
                 case READSTAT_TYPE_INT16:
-                    readstat_insert_int16_value(writer, variable, sav_data[cnt]->int_value);
+                    error = readstat_insert_int16_value(writer, variable, sav_data[cnt]->int_value);
                     break;
 
                 case READSTAT_TYPE_INT32:
-                    readstat_insert_int32_value(writer, variable, sav_data[cnt]->int_value);
+                    error = readstat_insert_int32_value(writer, variable, sav_data[cnt]->int_value);
                     break;
 
                 case READSTAT_TYPE_FLOAT:
-                    readstat_insert_float_value(writer, variable, sav_data[cnt]->float_value);
+                    error = readstat_insert_float_value(writer, variable, sav_data[cnt]->float_value);
                     break;
 
                 case READSTAT_TYPE_DOUBLE:
-                    readstat_insert_double_value(writer, variable, sav_data[cnt]->double_value);
+                    error = readstat_insert_double_value(writer, variable, sav_data[cnt]->double_value);
                     break;
 
                 default:
@@ -79,12 +132,20 @@ int save_sav(const char *output_file, const char *label, file_header **sav_heade
             cnt++;
         }
 
-        readstat_end_row(writer);
+        if (error == READSTAT_OK) {
+            error = readstat_end_row(writer);
+        }
     }
 
-    readstat_end_writing(writer);
+    if (error == READSTAT_OK) {
+        error = readstat_end_writing(writer);
+    }
     readstat_writer_free(writer);
     close(fd);
 
-    return 0;
+    if (error == READSTAT_OK) {
+        goWriteProgress(ctx, data_rows, data_rows);
+    }
+
+    return error;
 }
@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 package spss
 
 // #cgo windows amd64 CFLAGS: -g -IC:/msys64/mingw64/include
@@ -6,31 +9,41 @@ package spss
 // #cgo darwin LDFLAGS: -lreadstat
 // #cgo linux amd64 CFLAGS: -I/usr/local/include -g
 // #cgo linux LDFLAGS: -L/usr/local/lib -lreadstat
+// #include "readstat.h"
 // #include "sav_writer.h"
 // #include <stdlib.h>
 import "C"
-import "unsafe"
-
-type Header struct {
-	SavType ColumnType
-	Name    string
-	Label   string
+import (
+	"context"
+	"os"
+	"unsafe"
+)
+
+func Export(fileName string, label string, headers []Header, data []DataItem) error {
+	return ExportContext(context.Background(), fileName, label, headers, data, nil)
 }
 
-type DataItem struct {
-	Value []interface{}
-}
+// ExportContext is Export with cancellation, progress reporting and options. If ctx is cancelled
+// while rows are being written ctx.Err() is returned, and the file is removed as it is after any
+// error writing it. ReadStat only writes UTF-8, files in other encodings are written by the
+// native writer
+func ExportContext(ctx context.Context, fileName string, label string, headers []Header, data []DataItem, progress ProgressFunc, opts ...Option) error {
+
+	if o := newOptions(opts); !isUTF8(o.encoding) {
+		return exportNative(ctx, fileName, label, headers, data, progress, o)
+	}
 
-func Export(fileName string, label string, headers []Header, data []DataItem) int {
+	if err := validateHeaders(headers); err != nil {
+		return &WriteError{Code: ErrValueTypeMismatch, File: fileName, Err: err}
+	}
+	if err := validateData(headers, data); err != nil {
+		return &WriteError{Code: ErrValueTypeMismatch, File: fileName, Err: err}
+	}
 
 	numHeaders := len(headers)
 	cHeaders := (*[1 << 28]*C.file_header)(C.malloc(C.size_t(C.sizeof_file_header * numHeaders)))
 	for i, f := range headers {
-		foo := (*C.file_header)(C.malloc(C.size_t(C.sizeof_file_header)))
-		(*foo).sav_type = C.int(f.SavType)
-		(*foo).name = C.CString(f.Name)
-		(*foo).label = C.CString(f.Label)
-		cHeaders[i] = foo
+		cHeaders[i] = newFileHeader(f)
 	}
 
 	numRows := len(data)
@@ -50,57 +63,35 @@ func Export(fileName string, label string, headers []Header, data []DataItem) in
 			switch headers[j].SavType {
 
 			case ReadstatTypeString:
-				if _, ok := col.(string); !ok {
-					(*dataItem).string_value = C.CString(col.(string))
-					panic("Invalid type, string expected")
-				}
 				(*dataItem).string_value = C.CString(col.(string))
 
-			case ReadstatTypeInt8:
-				if _, ok := col.(int); !ok {
-					panic("Invalid type, int8 expected")
-				}
-				(*dataItem).int_value = C.int(col.(int))
-
-			case ReadstatTypeInt16:
-				if _, ok := col.(int); !ok {
-					panic("Invalid type, int16 expected")
-				}
-				(*dataItem).int_value = C.int(col.(int))
-
-			case ReadstatTypeInt32:
-				if _, ok := col.(int); !ok {
-					panic("Invalid type, int32 expected")
-				}
+			case ReadstatTypeInt8, ReadstatTypeInt16, ReadstatTypeInt32:
 				(*dataItem).int_value = C.int(col.(int))
 
 			case ReadstatTypeFloat:
-				if _, ok := col.(float32); !ok {
-					panic("Invalid type, float32 expected")
-				}
 				(*dataItem).float_value = C.float(col.(float32))
 
 			case ReadstatTypeDouble:
-				if _, ok := col.(float64); !ok {
-					panic("Invalid type, double expected")
-				}
 				(*dataItem).double_value = C.double(col.(float64))
-
-			case ReadstatTypeStringRef:
-				panic("String references not supported")
 			}
 			cDataItem[cnt] = dataItem
 			cnt++
 		}
 	}
 
-	res := C.save_sav(C.CString(fileName), C.CString(label), &cHeaders[0], C.int(numHeaders), C.int(numRows), &cDataItem[0])
+	id := registerContext(&parseContext{ctx: ctx, progress: progress})
+	defer releaseContext(id)
+
+	cFileName := C.CString(fileName)
+	defer C.free(unsafe.Pointer(cFileName))
+	cLabel := C.CString(label)
+	defer C.free(unsafe.Pointer(cLabel))
+
+	res := C.save_sav(cFileName, cLabel, &cHeaders[0], C.int(numHeaders), C.int(numRows), &cDataItem[0], C.int(id))
 
 	// Free up C allocated memory
 	for i := 0; i < numHeaders; i++ {
-		C.free(unsafe.Pointer((*cHeaders[i]).name))
-		C.free(unsafe.Pointer((*cHeaders[i]).label))
-		C.free(unsafe.Pointer(cHeaders[i]))
+		freeFileHeader(cHeaders[i])
 	}
 	C.free(unsafe.Pointer(cHeaders))
 
@@ -112,5 +103,104 @@ func Export(fileName string, label string, headers []Header, data []DataItem) in
 	}
 	C.free(unsafe.Pointer(cDataItem))
 
-	return int(res)
+	if res != 0 {
+		// the file is incomplete unless it could not be opened, when it may be someone else's
+		if ErrorCode(res) != ErrOpen {
+			_ = os.Remove(fileName)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		code := C.readstat_error_t(res)
+		return &WriteError{Code: ErrorCode(res), File: fileName, Message: C.GoString(C.readstat_error_message(code))}
+	}
+
+	return nil
+}
+
+// newFileHeader copies a header, with its value labels and missing values, into C memory
+func newFileHeader(h Header) *C.file_header {
+	header := (*C.file_header)(C.calloc(1, C.size_t(C.sizeof_file_header)))
+	header.sav_type = C.int(h.SavType)
+	header.name = C.CString(h.Name)
+	header.label = C.CString(h.Label)
+	header.format = C.CString(h.Format)
+	header.measure = C.int(h.Measure)
+	if h.Weight {
+		header.weight = 1
+	}
+
+	if n := len(h.ValueLabels); n > 0 {
+		header.label_cnt = C.int(n)
+		header.labels = (**C.char)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(uintptr(0)))))
+		header.label_double_values = (*C.double)(C.calloc(C.size_t(n), C.sizeof_double))
+		header.label_string_values = (**C.char)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(uintptr(0)))))
+		labels := (*[1 << 28]*C.char)(unsafe.Pointer(header.labels))
+		doubles := (*[1 << 28]C.double)(unsafe.Pointer(header.label_double_values))
+		strs := (*[1 << 28]*C.char)(unsafe.Pointer(header.label_string_values))
+		for i, l := range h.ValueLabels {
+			labels[i] = C.CString(l.Label)
+			switch v := l.Value.(type) {
+			case string:
+				strs[i] = C.CString(v)
+			case float64:
+				doubles[i] = C.double(v)
+			}
+		}
+	}
+
+	if n := len(h.Missing); n > 0 {
+		header.missing_cnt = C.int(n)
+		header.missing_lo = (*C.double)(C.calloc(C.size_t(n), C.sizeof_double))
+		header.missing_hi = (*C.double)(C.calloc(C.size_t(n), C.sizeof_double))
+		header.missing_string_values = (**C.char)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(uintptr(0)))))
+		lo := (*[1 << 28]C.double)(unsafe.Pointer(header.missing_lo))
+		hi := (*[1 << 28]C.double)(unsafe.Pointer(header.missing_hi))
+		strs := (*[1 << 28]*C.char)(unsafe.Pointer(header.missing_string_values))
+		for i, m := range h.Missing {
+			switch v := m.Lo.(type) {
+			case string:
+				strs[i] = C.CString(v)
+			case float64:
+				lo[i] = C.double(v)
+				hi[i] = C.double(m.Hi.(float64))
+			}
+		}
+	}
+	return header
+}
+
+func freeFileHeader(header *C.file_header) {
+	labels := (*[1 << 28]*C.char)(unsafe.Pointer(header.labels))
+	strs := (*[1 << 28]*C.char)(unsafe.Pointer(header.label_string_values))
+	for i := 0; i < int(header.label_cnt); i++ {
+		C.free(unsafe.Pointer(labels[i]))
+		C.free(unsafe.Pointer(strs[i]))
+	}
+	missing := (*[1 << 28]*C.char)(unsafe.Pointer(header.missing_string_values))
+	for i := 0; i < int(header.missing_cnt); i++ {
+		C.free(unsafe.Pointer(missing[i]))
+	}
+	C.free(unsafe.Pointer(header.labels))
+	C.free(unsafe.Pointer(header.label_double_values))
+	C.free(unsafe.Pointer(header.label_string_values))
+	C.free(unsafe.Pointer(header.missing_lo))
+	C.free(unsafe.Pointer(header.missing_hi))
+	C.free(unsafe.Pointer(header.missing_string_values))
+	C.free(unsafe.Pointer(header.name))
+	C.free(unsafe.Pointer(header.label))
+	C.free(unsafe.Pointer(header.format))
+	C.free(unsafe.Pointer(header))
+}
+
+//export goWriteProgress
+func goWriteProgress(id C.int, rows, total C.int) C.int {
+	percent := 100.0
+	if total > 0 {
+		percent = float64(rows) / float64(total) * 100
+	}
+	if lookupContext(id).report(int(rows), int(total), percent) {
+		return 0
+	}
+	return 1
 }
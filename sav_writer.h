@@ -11,6 +11,22 @@ typedef struct {
     int sav_type;
     const char *name;
     const char *label;
+    const char *format;
+    int measure;
+    int weight;  // the variable weights the cases
+
+    // value labels, string_values is used for string variables and double_values otherwise
+    int label_cnt;
+    double *label_double_values;
+    char **label_string_values;
+    char **labels;
+
+    // missing values, a range when lo and hi differ
+    int missing_cnt;
+    double *missing_lo;
+    double *missing_hi;
+    char **missing_string_values;
+
     readstat_variable_t *variable;
 } file_header;
 
@@ -24,6 +40,8 @@ typedef struct {
 } data_item;
 
 int save_sav(const char *output_file, const char *label,
-             file_header **sav_header, int column_cnt, int data_rows, data_item **sav_data);
+             file_header **sav_header, int column_cnt, int data_rows, data_item **sav_data, int ctx);
+
+extern int goWriteProgress(int, int, int);
 
 #endif
\ No newline at end of file
@@ -0,0 +1,17 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package spss
+
+import "context"
+
+func Export(fileName string, label string, headers []Header, data []DataItem) error {
+	return ExportContext(context.Background(), fileName, label, headers, data, nil)
+}
+
+// ExportContext is Export with cancellation, progress reporting and options. If ctx is cancelled
+// while rows are being written ctx.Err() is returned, and the file is removed as it is after any
+// error writing it
+func ExportContext(ctx context.Context, fileName string, label string, headers []Header, data []DataItem, progress ProgressFunc, opts ...Option) error {
+	return exportNative(ctx, fileName, label, headers, data, progress, newOptions(opts))
+}
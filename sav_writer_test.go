@@ -1,6 +1,13 @@
 package spss
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,9 +26,236 @@ func Test_writer(t *testing.T) {
 	}
 
 	t.Logf("Starting test - writer")
-	err := WriteToSPSSFile("test_output.sav", &wr)
+	err := WriteToSPSSFile(filepath.Join(t.TempDir(), "test_output.sav"), &wr)
 	if err != nil {
 		panic(err)
 	}
 	t.Logf("Test finished - writer")
 }
+
+func Test_roundtrip(t *testing.T) {
+
+	long := strings.Repeat("a long string value, ", 30)
+	headers := []Header{
+		{SavType: ReadstatTypeInt32, Name: "Serial", Label: "Serial number"},
+		{SavType: ReadstatTypeDouble, Name: "Weight", Label: "Survey weight", Weight: true},
+		{SavType: ReadstatTypeString, Name: "VeryLongVariableName", Label: "Comment"},
+	}
+	data := []DataItem{
+		{[]interface{}{1, 10.5, "first"}},
+		{[]interface{}{2, 1e10, long}},
+		{[]interface{}{300, -0.25, "café"}},
+	}
+
+	fileName := filepath.Join(t.TempDir(), "test_roundtrip.sav")
+	if err := Export(fileName, "round trip", headers, data); err != nil {
+		panic(err)
+	}
+
+	meta, err := Inspect(fileName)
+	if err != nil {
+		panic(err)
+	}
+	if meta.RowCount != 3 || meta.VarCount != 3 {
+		t.Errorf("Inspect counts are incorrect, got: %d rows %d variables, want: 3 rows 3 variables.", meta.RowCount, meta.VarCount)
+	}
+	if v, ok := meta.Variable("VeryLongVariableName"); !ok || v.Label != "Comment" {
+		t.Errorf("Inspect did not return the long variable name and label, got: %+v", meta.Variables)
+	}
+	if meta.Weight != "Weight" {
+		t.Errorf("Inspect did not return the weight variable, got: %q, want: Weight.", meta.Weight)
+	}
+
+	rows, err := Import(fileName)
+	if err != nil {
+		panic(err)
+	}
+	want := [][]string{
+		{"Serial", "Weight", "VeryLongVariableName"},
+		{"1.000000", "10.500000", "first"},
+		{"2.000000", "10000000000.000000", strings.Replace(strings.TrimSpace(long), ",", " ", -1)},
+		{"300.000000", "-0.250000", "café"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Import did not return the exported data, got: %v, want: %v.", rows, want)
+	}
+}
+
+func Test_writeDictionary(t *testing.T) {
+
+	headers := []Header{
+		{
+			SavType: ReadstatTypeDouble, Name: "Sex", Label: "Sex of respondent", Format: "F1.0", Measure: MeasureNominal,
+			ValueLabels: []ValueLabel{{1.0, "Male"}, {2.0, "Female"}},
+			Missing:     []MissingRange{{-9.0, -1.0}, {99.0, 99.0}},
+		},
+		{
+			SavType: ReadstatTypeString, Name: "Region", Label: "Region",
+			ValueLabels: []ValueLabel{{"N", "North"}, {"S", "South"}},
+			Missing:     []MissingRange{{"X", "X"}},
+		},
+		{
+			SavType: ReadstatTypeString, Name: "Occupation", Label: "Occupation",
+			ValueLabels: []ValueLabel{{"Software developer", "Developer"}, {"Not known", "Unknown"}},
+			Missing:     []MissingRange{{"Refused", "Refused"}, {"-", "-"}},
+		},
+	}
+	data := []DataItem{
+		{[]interface{}{1.0, "N", "Software developer"}},
+		{[]interface{}{2.0, "S", "Refused"}},
+	}
+
+	fileName := filepath.Join(t.TempDir(), "test_dictionary.sav")
+	if err := Export(fileName, "dictionary", headers, data); err != nil {
+		panic(err)
+	}
+
+	meta, err := Inspect(fileName)
+	if err != nil {
+		panic(err)
+	}
+	for _, h := range headers {
+		v, ok := meta.Variable(h.Name)
+		if !ok {
+			t.Fatalf("Inspect did not return variable %s", h.Name)
+		}
+		if !reflect.DeepEqual(v.ValueLabels, h.ValueLabels) {
+			t.Errorf("%s value labels are incorrect, got: %v, want: %v.", h.Name, v.ValueLabels, h.ValueLabels)
+		}
+		if !reflect.DeepEqual(v.Missing, h.Missing) {
+			t.Errorf("%s missing values are incorrect, got: %v, want: %v.", h.Name, v.Missing, h.Missing)
+		}
+	}
+	if v, _ := meta.Variable("Sex"); v.Format != "F1" || v.Measure != MeasureNominal {
+		t.Errorf("Sex format and measure are incorrect, got: %s %s, want: F1 nominal.", v.Format, v.Measure)
+	}
+
+	headers[0].Missing = []MissingRange{{1.0, 2.0}, {3.0, 4.0}}
+	if err := Export(fileName, "dictionary", headers, data); !errors.Is(err, ErrValueTypeMismatch) {
+		t.Errorf("Export with two missing ranges did not fail, got: %v, want: %v.", err, ErrValueTypeMismatch)
+	}
+
+	headers[0].Missing = nil
+	headers[2].Missing = []MissingRange{{"Does not apply", "Does not apply"}}
+	if err := Export(fileName, "dictionary", headers, data); !errors.Is(err, ErrValueTypeMismatch) {
+		t.Errorf("Export with a missing value longer than 8 bytes did not fail, got: %v, want: %v.", err, ErrValueTypeMismatch)
+	}
+
+	headers[2].Missing = nil
+	headers[1].Weight = true
+	if err := Export(fileName, "dictionary", headers, data); !errors.Is(err, ErrValueTypeMismatch) {
+		t.Errorf("Export with a string weight did not fail, got: %v, want: %v.", err, ErrValueTypeMismatch)
+	}
+}
+
+func Test_encoding(t *testing.T) {
+
+	headers := []Header{
+		{SavType: ReadstatTypeString, Name: "Name", Label: "Café"},
+	}
+	data := []DataItem{
+		{[]interface{}{"café €"}},
+	}
+
+	fileName := filepath.Join(t.TempDir(), "test_windows1252.sav")
+	if err := ExportContext(context.Background(), fileName, "Prix €", headers, data, nil, WithEncoding("windows-1252")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("caf\xe9 \x80")) || bytes.Contains(b, []byte("café")) {
+		t.Errorf("ExportContext did not write the strings in windows-1252.")
+	}
+
+	records, meta, err := ReadRecordsContext(context.Background(), fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.EqualFold(meta.Encoding, "windows-1252") || meta.FileLabel != "Prix €" {
+		t.Errorf("ReadRecordsContext did not return the encoding and label, got: %q %q, want: windows-1252 \"Prix €\".", meta.Encoding, meta.FileLabel)
+	}
+	if v, ok := meta.Variable("Name"); !ok || v.Label != "Café" {
+		t.Errorf("ReadRecordsContext did not return the variable label, got: %+v", meta.Variables)
+	}
+	if len(records) != 1 || records[0].Value("Name") != "café €" {
+		t.Errorf("ReadRecordsContext did not return the windows-1252 string, got: %v, want: café €.", records)
+	}
+
+	// a UTF-8 file read as windows-1252 shows each byte of é as a character
+	fileName = filepath.Join(t.TempDir(), "test_utf8.sav")
+	if err := Export(fileName, "", headers, data); err != nil {
+		t.Fatal(err)
+	}
+	records, _, err = ReadRecordsContext(context.Background(), fileName, nil, WithEncoding("windows-1252"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value("Name") != "cafÃ© â‚¬" {
+		t.Errorf("ReadRecordsContext did not use the encoding given, got: %v, want: cafÃ© â‚¬.", records)
+	}
+}
+
+func Test_exportRows(t *testing.T) {
+
+	headers := []Header{
+		{SavType: ReadstatTypeDouble, Name: "Serial", Label: "Serial number"},
+		{SavType: ReadstatTypeString, Name: "Comment", Label: "Comment"},
+	}
+	long := strings.Repeat("x", 300)
+	fileName := filepath.Join(t.TempDir(), "test_rows.sav")
+	err := ExportRows(context.Background(), fileName, "rows", headers, func(write func(row []interface{}) error) error {
+		row := make([]interface{}, 2)
+		for i, comment := range []string{"short", long, ""} {
+			row[0], row[1] = float64(i+1), comment
+			if err := write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, meta, err := ReadRecords(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.RowCount != 3 || meta.FileLabel != "rows" || len(records) != 3 {
+		t.Fatalf("ExportRows wrote the wrong file, got: %d rows %q.", meta.RowCount, meta.FileLabel)
+	}
+	if records[0].Value("Comment") != "short" || records[1].Value("Comment") != long || records[2].Float("Serial") != 3 {
+		t.Errorf("ExportRows did not write the rows, got: %v.", records)
+	}
+
+	fileName = filepath.Join(t.TempDir(), "test_rows_invalid.sav")
+	err = ExportRows(context.Background(), fileName, "", headers, func(write func(row []interface{}) error) error {
+		return write([]interface{}{1, "int instead of float64"})
+	}, nil)
+	var writeError *WriteError
+	if !errors.As(err, &writeError) || writeError.Code != ErrValueTypeMismatch {
+		t.Errorf("ExportRows did not reject the row, got: %v, want: %v.", err, ErrValueTypeMismatch)
+	}
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("ExportRows created a file for rows it rejected.")
+	}
+}
+
+func Test_writeContextCancelled(t *testing.T) {
+
+	headers := []Header{{SavType: ReadstatTypeDouble, Name: "Serial"}}
+	data := []DataItem{{[]interface{}{1.0}}, {[]interface{}{2.0}}}
+	fileName := filepath.Join(t.TempDir(), "test_cancelled.sav")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := ExportContext(ctx, fileName, "", headers, data, nil)
+	if err != context.Canceled {
+		t.Errorf("ExportContext did not honour cancellation, got: %v, want: %v.", err, context.Canceled)
+	}
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("ExportContext left the incomplete file %s.", fileName)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"upper.io/db.v3"
+)
+
+// auditEntry is a row of the audit log, recording which client sent which file or fetched which
+// result. Handlers fill in what they learn about the files through auditFrom
+type auditEntry struct {
+	ID            int64     `db:"id,omitempty"`
+	Time          time.Time `db:"time"`
+	Client        string    `db:"client"`
+	Remote        string    `db:"remote"`
+	Method        string    `db:"method"`
+	Route         string    `db:"route"`
+	Path          string    `db:"path"`
+	Status        int       `db:"status"`
+	FileName      string    `db:"file_name"` // name of the uploaded file as sent by the client
+	Format        string    `db:"format"`    // format of the data read or written
+	Job           string    `db:"job"`
+	RequestBytes  int64     `db:"request_bytes"`
+	RequestSHA256 string    `db:"request_sha256"` // hash of the body as far as it was read
+	ResponseBytes int64     `db:"response_bytes"`
+}
+
+type auditKey struct{}
+
+func withAudit(ctx context.Context, e *auditEntry) context.Context {
+	return context.WithValue(ctx, auditKey{}, e)
+}
+
+// auditFrom returns the audit entry of a request. Requests that are not audited get an entry
+// that is thrown away, so handlers can always fill it in
+func auditFrom(ctx context.Context) *auditEntry {
+	if e, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		return e
+	}
+	return &auditEntry{}
+}
+
+func (s *jobStore) audit(e *auditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Collection("audit").Insert(e)
+	return err
+}
+
+// uploaded returns the bytes the client has uploaded on the UTC day of t
+func (s *jobStore) uploaded(client string, t time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var usage struct {
+		Bytes int64 `db:"bytes"`
+	}
+	err := s.db.Collection("uploads").Find(db.Cond{"client": client, "day": t.UTC().Format("2006-01-02")}).One(&usage)
+	if err == db.ErrNoMoreRows {
+		return 0, nil
+	}
+	return usage.Bytes, err
+}
+
+func (s *jobStore) addUpload(client string, t time.Time, n int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Exec(`insert into uploads (client, day, bytes) values (?, ?, ?)
+		on conflict (client, day) do update set bytes = bytes + excluded.bytes`, client, t.UTC().Format("2006-01-02"), n)
+	return err
+}
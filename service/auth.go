@@ -0,0 +1,414 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of an HMAC signed request. The signature is the hex HMAC-SHA256, keyed with the
+// client's secret, of the method, request URI, timestamp and content hash joined by newlines
+const (
+	headerAPIKey        = "X-Api-Key"
+	headerKeyID         = "X-Gospss-Key-Id"
+	headerTimestamp     = "X-Gospss-Timestamp"
+	headerContentSHA256 = "X-Gospss-Content-Sha256"
+	headerSignature     = "X-Gospss-Signature"
+)
+
+// maxClockSkew is how far the timestamp of a signed request may be from the server's clock,
+// it bounds how long a captured request can be replayed
+const maxClockSkew = 5 * time.Minute
+
+// client is a caller of the API, loaded from the clients file. A client has an API key, an
+// HMAC secret or both
+type client struct {
+	ID          string   `json:"id"`
+	Key         string   `json:"key,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+	RateLimit   float64  `json:"rate_limit"`   // requests per second, 0 is unlimited
+	Burst       int      `json:"burst"`        // requests allowed at once above the rate
+	UploadQuota byteSize `json:"upload_quota"` // bytes uploaded per UTC day, 0 is unlimited
+
+	limiter *tokenBucket
+	usage   *uploadUsage
+}
+
+// loadClients reads a JSON file of the form {"clients": [{"id": "...", "key": "..."}]}
+func loadClients(fileName string) ([]*client, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Clients []*client `json:"clients"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	if len(file.Clients) == 0 {
+		return nil, fmt.Errorf("%s: no clients", fileName)
+	}
+	ids := make(map[string]bool)
+	for i, c := range file.Clients {
+		switch {
+		case c.ID == "":
+			return nil, fmt.Errorf("%s: client %d has no id", fileName, i+1)
+		case ids[c.ID]:
+			return nil, fmt.Errorf("%s: client %s is listed twice", fileName, c.ID)
+		case c.Key == "" && c.Secret == "":
+			return nil, fmt.Errorf("%s: client %s has neither a key nor a secret", fileName, c.ID)
+		case c.RateLimit < 0 || c.Burst < 0 || c.UploadQuota < 0:
+			return nil, fmt.Errorf("%s: client %s has a negative limit", fileName, c.ID)
+		}
+		ids[c.ID] = true
+	}
+	return file.Clients, nil
+}
+
+// authenticator identifies the client sending a request from one kind of credentials. It
+// returns a nil client when the request carries none of its credentials, and the hex SHA-256
+// the request body must have when that is part of the credentials
+type authenticator interface {
+	authenticate(r *http.Request) (c *client, bodySHA256 string, err error)
+}
+
+// apiKeys authenticates requests with a static key in the X-Api-Key header or as a bearer
+// token. Keys are looked up by their hash so the lookup time does not depend on the key
+type apiKeys map[[sha256.Size]byte]*client
+
+func newAPIKeys(clients []*client) apiKeys {
+	keys := make(apiKeys)
+	for _, c := range clients {
+		if c.Key != "" {
+			keys[sha256.Sum256([]byte(c.Key))] = c
+		}
+	}
+	return keys
+}
+
+func (keys apiKeys) authenticate(r *http.Request) (*client, string, error) {
+	key := r.Header.Get(headerAPIKey)
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		return nil, "", nil
+	}
+	c, ok := keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, "", errorf(http.StatusUnauthorized, "unauthorized", "unknown API key")
+	}
+	return c, "", nil
+}
+
+// hmacSigned authenticates requests signed with a client's secret, see headerSignature
+type hmacSigned struct {
+	clients map[string]*client
+	now     func() time.Time
+}
+
+func newHMACSigned(clients []*client) *hmacSigned {
+	h := &hmacSigned{clients: make(map[string]*client), now: time.Now}
+	for _, c := range clients {
+		if c.Secret != "" {
+			h.clients[c.ID] = c
+		}
+	}
+	return h
+}
+
+func (h *hmacSigned) authenticate(r *http.Request) (*client, string, error) {
+	id := r.Header.Get(headerKeyID)
+	if id == "" {
+		return nil, "", nil
+	}
+	timestamp := r.Header.Get(headerTimestamp)
+	contentSHA256 := strings.ToLower(r.Header.Get(headerContentSHA256))
+	signature, err := hex.DecodeString(r.Header.Get(headerSignature))
+	if err != nil || len(signature) == 0 || timestamp == "" || len(contentSHA256) != 2*sha256.Size {
+		return nil, "", errorf(http.StatusUnauthorized, "unauthorized", "a signed request needs the %s, %s, %s and %s headers",
+			headerKeyID, headerTimestamp, headerContentSHA256, headerSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, "", errorf(http.StatusUnauthorized, "unauthorized", "%s must be Unix seconds", headerTimestamp)
+	}
+	if skew := h.now().Sub(time.Unix(seconds, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, "", errorf(http.StatusUnauthorized, "unauthorized", "the request timestamp is more than %v from the server's clock", maxClockSkew)
+	}
+
+	c, ok := h.clients[id]
+	if !ok || !hmac.Equal(signature, sign(c.Secret, r.Method, r.URL.RequestURI(), timestamp, contentSHA256)) {
+		return nil, "", errorf(http.StatusUnauthorized, "unauthorized", "invalid signature")
+	}
+	return c, contentSHA256, nil
+}
+
+// sign returns the signature of a request, see headerSignature
+func sign(secret, method, requestURI, timestamp, contentSHA256 string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = io.WriteString(mac, method+"\n"+requestURI+"\n"+timestamp+"\n"+contentSHA256)
+	return mac.Sum(nil)
+}
+
+// auth is the authentication middleware configuration: the authenticators tried in turn and
+// the store where upload usage and the audit log are kept
+type auth struct {
+	authenticators []authenticator
+	store          *jobStore
+	now            func() time.Time
+}
+
+// newAuth accepts API keys and HMAC signed requests from clients. With no clients every
+// request is let through as anonymous but still audited
+func newAuth(clients []*client, store *jobStore) *auth {
+	a := &auth{store: store, now: time.Now}
+	for _, c := range clients {
+		if c.RateLimit > 0 {
+			c.limiter = newTokenBucket(c.RateLimit, c.Burst)
+		}
+		if c.UploadQuota > 0 {
+			c.usage = &uploadUsage{}
+		}
+	}
+	if len(clients) > 0 {
+		a.authenticators = []authenticator{newAPIKeys(clients), newHMACSigned(clients)}
+	}
+	return a
+}
+
+// anonymous is the client of every request when authentication is disabled
+var anonymous = &client{ID: "anonymous"}
+
+// identify returns the client sending r
+func (a *auth) identify(r *http.Request) (*client, string, error) {
+	if len(a.authenticators) == 0 {
+		return anonymous, "", nil
+	}
+	for _, auth := range a.authenticators {
+		c, bodySHA256, err := auth.authenticate(r)
+		if err != nil || c != nil {
+			return c, bodySHA256, err
+		}
+	}
+	return nil, "", errorf(http.StatusUnauthorized, "unauthorized", "send an API key in %s or sign the request", headerAPIKey)
+}
+
+// authenticate is the middleware identifying the client of every request to a route that is
+// not public. It applies the client's rate limit and upload quota and writes the request to
+// the audit log
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeName(r)
+		if s.auth == nil || publicRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &auditEntry{Time: s.auth.now().UTC(), Method: r.Method, Route: route, Path: r.URL.Path, Remote: r.RemoteAddr}
+		body := &auditBody{r: r.Body, hash: sha256.New()}
+		defer func() {
+			entry.Status, entry.ResponseBytes = http.StatusOK, 0
+			if rec, ok := w.(*statusRecorder); ok {
+				entry.Status, entry.ResponseBytes = rec.status, rec.bytes
+			}
+			entry.RequestBytes, entry.RequestSHA256 = body.n, hex.EncodeToString(body.hash.Sum(nil))
+			if err := s.auth.store.audit(entry); err != nil {
+				s.logger.Error("cannot write audit log", "client", entry.Client, "path", entry.Path, "error", err)
+			}
+			if body.client != nil {
+				s.auth.release(body.client, entry.Time, body.reserved-body.n)
+			}
+			if entry.RequestBytes > 0 && entry.Client != "" {
+				if err := s.auth.store.addUpload(entry.Client, entry.Time, entry.RequestBytes); err != nil {
+					s.logger.Error("cannot record upload", "client", entry.Client, "error", err)
+				}
+			}
+		}()
+
+		c, bodySHA256, err := s.auth.identify(r)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		entry.Client = c.ID
+
+		if c.limiter != nil {
+			if ok, wait := c.limiter.allow(s.auth.now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				s.writeError(w, r, errorf(http.StatusTooManyRequests, "rate_limited", "more than %g requests a second", c.RateLimit))
+				return
+			}
+		}
+
+		if c.usage != nil {
+			// the declared length is reserved up front, so concurrent uploads cannot go over
+			// the quota together. A body of unknown length reserves bytes as it is read
+			reserve := r.ContentLength
+			if reserve < 0 {
+				reserve = 0
+			}
+			if err := s.auth.reserve(c, entry.Time, reserve); err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			body.auth, body.client, body.day, body.reserved = s.auth, c, entry.Time, reserve
+		}
+		r.Body = body
+
+		if bodySHA256 != "" {
+			fileName, err := s.verifyBody(r, bodySHA256)
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			defer os.Remove(fileName)
+		}
+		next.ServeHTTP(w, r.WithContext(withAudit(r.Context(), entry)))
+	})
+}
+
+func errQuota(c *client) *apiError {
+	return errorf(http.StatusTooManyRequests, "quota_exceeded", "the daily upload quota of %s is used up", c.UploadQuota.String())
+}
+
+// verifyBody copies the body of a signed request to a temporary file, checks it has the signed
+// hash and replaces the body with the file. A handler may stop reading before the end of the
+// body so it cannot be checked as it is read
+func (s *server) verifyBody(r *http.Request, want string) (string, error) {
+	f, err := ioutil.TempFile(s.tempDir, "go-spss-signed-*")
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), http.MaxBytesReader(nil, r.Body, s.maxUpload))
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != want {
+		err = errorf(http.StatusUnauthorized, "unauthorized", "the body does not match %s", headerContentSHA256)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	r.Body = f
+	return f.Name(), nil
+}
+
+// auditBody counts and hashes the request body for the audit log, and stops reading when the
+// client's upload quota runs out
+type auditBody struct {
+	r    io.ReadCloser
+	hash hash.Hash
+	n    int64
+
+	// set when the client has an upload quota
+	auth     *auth
+	client   *client
+	day      time.Time
+	reserved int64 // bytes reserved in the client's usage
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	_, _ = b.hash.Write(p[:n])
+	b.n += int64(n)
+	if b.client != nil && b.n > b.reserved {
+		if err := b.auth.reserve(b.client, b.day, b.n-b.reserved); err != nil {
+			return n, err
+		}
+		b.reserved = b.n
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	return b.r.Close()
+}
+
+// uploadUsage is the bytes a client with an upload quota has used on a UTC day, including the
+// bytes reserved by uploads in progress. It is read from the store on the first upload of the
+// day and kept in memory after that, as the store is only written when a request finishes
+type uploadUsage struct {
+	mu   sync.Mutex
+	day  string
+	used int64
+}
+
+// reserve adds n bytes to the usage of c on the day of t, unless that goes over its quota
+func (a *auth) reserve(c *client, t time.Time, n int64) error {
+	u := c.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	day := t.UTC().Format("2006-01-02")
+	if u.day != day {
+		used, err := a.store.uploaded(c.ID, t)
+		if err != nil {
+			return err
+		}
+		u.day, u.used = day, used
+	}
+	if quota := int64(c.UploadQuota); u.used >= quota || n > quota-u.used {
+		return errQuota(c)
+	}
+	u.used += n
+	return nil
+}
+
+// release takes n bytes reserved on the day of t but not uploaded off the usage of c. n is
+// negative when the body was read past the quota, so the usage still matches the store
+func (a *auth) release(c *client, t time.Time, n int64) {
+	u := c.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.day == t.UTC().Format("2006-01-02") {
+		u.used -= n
+	}
+}
+
+// tokenBucket is a rate limiter allowing rate requests a second with bursts of burst requests
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow takes a token if there is one, otherwise it returns how long until there will be
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
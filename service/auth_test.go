@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"upper.io/db.v3"
+)
+
+func newAuthServer(t *testing.T, clients ...*client) *server {
+	s := newTestServer(t, 1<<20)
+	s.auth = newAuth(clients, s.jobs.store)
+	return s
+}
+
+func signedRequest(secret, id, method, target string, body []byte, at time.Time) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(headerKeyID, id)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerContentSHA256, hex.EncodeToString(sum[:]))
+	req.Header.Set(headerSignature, hex.EncodeToString(sign(secret, method, req.URL.RequestURI(), timestamp, hex.EncodeToString(sum[:]))))
+	return req
+}
+
+func Test_apiKeys(t *testing.T) {
+
+	s := newAuthServer(t, &client{ID: "analytics", Key: "k1"})
+	sav := testSav()
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"no key", "", "", http.StatusUnauthorized},
+		{"unknown key", headerAPIKey, "k2", http.StatusUnauthorized},
+		{"api key", headerAPIKey, "k1", http.StatusOK},
+		{"bearer token", "Authorization", "Bearer k1", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/convert/csv", bytes.NewReader(sav))
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: POST /convert/csv returned the wrong status, got: %d, want: %d.", c.name, rec.Code, c.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz needs a key, got: %d, want: %d.", rec.Code, http.StatusOK)
+	}
+
+	var entries []auditEntry
+	if err := s.jobs.store.db.Collection("audit").Find().OrderBy("id").All(&entries); err != nil {
+		panic(err)
+	}
+	if len(entries) != 4 || entries[0].Status != http.StatusUnauthorized || entries[2].Client != "analytics" ||
+		entries[2].Format != "csv" || entries[2].RequestBytes != int64(len(sav)) || entries[2].ResponseBytes == 0 {
+		t.Errorf("the audit log is wrong, got: %+v", entries)
+	}
+}
+
+func Test_hmacSigned(t *testing.T) {
+
+	s := newAuthServer(t, &client{ID: "etl", Secret: "s3cret"})
+	sav := testSav()
+	now := time.Now()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, signedRequest("s3cret", "etl", http.MethodPost, "/convert/csv?labels=true", sav, now))
+	if rec.Code != http.StatusOK {
+		t.Errorf("a signed request failed, got: %d %s", rec.Code, rec.Body.String())
+	}
+
+	tampered := signedRequest("s3cret", "etl", http.MethodPost, "/convert/csv", sav, now)
+	tampered.Body = http.NoBody
+	stale := signedRequest("s3cret", "etl", http.MethodPost, "/convert/csv", sav, now.Add(-time.Hour))
+	wrongSecret := signedRequest("guess", "etl", http.MethodPost, "/convert/csv", sav, now)
+	otherURL := signedRequest("s3cret", "etl", http.MethodPost, "/convert/csv", sav, now)
+	otherURL.URL.RawQuery = "labels=true"
+	for name, req := range map[string]*http.Request{"tampered body": tampered, "stale": stale, "wrong secret": wrongSecret, "other url": otherURL} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != "unauthorized" {
+			t.Errorf("%s: a badly signed request was accepted, got: %d", name, rec.Code)
+		}
+	}
+}
+
+func Test_limits(t *testing.T) {
+
+	sav := testSav()
+	s := newAuthServer(t,
+		&client{ID: "slow", Key: "slow", RateLimit: 1, Burst: 2},
+		&client{ID: "small", Key: "small", UploadQuota: byteSize(len(sav) + len(sav)/2)},
+		&client{ID: "busy", Key: "busy", UploadQuota: byteSize(len(sav) + len(sav)/2)},
+	)
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil)
+		req.Header.Set(headerAPIKey, "slow")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusNotFound || codes[1] != http.StatusNotFound || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("the rate limit was not applied, got: %v", codes)
+	}
+
+	codes = nil
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/convert/json", bytes.NewReader(sav))
+		req.Header.Set(headerAPIKey, "small")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("the upload quota was not applied, got: %v", codes)
+	}
+	used, err := s.jobs.store.uploaded("small", time.Now())
+	if err != nil {
+		panic(err)
+	}
+	if used != int64(len(sav)) {
+		t.Errorf("the upload was not recorded, got: %d, want: %d.", used, len(sav))
+	}
+	if n, _ := s.jobs.store.db.Collection("audit").Find(db.Cond{"client": "small", "status": http.StatusTooManyRequests}).Count(); n != 2 {
+		t.Errorf("the audit log is missing rejected uploads, got: %d", n)
+	}
+
+	// uploads running at the same time share the quota
+	var wg sync.WaitGroup
+	codes = make([]int, 4)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/convert/json", bytes.NewReader(sav))
+			req.Header.Set(headerAPIKey, "busy")
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("concurrent uploads went over the quota, got: %v", codes)
+	}
+
+	// a body of unknown length is stopped when it goes over the quota
+	req := httptest.NewRequest(http.MethodPost, "/convert/json", bytes.NewReader(sav))
+	req.ContentLength = -1
+	req.Header.Set(headerAPIKey, "busy")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("an upload of unknown length went over the quota, got: %d", rec.Code)
+	}
+}
+
+func Test_jobOwner(t *testing.T) {
+
+	s := newAuthServer(t, &client{ID: "owner", Key: "owner"}, &client{ID: "other", Key: "other"})
+	req := httptest.NewRequest(http.MethodPost, "/jobs?format=csv", bytes.NewReader(testSav()))
+	req.Header.Set(headerAPIKey, "owner")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs returned the wrong status, got: %d %s, want: %d.", rec.Code, rec.Body.String(), http.StatusAccepted)
+	}
+	location := rec.Header().Get("Location")
+
+	for _, target := range []string{location, location + "/result"} {
+		for key, want := range map[string]int{"owner": http.StatusOK, "other": http.StatusNotFound} {
+			code := 0
+			for i := 0; i < 100; i++ {
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				req.Header.Set(headerAPIKey, key)
+				rec := httptest.NewRecorder()
+				s.ServeHTTP(rec, req)
+				if code = rec.Code; code != http.StatusConflict {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if code != want {
+				t.Errorf("GET %s by %s returned the wrong status, got: %d, want: %d.", target, key, code, want)
+			}
+		}
+	}
+}
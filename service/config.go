@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the upper case name of a flag to give the environment variable
+// that sets it, so -max-upload is also GOSPSS_MAX_UPLOAD
+const envPrefix = "GOSPSS_"
+
+// config is the service configuration. Flags take precedence over the environment, which takes
+// precedence over the defaults
+type config struct {
+	addr            string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	maxUpload       byteSize
+	tempDir         string
+	workers         int
+	queueSize       int
+	resultTTL       time.Duration
+	database        string
+	clients         string
+	noAuth          bool
+	logFormat       string
+	logLevel        slog.Level
+}
+
+// loadConfig parses the flags in args and the environment read by getenv. Errors and the usage
+// are printed to output
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (*config, error) {
+	c := &config{maxUpload: 1 << 30}
+
+	fs := flag.NewFlagSet("go-spss-service", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&c.addr, "addr", ":8080", "address to listen on")
+	fs.DurationVar(&c.readTimeout, "read-timeout", 10*time.Minute, "longest time to read a request, including the upload")
+	fs.DurationVar(&c.writeTimeout, "write-timeout", 30*time.Minute, "longest time from the end of a request to the end of its response")
+	fs.DurationVar(&c.idleTimeout, "idle-timeout", 2*time.Minute, "longest time a keep-alive connection waits for the next request")
+	fs.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 5*time.Minute, "longest time to wait for requests and jobs to finish on SIGTERM")
+	fs.Var(&c.maxUpload, "max-upload", "largest request body accepted, in bytes or with a KiB, MiB or GiB suffix")
+	fs.StringVar(&c.tempDir, "temp-dir", os.TempDir(), "directory for uploads and job results")
+	fs.IntVar(&c.workers, "workers", 2, "conversion jobs run at the same time")
+	fs.IntVar(&c.queueSize, "queue-size", 100, "conversion jobs waiting for a worker")
+	fs.DurationVar(&c.resultTTL, "result-ttl", 24*time.Hour, "longest time the result of a job is kept when it is not downloaded")
+	fs.StringVar(&c.database, "db", "LFS.db", "SQLite database holding the job store")
+	fs.StringVar(&c.clients, "clients", "", "JSON file of the clients allowed to use the API, with their keys and limits")
+	fs.BoolVar(&c.noAuth, "no-auth", false, "leave the API open to anyone, requests are still audited")
+	fs.StringVar(&c.logFormat, "log-format", "json", "log format: json or text")
+	fs.TextVar(&c.logLevel, "log-level", slog.LevelInfo, "lowest level logged: debug, info, warn or error")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-spss-service [flags]\n\nEvery flag can also be set with an environment variable, -max-upload with %sMAX_UPLOAD.\n\n", envPrefix)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value := getenv(name)
+		if err != nil || set[f.Name] || value == "" {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %v", name, setErr)
+		}
+	})
+	if err == nil {
+		err = c.validate()
+	}
+	if err != nil {
+		fmt.Fprintf(output, "go-spss-service: %v\n", err)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.workers < 1:
+		return errors.New("workers must be at least 1")
+	case c.queueSize < 0:
+		return errors.New("queue-size cannot be negative")
+	case c.resultTTL <= 0:
+		return errors.New("result-ttl must be positive")
+	case c.maxUpload <= 0:
+		return errors.New("max-upload must be positive")
+	case c.clients == "" && !c.noAuth:
+		return errors.New("set -clients to a clients file, or -no-auth to leave the API open")
+	case c.clients != "" && c.noAuth:
+		return errors.New("-clients and -no-auth cannot both be set")
+	case c.logFormat != "json" && c.logFormat != "text":
+		return fmt.Errorf("unsupported log-format %q, expected json or text", c.logFormat)
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// newLogger returns the structured logger writing to w in the configured format
+func (c *config) newLogger(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: c.logLevel}
+	if c.logFormat == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// byteSize is a size in bytes that can be written with a binary unit suffix
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	for _, u := range byteUnits {
+		if *b != 0 && int64(*b)%u.size == 0 {
+			return strconv.FormatInt(int64(*b)/u.size, 10) + u.suffix
+		}
+	}
+	return "0"
+}
+
+func (b *byteSize) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+func (b *byteSize) Set(s string) error {
+	s = strings.TrimSpace(s)
+	size := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, size = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * size)
+	return nil
+}
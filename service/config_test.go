@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func Test_loadConfig(t *testing.T) {
+
+	env := map[string]string{
+		"GOSPSS_ADDR":       ":9090",
+		"GOSPSS_WORKERS":    "4",
+		"GOSPSS_MAX_UPLOAD": "512MiB",
+		"GOSPSS_NO_AUTH":    "true",
+	}
+	cfg, err := loadConfig([]string{"-workers", "8", "-read-timeout", "1m"}, func(name string) string { return env[name] }, ioutil.Discard)
+	if err != nil {
+		panic(err)
+	}
+	if cfg.addr != ":9090" || cfg.workers != 8 || cfg.maxUpload != 512<<20 || cfg.readTimeout != time.Minute || cfg.queueSize != 100 {
+		t.Errorf("loadConfig returned the wrong config, got: %+v", cfg)
+	}
+
+	for _, args := range [][]string{{"-workers", "0", "-no-auth"}, {"-max-upload", "lots", "-no-auth"}, {"-log-format", "xml", "-no-auth"}, {}} {
+		if _, err := loadConfig(args, func(string) string { return "" }, ioutil.Discard); err == nil {
+			t.Errorf("loadConfig accepted %v", args)
+		}
+	}
+	if _, err := loadConfig(nil, func(name string) string { return map[string]string{"GOSPSS_WORKERS": "many"}[name] }, ioutil.Discard); err == nil {
+		t.Errorf("loadConfig accepted GOSPSS_WORKERS=many")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	spss "go-spss"
+)
+
+// apiError is the JSON body of every error response
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func errorf(status int, code string, format string, a ...interface{}) *apiError {
+	return &apiError{status, code, fmt.Sprintf(format, a...)}
+}
+
+// streamError is returned by a handler that fails after the response has started. It is
+// logged and counted but cannot be sent to the client
+type streamError struct {
+	err error
+}
+
+func (e streamError) Error() string {
+	return e.err.Error()
+}
+
+func (e streamError) Unwrap() error {
+	return e.err
+}
+
+// toAPIError maps the errors of the spss package and the request body onto HTTP statuses
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	var tooLarge *http.MaxBytesError
+	var parseErr *spss.ParseError
+	var writeErr *spss.WriteError
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &tooLarge):
+		return errorf(http.StatusRequestEntityTooLarge, "too_large", "request body is larger than %d bytes", tooLarge.Limit)
+	case errors.As(err, &parseErr):
+		return errorf(http.StatusUnprocessableEntity, "invalid_sav", "%v", err)
+	case errors.As(err, &writeErr):
+		return errorf(http.StatusUnprocessableEntity, "invalid_data", "%v", err)
+	}
+	return errorf(http.StatusInternalServerError, "internal", "%v", err)
+}
+
+func (s *server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := err.(streamError); ok {
+		s.logger.Error("response failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		return
+	}
+
+	apiErr := toAPIError(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		s.logger.Error("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error *apiError `json:"error"`
+	}{apiErr})
+}
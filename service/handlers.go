@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	spss "go-spss"
+	"go-spss/convert"
+)
+
+// maxDictionary is the largest dictionary part accepted by /sav
+const maxDictionary = 10 << 20
+
+var contentTypes = map[string]string{
+	convert.Sav:     "application/x-spss-sav",
+	convert.CSV:     "text/csv; charset=utf-8",
+	convert.JSON:    "application/json",
+	convert.Parquet: "application/vnd.apache.parquet",
+	convert.Arrow:   "application/vnd.apache.arrow.file",
+	convert.XLSX:    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	convert.NDJSON:  "application/x-ndjson",
+	convert.DTA:     "application/x-stata-dta",
+}
+
+type response struct {
+	Result    string   `json:"result"`
+	Endpoints []string `json:"endpoints"`
+}
+
+func (s *server) index(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, response{
+		Result: "All good over here on the server",
+		Endpoints: []string{
+			"POST /dictionary",
+			"POST /convert/{csv,json,ndjson,parquet,arrow,xlsx,dta}",
+			"POST /sav",
+			"POST /jobs?format={csv,json,ndjson,parquet,arrow,xlsx,dta}",
+			"GET /jobs/{id}",
+			"GET /jobs/{id}/result",
+			"GET /healthz",
+			"GET /readyz",
+			"GET /metrics",
+		},
+	})
+}
+
+// dictionary returns the dictionary of an uploaded SAV file
+func (s *server) dictionary(w http.ResponseWriter, r *http.Request) (err error) {
+	task := s.metrics.begin("dictionary", convert.Sav)
+	defer func() { task.done(err) }()
+	auditFrom(r.Context()).Format = convert.Sav
+
+	fileName, size, err := s.saveUpload(r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fileName)
+	task.bytesIn = size
+
+	meta, err := spss.Inspect(fileName)
+	if err != nil {
+		return err
+	}
+	task.rows = meta.RowCount
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(countingWriter{w, &task.bytesOut}).Encode(convert.NewDictionary(meta))
+}
+
+// convert streams an uploaded SAV file back as CSV, JSON, NDJSON, Parquet, Arrow, XLSX or
+// Stata, a row at a time without reading the whole file into memory. The conversion stops when
+// the client goes away. With labels=true value labels are written instead of values, with
+// dictionary=true NDJSON starts with the dictionary of the file
+func (s *server) convert(w http.ResponseWriter, r *http.Request) (err error) {
+	format, err := outputFormat(mux.Vars(r)["format"])
+	if err != nil {
+		return err
+	}
+	task := s.metrics.begin("convert", format)
+	defer func() { task.done(err) }()
+	auditFrom(r.Context()).Format = format
+
+	labels, err := boolParam(r, "labels")
+	if err != nil {
+		return err
+	}
+
+	dictionary, err := boolParam(r, "dictionary")
+	if err != nil {
+		return err
+	}
+
+	fileName, size, err := s.saveUpload(r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fileName)
+	task.bytesIn = size
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", "attachment; filename=\"data."+format+"\"")
+	opts := convert.SavOptions{Labels: labels, Dictionary: dictionary}
+	task.rows, err = convert.ConvertSav(r.Context(), fileName, countingWriter{w, &task.bytesOut}, format, opts)
+	if err != nil && task.bytesOut > 0 {
+		return streamError{err}
+	}
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		return err
+	}
+	return nil
+}
+
+// submitJob queues the conversion of an uploaded SAV file and returns the job, whose status is
+// polled with GET /jobs/{id}
+func (s *server) submitJob(w http.ResponseWriter, r *http.Request) error {
+	format, err := outputFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return err
+	}
+	labels, err := boolParam(r, "labels")
+	if err != nil {
+		return err
+	}
+
+	fileName, _, err := s.saveUpload(r)
+	if err != nil {
+		return err
+	}
+	j, err := s.jobs.submit(auditFrom(r.Context()).Client, fileName, format, labels)
+	if err != nil {
+		_ = os.Remove(fileName)
+		switch err {
+		case errQueueFull:
+			return errorf(http.StatusServiceUnavailable, "queue_full", "too many conversions are waiting, try again later")
+		case errQueueClosed:
+			return errorf(http.StatusServiceUnavailable, "shutting_down", "the service is shutting down, try again later")
+		}
+		return err
+	}
+
+	entry := auditFrom(r.Context())
+	entry.Format, entry.Job = format, j.ID
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(j)
+}
+
+func (s *server) jobStatus(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, j)
+}
+
+// jobResult downloads the converted file of a finished job. The result is removed once it has
+// been downloaded in full, range requests can be used to download it in parts
+func (s *server) jobResult(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	switch j.Status {
+	case jobFailed:
+		return errorf(http.StatusConflict, "job_failed", "job %s failed: %s", j.ID, j.Error)
+	case jobQueued, jobRunning:
+		return errorf(http.StatusConflict, "job_not_finished", "job %s is %s", j.ID, j.Status)
+	case jobExpired:
+		return errorf(http.StatusGone, "result_gone", "the result of job %s has been downloaded or has expired", j.ID)
+	}
+
+	f, err := os.Open(j.Result)
+	if os.IsNotExist(err) {
+		return errorf(http.StatusGone, "result_gone", "the result of job %s has been removed", j.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentTypes[j.Format])
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+j.ID+"."+j.Format+"\"")
+	http.ServeContent(w, r, "", j.Updated, f)
+	_ = f.Close()
+
+	if r.Method == http.MethodGet && r.Header.Get("Range") == "" && r.Context().Err() == nil {
+		s.jobs.expireJob(j)
+	}
+	return nil
+}
+
+// job returns the job in the path of r. Jobs of other clients are not found, so their IDs
+// cannot be probed
+func (s *server) job(r *http.Request) (*job, error) {
+	id := mux.Vars(r)["id"]
+	j, err := s.jobs.store.get(id)
+	if err == nil && j.Client != auditFrom(r.Context()).Client {
+		err = errJobNotFound
+	}
+	if err == errJobNotFound {
+		return nil, errorf(http.StatusNotFound, "not_found", "no job %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := auditFrom(r.Context())
+	entry.Format, entry.Job = j.Format, j.ID
+	return j, nil
+}
+
+// sav builds a SAV file from posted CSV, JSON or NDJSON data. The data is either the request body, or
+// the data part of a multipart form with an optional dictionary part holding a JSON Dictionary
+// that sets the file label, variable order, types, labels and the rest of the dictionary
+func (s *server) sav(w http.ResponseWriter, r *http.Request) (err error) {
+	var t *convert.Table
+	var dict *convert.Dictionary
+
+	task := s.metrics.begin("sav", "")
+	defer func() { task.done(err) }()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return errorf(http.StatusBadRequest, "bad_request", "%v", err)
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errorf(http.StatusBadRequest, "bad_request", "%v", err)
+			}
+			switch part.FormName() {
+			case "data":
+				auditFrom(r.Context()).FileName = part.FileName()
+				in := countingReader{part, &task.bytesIn}
+				if t, task.format, err = readTable(r, part.FileName(), part.Header.Get("Content-Type"), in); err != nil {
+					return err
+				}
+			case "dictionary":
+				dict = &convert.Dictionary{}
+				if err := json.NewDecoder(io.LimitReader(part, maxDictionary)).Decode(dict); err != nil {
+					return errorf(http.StatusBadRequest, "invalid_dictionary", "%v", err)
+				}
+			}
+		}
+		if t == nil {
+			return errorf(http.StatusBadRequest, "bad_request", "missing data part")
+		}
+	} else {
+		in := countingReader{r.Body, &task.bytesIn}
+		if t, task.format, err = readTable(r, "", r.Header.Get("Content-Type"), in); err != nil {
+			return err
+		}
+	}
+
+	auditFrom(r.Context()).Format = task.format
+	if dict != nil {
+		if err := dict.Apply(t); err != nil {
+			return errorf(http.StatusUnprocessableEntity, "invalid_dictionary", "%v", err)
+		}
+	}
+
+	f, err := ioutil.TempFile(s.tempDir, "go-spss-*.sav")
+	if err != nil {
+		return err
+	}
+	fileName := f.Name()
+	_ = f.Close()
+	defer os.Remove(fileName)
+
+	if err := t.WriteSav(fileName); err != nil {
+		return err
+	}
+	task.rows = len(t.Rows)
+
+	out, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+
+	w.Header().Set("Content-Type", contentTypes[convert.Sav])
+	w.Header().Set("Content-Disposition", "attachment; filename=\"data.sav\"")
+	if task.bytesOut, err = io.Copy(w, out); err != nil {
+		return streamError{err}
+	}
+	return nil
+}
+
+func outputFormat(name string) (string, error) {
+	format, err := convert.Format(name, "")
+	if err != nil || format == convert.Sav {
+		return "", errorf(http.StatusUnsupportedMediaType, "unsupported_format", "cannot convert to %q, expected csv, json, ndjson, parquet, arrow, xlsx or dta", name)
+	}
+	return format, nil
+}
+
+// readTable reads posted CSV, JSON or NDJSON. The format is the format query parameter, or else
+// taken from the file name or content type of the upload
+func readTable(r *http.Request, fileName, contentType string, in io.Reader) (*convert.Table, string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" && fileName == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch mediaType {
+		case "text/csv":
+			format = convert.CSV
+		case "application/json":
+			format = convert.JSON
+		case "application/x-ndjson":
+			format = convert.NDJSON
+		}
+	}
+	format, err := convert.Format(format, fileName)
+	if err != nil || format != convert.CSV && format != convert.JSON && format != convert.NDJSON {
+		return nil, "", errorf(http.StatusUnsupportedMediaType, "unsupported_format", "data must be csv, json or ndjson, use the format parameter or a text/csv, application/json or application/x-ndjson content type")
+	}
+
+	var t *convert.Table
+	switch format {
+	case convert.CSV:
+		t, err = convert.ReadCSV(in)
+	case convert.NDJSON:
+		t, err = convert.ReadNDJSON(in)
+	default:
+		t, err = convert.ReadJSON(in)
+	}
+	if err != nil {
+		// errors reading the request, such as a body too large or over the upload quota
+		if apiErr := toAPIError(err); apiErr.Status != http.StatusInternalServerError {
+			return nil, format, apiErr
+		}
+		return nil, format, errorf(http.StatusBadRequest, "invalid_data", "%v", err)
+	}
+	return t, format, nil
+}
+
+// saveUpload writes an uploaded SAV file to the temporary directory and returns its name and
+// size. The file is the file part of a multipart form or else the request body
+func (s *server) saveUpload(r *http.Request) (string, int64, error) {
+	var in io.Reader = r.Body
+	ext := ".sav"
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		part, err := filePart(r)
+		if err != nil {
+			return "", 0, err
+		}
+		auditFrom(r.Context()).FileName = part.FileName()
+		if strings.EqualFold(filepath.Ext(part.FileName()), ".zsav") {
+			ext = ".zsav"
+		}
+		in = part
+	}
+
+	f, err := ioutil.TempFile(s.tempDir, "go-spss-*"+ext)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(f, in)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n == 0 {
+		err = errorf(http.StatusBadRequest, "bad_request", "no SAV file was uploaded")
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), n, nil
+}
+
+func filePart(r *http.Request) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "bad_request", "%v", err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errorf(http.StatusBadRequest, "bad_request", "missing file part")
+		}
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "bad_request", "%v", err)
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+func boolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errorf(http.StatusBadRequest, "bad_request", "%s must be true or false", name)
+	}
+	return b, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	spss "go-spss"
+)
+
+type readiness struct {
+	Status  string            `json:"status"`
+	Backend string            `json:"backend"`
+	Checks  map[string]string `json:"checks"`
+}
+
+// healthz reports the process is up, it does no work so it can be polled often
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether the service can do its work: a SAV file can be written and read back
+// with the compiled in backend, libreadstat unless built with purego, and the job store
+// accepts writes
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) error {
+	ready := readiness{Status: "ok", Backend: spss.Backend, Checks: map[string]string{"sav": "ok", "store": "ok"}}
+
+	if err := s.checkSav(); err != nil {
+		ready.Status, ready.Checks["sav"] = "unavailable", err.Error()
+	}
+	if err := s.jobs.store.ping(); err != nil {
+		ready.Status, ready.Checks["store"] = "unavailable", err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if ready.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return writeJSON(w, ready)
+}
+
+func (s *server) checkSav() error {
+	f, err := ioutil.TempFile(s.tempDir, "go-spss-ready-*.sav")
+	if err != nil {
+		return err
+	}
+	fileName := f.Name()
+	_ = f.Close()
+	defer os.Remove(fileName)
+
+	headers := []spss.Header{{SavType: spss.ReadstatTypeDouble, Name: "ready", Label: "readiness check"}}
+	if err := spss.Export(fileName, "readiness check", headers, []spss.DataItem{{Value: []interface{}{1.0}}}); err != nil {
+		return err
+	}
+	_, err = spss.Inspect(fileName)
+	return err
+}
+
+func (s *server) metricsHandler(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w)
+	return nil
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	spss "go-spss"
+	"go-spss/convert"
+	"upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// Job statuses
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+	jobExpired = "expired" // the result has been downloaded or kept for the result TTL and is removed
+)
+
+// sweepInterval is how often the results of expired jobs are looked for
+const sweepInterval = time.Minute
+
+// job is a conversion run in the background. Its status is kept in the jobs table so it can be
+// polled from any request
+type job struct {
+	ID       string     `db:"id" json:"id"`
+	Client   string     `db:"client" json:"-"` // the client that submitted the job, the only one that can see it
+	Status   string     `db:"status" json:"status"`
+	Format   string     `db:"format" json:"format"`
+	Labels   bool       `db:"labels" json:"labels"`
+	Rows     int        `db:"rows" json:"rows"`
+	Total    int        `db:"total" json:"total"`
+	Progress float64    `db:"progress" json:"progress"`
+	Error    string     `db:"error" json:"error,omitempty"`
+	Input    string     `db:"input" json:"-"`
+	Result   string     `db:"result" json:"-"`
+	Created  time.Time  `db:"created" json:"created"`
+	Updated  time.Time  `db:"updated" json:"updated"`
+	Expires  *time.Time `db:"expires" json:"expires,omitempty"` // when the job finished plus the result TTL
+}
+
+var errJobNotFound = errors.New("job not found")
+
+// jobStore persists jobs, the audit log and upload usage in SQLite. Access is serialised
+// because the shared cache connection reports SQLITE_LOCKED rather than waiting when written to
+// concurrently
+type jobStore struct {
+	mu sync.Mutex
+	db sqlbuilder.Database
+}
+
+var storeTables = []struct{ name, create string }{
+	{"jobs", `create table if not exists jobs (
+		id text primary key,
+		client text not null,
+		status text not null,
+		format text not null,
+		labels boolean not null,
+		rows integer not null,
+		total integer not null,
+		progress double not null,
+		error text not null,
+		input text not null,
+		result text not null,
+		created timestamp not null,
+		updated timestamp not null,
+		expires timestamp
+	)`},
+	{"readiness", `create table if not exists readiness (id integer primary key, checked timestamp not null)`},
+	{"audit", `create table if not exists audit (
+		id integer primary key,
+		time timestamp not null,
+		client text not null,
+		remote text not null,
+		method text not null,
+		route text not null,
+		path text not null,
+		status integer not null,
+		file_name text not null,
+		format text not null,
+		job text not null,
+		request_bytes integer not null,
+		request_sha256 text not null,
+		response_bytes integer not null
+	)`},
+	{"uploads", `create table if not exists uploads (
+		client text not null,
+		day text not null,
+		bytes integer not null,
+		primary key (client, day)
+	)`},
+}
+
+// storeColumns are the columns added to the tables since they were first created, they are
+// added to the tables of an existing database when it is opened
+var storeColumns = []struct{ table, name, definition string }{
+	{"jobs", "client", "text not null default ''"},
+	{"jobs", "expires", "timestamp"},
+}
+
+func newJobStore(sess sqlbuilder.Database) (*jobStore, error) {
+	for _, table := range storeTables {
+		if _, err := sess.Exec(table.create); err != nil {
+			return nil, fmt.Errorf("cannot create %s table: %v", table.name, err)
+		}
+	}
+	for _, col := range storeColumns {
+		row, err := sess.QueryRow(`select count(*) from pragma_table_info(?) where name = ?`, col.table, col.name)
+		var n int
+		if err == nil {
+			err = row.Scan(&n)
+		}
+		if err == nil && n == 0 {
+			_, err = sess.Exec(fmt.Sprintf("alter table %s add column %s %s", col.table, col.name, col.definition))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot add %s column to %s table: %v", col.name, col.table, err)
+		}
+	}
+	return &jobStore{db: sess}, nil
+}
+
+// ping checks the database can be written to
+func (s *jobStore) ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Exec(`replace into readiness (id, checked) values (1, ?)`, time.Now().UTC())
+	return err
+}
+
+func (s *jobStore) insert(j *job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.Collection("jobs").Insert(j)
+	return err
+}
+
+func (s *jobStore) update(j *job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.Updated = time.Now().UTC()
+	return s.db.Collection("jobs").Find(db.Cond{"id": j.ID}).Update(j)
+}
+
+func (s *jobStore) get(id string) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var j job
+	err := s.db.Collection("jobs").Find(db.Cond{"id": id}).One(&j)
+	if err == db.ErrNoMoreRows {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// failUnfinished marks the jobs left queued or running by a previous process as failed, the
+// queue is held in memory so they will never run. It returns the jobs so their files can be
+// removed
+func (s *jobStore) failUnfinished() ([]job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []job
+	res := s.db.Collection("jobs").Find(db.Cond{"status IN": []string{jobQueued, jobRunning}})
+	if err := res.All(&jobs); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	err := res.Update(map[string]interface{}{"status": jobFailed, "error": "the service restarted", "updated": now, "expires": now})
+	return jobs, err
+}
+
+// expired returns the finished jobs whose result TTL ran out before now
+func (s *jobStore) expired(now time.Time) ([]job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []job
+	err := s.db.Collection("jobs").Find(db.Cond{"status IN": []string{jobDone, jobFailed}, "expires <": now.UTC()}).All(&jobs)
+	return jobs, err
+}
+
+// jobQueue runs conversion jobs on a fixed number of workers. Jobs wait in a bounded channel
+// so a burst of uploads cannot exhaust the disk or memory
+type jobQueue struct {
+	store   *jobStore
+	dir     string
+	ttl     time.Duration // how long a result is kept when it is not downloaded
+	logger  *slog.Logger
+	metrics *metrics
+	pending chan *job
+	wg      sync.WaitGroup
+
+	// ctx is cancelled when close gives up waiting, failing the conversions still running
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	sweep  chan struct{} // closed to stop the sweeper
+}
+
+var (
+	errQueueFull   = errors.New("job queue is full")
+	errQueueClosed = errors.New("job queue is closed")
+)
+
+// newJobQueue starts the workers and a sweeper removing the results of jobs older than ttl.
+// Jobs left unfinished by a previous process are failed and their files removed, as are
+// results in dir that no job owns any more
+func newJobQueue(store *jobStore, dir string, workers, size int, ttl time.Duration, logger *slog.Logger, m *metrics) (*jobQueue, error) {
+	unfinished, err := store.failUnfinished()
+	if err != nil {
+		return nil, err
+	}
+	q := &jobQueue{store: store, dir: dir, ttl: ttl, logger: logger, metrics: m, pending: make(chan *job, size), sweep: make(chan struct{})}
+	for _, j := range unfinished {
+		q.remove(j.Input)
+		q.remove(j.Result)
+	}
+	q.removeOrphans()
+	q.expire(time.Now())
+
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	q.wg.Add(1)
+	go q.sweeper()
+	return q, nil
+}
+
+// submit stores a new job of client converting the SAV file input and queues it. The queue owns
+// input from then on and removes it when the job finishes
+func (q *jobQueue) submit(client, input, format string, labels bool) (*job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	j := &job{ID: id, Client: client, Status: jobQueued, Format: format, Labels: labels, Input: input, Created: now, Updated: now}
+	if err := q.store.insert(j); err != nil {
+		return nil, err
+	}
+
+	queued := *j
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err = errQueueFull
+	if q.closed {
+		err = errQueueClosed
+	} else {
+		select {
+		case q.pending <- j:
+			q.logger.Info("job queued", "job", j.ID, "format", j.Format)
+			return &queued, nil
+		default:
+		}
+	}
+	j.Status, j.Error = jobFailed, err.Error()
+	_ = q.store.update(j)
+	return nil, err
+}
+
+// close stops new jobs being submitted and waits for the workers to finish the jobs already
+// queued. When ctx is done first the running conversions are cancelled, they and the jobs
+// still queued are marked failed
+func (q *jobQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+		close(q.sweep)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *jobQueue) work() {
+	defer q.wg.Done()
+	for j := range q.pending {
+		q.run(j)
+	}
+}
+
+func (q *jobQueue) run(j *job) {
+	defer os.Remove(j.Input)
+
+	if q.ctx.Err() != nil {
+		j.Status, j.Error = jobFailed, "the service shut down"
+		q.save(j)
+		return
+	}
+	j.Status = jobRunning
+	q.save(j)
+
+	t := q.metrics.begin("job", j.Format)
+	err := q.convert(j, t)
+	t.done(err)
+	if err != nil {
+		j.Status, j.Error = jobFailed, err.Error()
+		if q.ctx.Err() != nil {
+			j.Error = "the service shut down"
+		}
+		q.logger.Error("job failed", "job", j.ID, "format", j.Format, "error", err)
+	} else {
+		j.Status, j.Progress = jobDone, 100
+		q.logger.Info("job done", "job", j.ID, "format", j.Format, "rows", t.rows, "duration", time.Since(t.start))
+	}
+	expires := time.Now().UTC().Add(q.ttl)
+	j.Expires = &expires
+	q.save(j)
+}
+
+func (q *jobQueue) convert(j *job, task *task) error {
+	if info, err := os.Stat(j.Input); err == nil {
+		task.bytesIn = info.Size()
+	}
+	// the status is saved at most once a percent or once a second, not for every batch of rows
+	percent, saved := -1, time.Now()
+	progress := func(p spss.Progress) {
+		j.Rows, j.Total, j.Progress = p.Rows, p.Total, p.Percent
+		if int(p.Percent) > percent || time.Since(saved) >= time.Second {
+			percent, saved = int(p.Percent), time.Now()
+			q.save(j)
+		}
+	}
+
+	// every format is streamed from the SAV file, so large files are never held in memory
+	write := func(w io.Writer) (err error) {
+		task.rows, err = convert.ConvertSav(q.ctx, j.Input, w, j.Format, convert.SavOptions{Labels: j.Labels, Progress: progress})
+		return err
+	}
+
+	result := filepath.Join(q.dir, "go-spss-job-"+j.ID+"."+j.Format)
+	f, err := os.Create(result)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(countingWriter{f, &task.bytesOut})
+	err = write(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(result)
+		return err
+	}
+	j.Result = result
+	return nil
+}
+
+func (q *jobQueue) save(j *job) {
+	if err := q.store.update(j); err != nil {
+		q.logger.Error("cannot save job status", "job", j.ID, "error", err)
+	}
+}
+
+// sweeper expires the jobs whose result TTL has run out until the queue is closed
+func (q *jobQueue) sweeper() {
+	defer q.wg.Done()
+	interval := sweepInterval
+	if q.ttl < interval {
+		interval = q.ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.sweep:
+			return
+		case now := <-ticker.C:
+			q.expire(now)
+		}
+	}
+}
+
+// expire removes the results of the jobs whose result TTL ran out before now
+func (q *jobQueue) expire(now time.Time) {
+	jobs, err := q.store.expired(now)
+	if err != nil {
+		q.logger.Error("cannot find expired jobs", "error", err)
+		return
+	}
+	for i := range jobs {
+		q.expireJob(&jobs[i])
+	}
+}
+
+// expireJob removes the result of a finished job, which is then gone for good
+func (q *jobQueue) expireJob(j *job) {
+	q.remove(j.Result)
+	if j.Status == jobDone {
+		j.Status = jobExpired
+	}
+	j.Result, j.Expires = "", nil
+	q.save(j)
+	q.logger.Info("job expired", "job", j.ID, "format", j.Format)
+}
+
+// removeOrphans removes the results in the directory that belong to no finished job, left by a
+// process that stopped while writing them or by jobs since removed from the database
+func (q *jobQueue) removeOrphans() {
+	results, err := filepath.Glob(filepath.Join(q.dir, "go-spss-job-*"))
+	if err != nil {
+		return
+	}
+	for _, result := range results {
+		id := strings.TrimPrefix(filepath.Base(result), "go-spss-job-")
+		id = strings.TrimSuffix(id, filepath.Ext(id))
+		j, err := q.store.get(id)
+		if err == errJobNotFound || err == nil && (j.Status != jobDone || j.Result != result) {
+			q.remove(result)
+		}
+	}
+}
+
+// remove deletes a file of a job, which may already be gone
+func (q *jobQueue) remove(fileName string) {
+	if fileName == "" {
+		return
+	}
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		q.logger.Error("cannot remove job file", "file", fileName, "error", err)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
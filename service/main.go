@@ -1,52 +1,101 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/gorilla/mux"
+	"go-spss/dataset"
 )
 
-type Todo struct {
-	Name      string    `json:"name"`
-	Completed bool      `json:"completed"`
-	Due       time.Time `json:"due"`
-}
-
-type Response struct {
-	Result string `json"result"`
-}
-
 func main() {
-
-	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/", Index)
-	router.HandleFunc("/todos", TodoIndex)
-	router.HandleFunc("/todos/{todoId}", TodoShow)
-
-	log.Fatal(http.ListenAndServe(":8080", router))
+	cfg, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		// loadConfig has already printed the error
+		os.Exit(2)
+	}
+	logger := cfg.newLogger(os.Stdout)
+	if err := run(cfg, logger); err != nil {
+		logger.Error("service stopped", "error", err)
+		os.Exit(1)
+	}
 }
 
-func Index(w http.ResponseWriter, r *http.Request) {
-	response := Response{
-		"All good over here on the server",
+// run serves until SIGTERM or SIGINT, then stops accepting connections and waits up to the
+// shutdown timeout for the requests and conversion jobs in flight
+func run(cfg *config, logger *slog.Logger) error {
+	sess, err := dataset.OpenStore(cfg.database)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sess.Close()
+	}()
+	store, err := newJobStore(sess)
+	if err != nil {
+		return err
+	}
+	var clients []*client
+	if !cfg.noAuth {
+		if clients, err = loadClients(cfg.clients); err != nil {
+			return err
+		}
+	}
+	m := newMetrics()
+	jobs, err := newJobQueue(store, cfg.tempDir, cfg.workers, cfg.queueSize, cfg.resultTTL, logger, m)
+	if err != nil {
+		return err
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		panic(err)
+	srv := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           newServer(logger, int64(cfg.maxUpload), cfg.tempDir, jobs, m, newAuth(clients, store)),
+		ReadHeaderTimeout: cfg.readTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
-}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-func TodoIndex(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "Todo Index!")
-}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", cfg.addr, "workers", cfg.workers, "max_upload", int64(cfg.maxUpload),
+			"temp_dir", cfg.tempDir, "db", cfg.database, "clients", len(clients))
+		serveErr <- srv.ListenAndServe()
+	}()
 
-func TodoShow(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	todoId := vars["todoId"]
-	fmt.Fprintln(w, "Todo show:", todoId)
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	logger.Info("shutting down", "timeout", cfg.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	if jobsErr := jobs.close(shutdownCtx); jobsErr != nil {
+		logger.Error("conversion jobs did not finish", "error", jobsErr)
+		if err == nil {
+			err = jobsErr
+		}
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("stopped")
+	return nil
 }
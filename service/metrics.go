@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collector is a metric that can write itself in the Prometheus text exposition format
+type collector interface {
+	write(w io.Writer)
+}
+
+// counter is a Prometheus counter with labels. Series are keyed by their label values
+type counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, series: make(map[string]float64)}
+}
+
+func (c *counter) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.series[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelText(c.labels, key, ""), formatFloat(c.series[key]))
+	}
+}
+
+// histogram is a Prometheus histogram with labels and fixed buckets
+type histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// durationBuckets are the upper bounds in seconds used for request and conversion durations
+var durationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogram) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelText(h.labels, key, formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelText(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelText(h.labels, key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelText(h.labels, key, ""), s.count)
+	}
+}
+
+// labelText formats the labels of a series, le is the histogram bucket label when not empty
+func labelText(names []string, key, le string) string {
+	var parts []string
+	if len(names) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, name := range names {
+			if i < len(values) {
+				parts = append(parts, name+"="+strconv.Quote(values[i]))
+			}
+		}
+	}
+	if le != "" {
+		parts = append(parts, "le="+strconv.Quote(le))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metrics holds everything exposed on /metrics
+type metrics struct {
+	collectors []collector
+
+	requests        *counter
+	requestDuration *histogram
+	files           *counter
+	rows            *counter
+	bytesIn         *counter
+	bytesOut        *counter
+	errors          *counter
+	duration        *histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		requests:        newCounter("gospss_http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: newHistogram("gospss_http_request_duration_seconds", "HTTP request durations by route.", durationBuckets, "route"),
+		files:           newCounter("gospss_files_total", "Files processed successfully by operation and format.", "operation", "format"),
+		rows:            newCounter("gospss_rows_total", "Rows processed by operation and format.", "operation", "format"),
+		bytesIn:         newCounter("gospss_read_bytes_total", "Bytes uploaded by operation and format.", "operation", "format"),
+		bytesOut:        newCounter("gospss_written_bytes_total", "Bytes produced by operation and format.", "operation", "format"),
+		errors:          newCounter("gospss_errors_total", "Files that failed by operation, format and error code.", "operation", "format", "code"),
+		duration:        newHistogram("gospss_duration_seconds", "Time taken to process a file by operation and format.", durationBuckets, "operation", "format"),
+	}
+	m.collectors = []collector{m.requests, m.requestDuration, m.files, m.rows, m.bytesIn, m.bytesOut, m.errors, m.duration}
+	return m
+}
+
+func (m *metrics) write(w io.Writer) {
+	for _, c := range m.collectors {
+		c.write(w)
+	}
+}
+
+// task is a single file processed by an endpoint or a job. The handler fills in the counts as
+// it goes and calls done when it returns
+type task struct {
+	metrics   *metrics
+	operation string
+	format    string
+	start     time.Time
+	rows      int
+	bytesIn   int64
+	bytesOut  int64
+}
+
+func (m *metrics) begin(operation, format string) *task {
+	return &task{metrics: m, operation: operation, format: format, start: time.Now()}
+}
+
+func (t *task) done(err error) {
+	m := t.metrics
+	if err != nil {
+		m.errors.add(1, t.operation, t.format, toAPIError(err).Code)
+		return
+	}
+	m.files.add(1, t.operation, t.format)
+	m.rows.add(float64(t.rows), t.operation, t.format)
+	m.bytesIn.add(float64(t.bytesIn), t.operation, t.format)
+	m.bytesOut.add(float64(t.bytesOut), t.operation, t.format)
+	m.duration.observe(time.Since(t.start).Seconds(), t.operation, t.format)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// server is the SAV conversion HTTP API
+type server struct {
+	router    *mux.Router
+	logger    *slog.Logger
+	maxUpload int64
+	tempDir   string
+	jobs      *jobQueue
+	metrics   *metrics
+	auth      *auth
+}
+
+// publicRoutes can be requested without credentials, they hold no survey data
+var publicRoutes = map[string]bool{"/": true, "/healthz": true, "/readyz": true, "/metrics": true}
+
+// newServer returns the API. With a nil auth every endpoint is open
+func newServer(logger *slog.Logger, maxUpload int64, tempDir string, jobs *jobQueue, m *metrics, a *auth) *server {
+	s := &server{
+		router:    mux.NewRouter().StrictSlash(true),
+		logger:    logger,
+		maxUpload: maxUpload,
+		tempDir:   tempDir,
+		jobs:      jobs,
+		metrics:   m,
+		auth:      a,
+	}
+	s.routes()
+	return s
+}
+
+func (s *server) routes() {
+	s.router.HandleFunc("/", s.handle(s.index)).Methods(http.MethodGet)
+	s.router.HandleFunc("/healthz", s.handle(s.healthz)).Methods(http.MethodGet)
+	s.router.HandleFunc("/readyz", s.handle(s.readyz)).Methods(http.MethodGet)
+	s.router.HandleFunc("/metrics", s.handle(s.metricsHandler)).Methods(http.MethodGet)
+	s.router.HandleFunc("/dictionary", s.handle(s.dictionary)).Methods(http.MethodPost)
+	s.router.HandleFunc("/convert/{format}", s.handle(s.convert)).Methods(http.MethodPost)
+	s.router.HandleFunc("/sav", s.handle(s.sav)).Methods(http.MethodPost)
+	s.router.HandleFunc("/jobs", s.handle(s.submitJob)).Methods(http.MethodPost)
+	s.router.HandleFunc("/jobs/{id}", s.handle(s.jobStatus)).Methods(http.MethodGet)
+	s.router.HandleFunc("/jobs/{id}/result", s.handle(s.jobResult)).Methods(http.MethodGet)
+	s.router.Use(s.instrument, s.authenticate)
+
+	// the router only runs its middleware on matched routes
+	s.router.NotFoundHandler = s.instrument(s.handle(func(w http.ResponseWriter, r *http.Request) error {
+		return errorf(http.StatusNotFound, "not_found", "no endpoint %s %s", r.Method, r.URL.Path)
+	}))
+	s.router.MethodNotAllowedHandler = s.instrument(s.handle(func(w http.ResponseWriter, r *http.Request) error {
+		return errorf(http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed on %s", r.Method, r.URL.Path)
+	}))
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// handlerFunc is an http.HandlerFunc that returns its error instead of writing it
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// handle limits the size of the request body and writes any error returned by h as JSON
+func (s *server) handle(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+		if err := h(w, r); err != nil {
+			s.writeError(w, r, err)
+		}
+	}
+}
+
+// instrument records every request in the metrics and logs it
+func (s *server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start)
+		route := routeName(r)
+		s.metrics.requests.add(1, route, r.Method, strconv.Itoa(rec.status))
+		s.metrics.requestDuration.observe(elapsed.Seconds(), route)
+		s.logger.Info("request", "method", r.Method, "path", r.URL.Path, "route", route,
+			"status", rec.status, "bytes", rec.bytes, "duration", elapsed, "remote", r.RemoteAddr)
+	})
+}
+
+// routeName is the path template of the route r matched, so requests for different jobs are
+// counted together
+func routeName(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// statusRecorder remembers the status code and number of bytes written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	spss "go-spss"
+	"go-spss/convert"
+	"upper.io/db.v3/sqlite"
+)
+
+func newTestServer(t *testing.T, maxUpload int64) *server {
+	dir, err := ioutil.TempDir("", "go-spss-service")
+	if err != nil {
+		panic(err)
+	}
+	sess, err := sqlite.Open(sqlite.ConnectionURL{Database: filepath.Join(dir, "test.db")})
+	if err != nil {
+		panic(err)
+	}
+	t.Cleanup(func() {
+		_ = sess.Close()
+		_ = os.RemoveAll(dir)
+	})
+
+	logger := slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	store, err := newJobStore(sess)
+	if err != nil {
+		panic(err)
+	}
+	m := newMetrics()
+	jobs, err := newJobQueue(store, dir, 1, 2, time.Hour, logger, m)
+	if err != nil {
+		panic(err)
+	}
+	return newServer(logger, maxUpload, dir, jobs, m, nil)
+}
+
+func testSav() []byte {
+	headers := []spss.Header{
+		{SavType: spss.ReadstatTypeDouble, Name: "Serial", Label: "Serial number"},
+		{SavType: spss.ReadstatTypeString, Name: "Version", Label: "Version"},
+	}
+	data := []spss.DataItem{
+		{Value: []interface{}{1.0, "v1"}},
+		{Value: []interface{}{2.5, "v2, b"}},
+	}
+	if err := spss.Export("test_service.sav", "service", headers, data); err != nil {
+		panic(err)
+	}
+	defer os.Remove("test_service.sav")
+
+	b, err := ioutil.ReadFile("test_service.sav")
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func multipartBody(parts map[string]string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range parts {
+		fileName := name + ".csv"
+		if name == "file" {
+			fileName = "upload.sav"
+		}
+		w, err := mw.CreateFormFile(name, fileName)
+		if err != nil {
+			panic(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	_ = mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Errorf("error response is not JSON: %v", err)
+	}
+	return body.Error.Code
+}
+
+func Test_dictionary(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	body, contentType := multipartBody(map[string]string{"file": string(testSav())})
+	req := httptest.NewRequest(http.MethodPost, "/dictionary", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /dictionary returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusOK)
+	}
+	var dict convert.Dictionary
+	if err := json.NewDecoder(rec.Body).Decode(&dict); err != nil {
+		panic(err)
+	}
+	if dict.Rows != 2 || len(dict.Variables) != 2 || dict.Variables[0].Label != "Serial number" || dict.Variables[1].Type != convert.String {
+		t.Errorf("POST /dictionary returned the wrong dictionary, got: %+v", dict)
+	}
+}
+
+func Test_convert(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	req := httptest.NewRequest(http.MethodPost, "/convert/csv", bytes.NewReader(testSav()))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	want := "Serial,Version\n1,v1\n2.5,\"v2, b\"\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("POST /convert/csv returned the wrong body, got: %d %q, want: 200 %q.", rec.Code, rec.Body.String(), want)
+	}
+
+	// the conversion stops when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req = httptest.NewRequest(http.MethodPost, "/convert/json", bytes.NewReader(testSav())).WithContext(ctx)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK || strings.Contains(rec.Body.String(), "v1") {
+		t.Errorf("POST /convert/json did not stop when the request was cancelled, got: %d %q.", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/convert/parquet", bytes.NewReader(testSav()))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if body := rec.Body.Bytes(); rec.Code != http.StatusOK || len(body) < 8 || string(body[:4]) != "PAR1" || string(body[len(body)-4:]) != "PAR1" {
+		t.Errorf("POST /convert/parquet did not return a parquet file, got: %d %q.", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/convert/ndjson?dictionary=true", bytes.NewReader(testSav()))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if lines := strings.Split(rec.Body.String(), "\n"); rec.Code != http.StatusOK || len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], `{"$dictionary":`) || lines[1] != `{"Serial":1,"Version":"v1"}` {
+		t.Errorf("POST /convert/ndjson returned the wrong body, got: %d %q.", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/convert/xlsx", bytes.NewReader(testSav()))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if body := rec.Body.Bytes(); rec.Code != http.StatusOK || len(body) < 4 || string(body[:4]) != "PK\x03\x04" {
+		t.Errorf("POST /convert/xlsx did not return a workbook, got: %d %q.", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/convert/dta", bytes.NewReader(testSav()))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.HasPrefix(body, "<stata_dta><header><release>118</release>") {
+		t.Errorf("POST /convert/dta did not return a Stata file, got: %d %q.", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/convert/sas7bdat", bytes.NewReader(testSav()))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType || errorCode(t, rec) != "unsupported_format" {
+		t.Errorf("POST /convert/sas7bdat returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusUnsupportedMediaType)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/convert/json", strings.NewReader("not a sav file"))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity || errorCode(t, rec) != "invalid_sav" {
+		t.Errorf("POST /convert/json of an invalid file returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func Test_sav(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	body, contentType := multipartBody(map[string]string{
+		"data": "name,code,age\nAnn,01,34\nBob,02,-9\n",
+		"dictionary": `{"file_label": "people", "variables": [
+			{"name": "code", "label": "Code", "type": "string", "value_labels": [{"value": "01", "label": "First"}]},
+			{"name": "age", "type": "numeric", "format": "F3.0", "measure": "scale",
+				"value_labels": [{"value": -9, "label": "Refused"}], "missing": [{"lo": -9, "hi": -9}]}
+		]}`,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/sav", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /sav returned the wrong status, got: %d %s, want: %d.", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	fileName := filepath.Join(t.TempDir(), "test_service_out.sav")
+	if err := ioutil.WriteFile(fileName, rec.Body.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+
+	table, err := convert.ReadSav(fileName, false)
+	if err != nil {
+		panic(err)
+	}
+	if table.Label != "people" || table.Headers[0].Label != "Code" || table.Rows[1][0] != "02" {
+		t.Errorf("POST /sav returned the wrong file, got: %+v %v", table.Headers, table.Rows)
+	}
+
+	meta, err := spss.Inspect(fileName)
+	if err != nil {
+		panic(err)
+	}
+	code, _ := meta.Variable("code")
+	age, _ := meta.Variable("age")
+	if !reflect.DeepEqual(code.ValueLabels, []spss.ValueLabel{{Value: "01", Label: "First"}}) ||
+		!reflect.DeepEqual(age.ValueLabels, []spss.ValueLabel{{Value: -9.0, Label: "Refused"}}) ||
+		!reflect.DeepEqual(age.Missing, []spss.MissingRange{{Lo: -9.0, Hi: -9.0}}) ||
+		age.Format != "F3" || age.Measure != spss.MeasureScale {
+		t.Errorf("POST /sav did not write the dictionary, got: %+v %+v", code, age)
+	}
+}
+
+func Test_requestTooLarge(t *testing.T) {
+
+	s := newTestServer(t, 64)
+	req := httptest.NewRequest(http.MethodPost, "/dictionary", bytes.NewReader(testSav()))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge || errorCode(t, rec) != "too_large" {
+		t.Errorf("POST /dictionary of a large file returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func Test_jobs(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	req := httptest.NewRequest(http.MethodPost, "/jobs?format=json", bytes.NewReader(testSav()))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs returned the wrong status, got: %d %s, want: %d.", rec.Code, rec.Body.String(), http.StatusAccepted)
+	}
+	var j job
+	if err := json.NewDecoder(rec.Body).Decode(&j); err != nil {
+		panic(err)
+	}
+	if rec.Header().Get("Location") != "/jobs/"+j.ID {
+		t.Errorf("POST /jobs returned the wrong location, got: %s", rec.Header().Get("Location"))
+	}
+
+	for i := 0; i < 100 && j.Status != jobDone && j.Status != jobFailed; i++ {
+		time.Sleep(10 * time.Millisecond)
+		rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+j.ID, nil))
+		if err := json.NewDecoder(rec.Body).Decode(&j); err != nil {
+			panic(err)
+		}
+	}
+	if j.Status != jobDone || j.Progress != 100 {
+		t.Fatalf("GET /jobs/{id} did not finish, got: %+v", j)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+j.ID+"/result", nil))
+	want := "[\n  {\"Serial\": 1, \"Version\": \"v1\"},\n  {\"Serial\": 2.5, \"Version\": \"v2, b\"}\n]\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("GET /jobs/{id}/result returned the wrong body, got: %d %q, want: 200 %q.", rec.Code, rec.Body.String(), want)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil))
+	if rec.Code != http.StatusNotFound || errorCode(t, rec) != "not_found" {
+		t.Errorf("GET /jobs/{id} of an unknown job returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusNotFound)
+	}
+}
+
+func Test_health(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"ok"`) {
+			t.Errorf("GET %s returned the wrong status, got: %d %s, want: %d.", path, rec.Code, rec.Body.String(), http.StatusOK)
+		}
+	}
+
+	if _, err := s.jobs.store.db.Exec("drop table readiness"); err != nil {
+		panic(err)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz with a broken store returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func Test_metrics(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/convert/csv", bytes.NewReader(testSav())))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/convert/json", strings.NewReader("not a sav file")))
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`gospss_files_total{operation="convert",format="csv"} 1`,
+		`gospss_rows_total{operation="convert",format="csv"} 2`,
+		`gospss_errors_total{operation="convert",format="json",code="invalid_sav"} 1`,
+		`gospss_duration_seconds_count{operation="convert",format="csv"} 1`,
+		`gospss_http_requests_total{route="/convert/{format}",method="POST",code="422"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET /metrics is missing a series, got: %s, want: %s.", rec.Body.String(), want)
+		}
+	}
+}
+
+func Test_shutdown(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	req := httptest.NewRequest(http.MethodPost, "/jobs?format=csv", bytes.NewReader(testSav()))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	var j job
+	if err := json.NewDecoder(rec.Body).Decode(&j); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.jobs.close(ctx); err != nil {
+		t.Fatalf("close did not drain the queue: %v", err)
+	}
+	finished, err := s.jobs.store.get(j.ID)
+	if err != nil {
+		panic(err)
+	}
+	if finished.Status != jobDone {
+		t.Errorf("close did not finish the queued job, got: %+v", finished)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/jobs?format=csv", bytes.NewReader(testSav()))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || errorCode(t, rec) != "shutting_down" {
+		t.Errorf("POST /jobs after close returned the wrong status, got: %d, want: %d.", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func Test_expiry(t *testing.T) {
+
+	s := newTestServer(t, 1<<20)
+	var jobs []job
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs?format=csv", bytes.NewReader(testSav())))
+		var j job
+		if err := json.NewDecoder(rec.Body).Decode(&j); err != nil {
+			panic(err)
+		}
+		jobs = append(jobs, j)
+	}
+	if err := s.jobs.close(context.Background()); err != nil {
+		panic(err)
+	}
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+jobs[0].ID+"/result", nil))
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusGone {
+		t.Errorf("a downloaded result was not removed, got: %v", codes)
+	}
+
+	done, err := s.jobs.store.get(jobs[1].ID)
+	if err != nil {
+		panic(err)
+	}
+	s.jobs.expire(time.Now().Add(30 * time.Minute))
+	if j, _ := s.jobs.store.get(jobs[1].ID); j.Status != jobDone {
+		t.Errorf("a job expired before its TTL, got: %+v", j)
+	}
+	s.jobs.expire(time.Now().Add(2 * time.Hour))
+	if j, _ := s.jobs.store.get(jobs[1].ID); j.Status != jobExpired {
+		t.Errorf("a job did not expire after its TTL, got: %+v", j)
+	}
+	if _, err := os.Stat(done.Result); !os.IsNotExist(err) {
+		t.Errorf("the result of an expired job was not removed: %v", err)
+	}
+
+	orphan := filepath.Join(s.tempDir, "go-spss-job-orphan.csv")
+	if err := ioutil.WriteFile(orphan, []byte("Serial\n"), 0666); err != nil {
+		panic(err)
+	}
+	q, err := newJobQueue(s.jobs.store, s.tempDir, 1, 2, time.Hour, s.logger, s.metrics)
+	if err != nil {
+		panic(err)
+	}
+	defer q.close(context.Background())
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("a result left by a previous process was not removed: %v", err)
+	}
+}
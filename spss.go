@@ -1,5 +1,7 @@
 package spss
 
+import "context"
+
 /*
 Use of this source code is governed by a MIT license
 The license can be found in the LICENSE file.
@@ -28,6 +30,19 @@ func ReadFromSPSSFile(in string, out interface{}) error {
 	return spssReader(in).Read(out)
 }
 
+// ReadContext is ReadFromSPSSFile with cancellation, progress reporting and options. Readers that
+// do not implement ContextReader are only checked for cancellation before the read starts
+func ReadContext(ctx context.Context, in string, out interface{}, progress ProgressFunc, opts ...Option) error {
+	reader := spssReader(in)
+	if r, ok := reader.(ContextReader); ok {
+		return r.ReadContext(ctx, out, progress, opts...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return reader.Read(out)
+}
+
 func SetSPSSWriter(writer func(interface{}) Writer) {
 	spssWriter = writer
 }
@@ -39,3 +54,16 @@ func DefaultSPSSWriter(in interface{}) Writer {
 func WriteToSPSSFile(out string, in interface{}) error {
 	return spssWriter(out).Write(in)
 }
+
+// WriteContext is WriteToSPSSFile with cancellation, progress reporting and options. Writers that
+// do not implement ContextWriter are only checked for cancellation before the write starts
+func WriteContext(ctx context.Context, out string, in interface{}, progress ProgressFunc, opts ...Option) error {
+	writer := spssWriter(out)
+	if w, ok := writer.(ContextWriter); ok {
+		return w.WriteContext(ctx, in, progress, opts...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return writer.Write(in)
+}